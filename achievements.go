@@ -0,0 +1,623 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Achievement scopes.
+// Game achievements are evaluated against the stats of a player in a game,
+// lifetime achievements against the stats accumulated over all the games
+// of the player, and team achievements against the results of a team and
+// the stats of its members in a game (see team_achievements.go).
+const (
+	ScopeGame     = "game"
+	ScopeLifetime = "lifetime"
+	ScopeTeam     = "team"
+)
+
+// AchievementRule defines an achievement as data.
+// A rule is either:
+//   - a single achievement, reached when its condition, an expression over
+//     stats and derived stats (see statsVariables), is true
+//   - a progressive achievement, made up of tiers reached when its metric,
+//     an expression over stats and derived stats, is greater than or equal
+//     to the tier threshold
+//
+// Live rules are also evaluated each time a stat is incremented, so players
+// can unlock them during a game. Other rules are only evaluated when a
+// game stops.
+//
+// Name and Description are the default (English) texts, Names and
+// Descriptions their translations indexed by language (e.g. fr).
+// Hidden achievements are kept secret in the catalog until unlocked.
+type AchievementRule struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Description  string            `json:"description"`
+	Names        map[string]string `json:"names,omitempty"`
+	Descriptions map[string]string `json:"descriptions,omitempty"`
+	Icon         string            `json:"icon,omitempty"`
+	Hidden       bool              `json:"hidden,omitempty"`
+	Condition    string            `json:"condition,omitempty"`
+	Metric       string            `json:"metric,omitempty"`
+	Tiers        []Tier            `json:"tiers,omitempty"`
+	Scope        string            `json:"scope"`
+	Live         bool              `json:"live,omitempty"`
+
+	condition Expr
+	metric    Expr
+}
+
+// Tier is a level of a progressive achievement (e.g. bronze, silver, gold).
+// The tier of a rule with a single tier can be unnamed.
+type Tier struct {
+	Name      string  `json:"name,omitempty"`
+	Threshold float64 `json:"threshold"`
+}
+
+// Compile checks the rule and compiles its condition or metric
+func (r *AchievementRule) Compile() error {
+	if r.ID == "" || r.Name == "" {
+		return fmt.Errorf("id and name are mandatory")
+	}
+	if strings.Contains(r.ID, ":") {
+		return fmt.Errorf("id should not contain a colon")
+	}
+	if r.Scope != ScopeGame && r.Scope != ScopeLifetime && r.Scope != ScopeTeam {
+		return fmt.Errorf("scope should be %s, %s or %s", ScopeGame, ScopeLifetime, ScopeTeam)
+	}
+	if r.Live && r.Scope == ScopeTeam {
+		return fmt.Errorf("team achievements cannot be live")
+	}
+	if (r.Condition == "") == (r.Metric == "") {
+		return fmt.Errorf("either a condition or a metric is required")
+	}
+
+	var err error
+	if r.Condition != "" {
+		if len(r.Tiers) > 0 {
+			return fmt.Errorf("tiers require a metric instead of a condition")
+		}
+		r.condition, err = compileExpr(r.Condition, r.Scope)
+		if err != nil {
+			return fmt.Errorf("malformed condition: %v", err)
+		}
+		r.metric = nil
+		return nil
+	}
+
+	if len(r.Tiers) == 0 {
+		return fmt.Errorf("a metric requires tiers")
+	}
+	names := map[string]bool{}
+	for i, t := range r.Tiers {
+		if t.Name == "" && len(r.Tiers) > 1 {
+			return fmt.Errorf("tiers should be named")
+		}
+		if names[t.Name] {
+			return fmt.Errorf("tier %q is defined twice", t.Name)
+		}
+		names[t.Name] = true
+		if i > 0 && t.Threshold <= r.Tiers[i-1].Threshold {
+			return fmt.Errorf("tier thresholds should be increasing")
+		}
+	}
+	r.metric, err = compileExpr(r.Metric, r.Scope)
+	if err != nil {
+		return fmt.Errorf("malformed metric: %v", err)
+	}
+	r.condition = nil
+	return nil
+}
+
+// compileExpr parses an expression and checks that it only uses
+// variables known in the scope provided
+func compileExpr(s string, scope string) (Expr, error) {
+	e, err := ParseExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	known := statsVariables(Stats{})
+	switch scope {
+	case ScopeLifetime:
+		known = lifetimeVariables(Stats{}, Streaks{}, Progression{})
+	case ScopeTeam:
+		known = teamVariables(Team{}, Team{}, nil)
+	}
+	for _, v := range exprVariables(e) {
+		if _, ok := known[v]; !ok {
+			return nil, fmt.Errorf("unknown variable %q", v)
+		}
+	}
+	return e, nil
+}
+
+// TierID returns the id of the achievement matching a tier of the rule:
+// the rule id followed by the tier name (e.g. veteran:gold), or the rule
+// id alone for an unnamed tier
+func (r *AchievementRule) TierID(t Tier) string {
+	if t.Name == "" {
+		return r.ID
+	}
+	return r.ID + ":" + t.Name
+}
+
+// AchievementIDs returns the ids of all the achievements defined by the rule
+func (r *AchievementRule) AchievementIDs() []string {
+	if r.condition != nil {
+		return []string{r.ID}
+	}
+	var ids []string
+	for _, t := range r.Tiers {
+		ids = append(ids, r.TierID(t))
+	}
+	return ids
+}
+
+// Evaluate returns the achievements defined by the rule, reached or not,
+// with the variables provided.
+// The rule id alone is also set, and tells whether any tier is reached, so
+// that clients knowing achievements from before tiers (e.g. veteran) keep
+// working.
+func (r *AchievementRule) Evaluate(vars map[string]float64) Achievements {
+	if r.condition != nil {
+		return Achievements{r.ID: r.condition.Eval(vars) != 0}
+	}
+	a := Achievements{}
+	v := r.metric.Eval(vars)
+	for _, t := range r.Tiers {
+		a[r.TierID(t)] = v >= t.Threshold
+	}
+	a[r.ID] = v >= r.Tiers[0].Threshold
+	return a
+}
+
+// statsVariables returns the variables achievement conditions can use:
+// every stat (named after its json name) and some derived stats
+func statsVariables(s Stats) map[string]float64 {
+	vars := map[string]float64{
+		"nbAttemptedAttacks":       float64(s.NbAttemptedAttacks),
+		"nbHits":                   float64(s.NbHits),
+		"damageDone":               float64(s.DamageDone),
+		"nbKills":                  float64(s.NbKills),
+		"nbFirstHitKills":          float64(s.NbFirstHitKills),
+		"nbAssists":                float64(s.NbAssists),
+		"nbSpellCasts":             float64(s.NbSpellCasts),
+		"spellDamageDone":          float64(s.SpellDamageDone),
+		"totalTimePlayedInSeconds": float64(s.TotalTimePlayedInSeconds),
+		"totalNbGamesPlayed":       float64(s.TotalNbGamesPlayed),
+		"totalNbGamesWins":         float64(s.TotalNbWins),
+	}
+
+	// Derived stats
+	vars["totalDamage"] = vars["damageDone"] + vars["spellDamageDone"]
+	vars["accuracy"] = ratio(vars["nbHits"], vars["nbAttemptedAttacks"])
+	vars["firstHitKillRate"] = ratio(vars["nbFirstHitKills"], vars["nbKills"])
+	vars["winRate"] = ratio(vars["totalNbGamesWins"], vars["totalNbGamesPlayed"])
+	return vars
+}
+
+// ratio divides x by y, or returns 0 if y is 0
+func ratio(x, y float64) float64 {
+	if y == 0 {
+		return 0
+	}
+	return x / y
+}
+
+// RulesEngine calculates achievements from a list of achievement rules.
+// It implements the AchievementsCalculator interface.
+type RulesEngine struct {
+	Rules []AchievementRule
+}
+
+// Calculate returns the achievements of the scope provided, reached or not,
+// with the stats provided
+func (e *RulesEngine) Calculate(scope string, stats Stats) Achievements {
+	return e.Evaluate(scope, statsVariables(stats))
+}
+
+// Evaluate returns the achievements of the scope provided, reached or not,
+// with the variables provided
+func (e *RulesEngine) Evaluate(scope string, vars map[string]float64) Achievements {
+	a := Achievements{}
+	for _, r := range e.Rules {
+		if r.Scope != scope {
+			continue
+		}
+		for id, ok := range r.Evaluate(vars) {
+			a[id] = ok
+		}
+	}
+	return a
+}
+
+// Rule returns the rule matching the id provided, or nil if not found
+func (e *RulesEngine) Rule(id string) *AchievementRule {
+	for i := range e.Rules {
+		if e.Rules[i].ID == id {
+			return &e.Rules[i]
+		}
+	}
+	return nil
+}
+
+// SetRule compiles the rule provided and adds it to the rules, or replaces
+// the rule with the same id
+func (e *RulesEngine) SetRule(rule AchievementRule) {
+	rule.Compile()
+	if existing := e.Rule(rule.ID); existing != nil {
+		*existing = rule
+		return
+	}
+	e.Rules = append(e.Rules, rule)
+}
+
+// DeleteRule removes the rule matching the id provided
+func (e *RulesEngine) DeleteRule(id string) {
+	for i := range e.Rules {
+		if e.Rules[i].ID == id {
+			e.Rules = append(e.Rules[:i], e.Rules[i+1:]...)
+			return
+		}
+	}
+}
+
+// defaultAchievementRules returns the achievements available out of the box
+func defaultAchievementRules() []AchievementRule {
+	rules := []AchievementRule{
+		{ID: "sharpshooter", Name: "Sharpshooter", Description: "Land at least 75% of your attacks in a game", Condition: "nbHits > 0 && accuracy >= 0.75", Scope: ScopeGame},
+		{ID: "bruiser", Name: "Bruiser", Description: "Deal at least 500 damage in a game", Metric: "totalDamage", Tiers: []Tier{{Threshold: 500}}, Scope: ScopeGame, Live: true},
+		{ID: "veteran", Name: "Veteran", Description: "Play 100, 500 and 1000 games", Metric: "totalNbGamesPlayed", Tiers: []Tier{{"bronze", 100}, {"silver", 500}, {"gold", 1000}}, Scope: ScopeLifetime},
+		{ID: "bigWinner", Name: "Big Winner", Description: "Win 50, 100 and 200 games", Metric: "totalNbGamesWins", Tiers: []Tier{{"bronze", 50}, {"silver", 100}, {"gold", 200}}, Scope: ScopeLifetime},
+		{ID: "onFire", Name: "On Fire", Description: "Win 3, 5 and 10 games in a row", Metric: "winStreak", Tiers: []Tier{{"bronze", 3}, {"silver", 5}, {"gold", 10}}, Scope: ScopeLifetime},
+		{ID: "executioner", Name: "Executioner", Description: "Get a first hit kill in 3 games in a row", Metric: "firstHitKillStreak", Tiers: []Tier{{Threshold: 3}}, Scope: ScopeLifetime},
+		{ID: "dedicated", Name: "Dedicated", Description: "Play at least one game a day for 7 days in a row", Metric: "dayStreak", Tiers: []Tier{{Threshold: 7}}, Scope: ScopeLifetime},
+		{ID: "seasoned", Name: "Seasoned", Description: "Reach levels 10, 25 and 50", Metric: "level", Tiers: []Tier{{"bronze", 10}, {"silver", 25}, {"gold", 50}}, Scope: ScopeLifetime},
+		{ID: "unstoppable", Name: "Unstoppable", Description: "Win 10 games in a row as a team", Metric: "winStreak", Tiers: []Tier{{Threshold: 10}}, Scope: ScopeTeam},
+		{ID: "flawlessVictory", Name: "Flawless Victory", Description: "Win a game without any member of the team being killed", Condition: "won && opponentNbKills == 0", Scope: ScopeTeam},
+		{ID: "teamwork", Name: "Teamwork", Description: "Every member of the team gets a kill in a game", Condition: "nbMembers > 0 && nbMembersWithKill == nbMembers", Scope: ScopeTeam},
+	}
+	for i := range rules {
+		rules[i].Icon = rules[i].ID
+		rules[i].Hidden = rules[i].ID == "flawlessVictory"
+		for lang, translations := range defaultTranslations {
+			if tr, ok := translations[rules[i].ID]; ok {
+				if rules[i].Names == nil {
+					rules[i].Names = map[string]string{}
+					rules[i].Descriptions = map[string]string{}
+				}
+				rules[i].Names[lang] = tr[0]
+				rules[i].Descriptions[lang] = tr[1]
+			}
+		}
+		if err := rules[i].Compile(); err != nil {
+			panic(err)
+		}
+	}
+	return rules
+}
+
+// defaultTranslations holds the names and descriptions of the default
+// achievements, indexed by language and achievement rule id
+var defaultTranslations = map[string]map[string][2]string{
+	"fr": {
+		"sharpshooter":    {"Tireur d'élite", "Réussir au moins 75% de ses attaques dans une partie"},
+		"bruiser":         {"Cogneur", "Infliger au moins 500 dégâts dans une partie"},
+		"veteran":         {"Vétéran", "Jouer 100, 500 et 1000 parties"},
+		"bigWinner":       {"Grand gagnant", "Gagner 50, 100 et 200 parties"},
+		"onFire":          {"En feu", "Gagner 3, 5 et 10 parties d'affilée"},
+		"executioner":     {"Exécuteur", "Tuer d'un seul coup dans 3 parties d'affilée"},
+		"dedicated":       {"Assidu", "Jouer au moins une partie par jour pendant 7 jours d'affilée"},
+		"seasoned":        {"Aguerri", "Atteindre les niveaux 10, 25 et 50"},
+		"unstoppable":     {"Inarrêtable", "Gagner 10 parties d'affilée en équipe"},
+		"flawlessVictory": {"Victoire parfaite", "Gagner une partie sans qu'aucun membre de l'équipe ne soit tué"},
+		"teamwork":        {"Esprit d'équipe", "Chaque membre de l'équipe tue au moins un adversaire dans une partie"},
+	},
+}
+
+// LifetimeStats returns the stats of a player accumulated over all his
+// stopped games
+func (w *World) LifetimeStats(playerID string) Stats {
+	return w.Lifetime[playerID]
+}
+
+// PlayerAchievements calculates the achievements of a player of a stopped game:
+// game achievements from his stats in this game and lifetime achievements
+// from the lifetime variables he had once this game was over
+func (w *World) PlayerAchievements(stats Stats, lifetime map[string]float64) Achievements {
+	a := w.Rules.Calculate(ScopeGame, stats)
+	for id, ok := range w.Rules.Evaluate(ScopeLifetime, lifetime) {
+		a[id] = ok
+	}
+	return a
+}
+
+// AchievementProgress reports how close a player is to reaching the next tier
+// of an achievement rule, from the tier he reached.
+// For game achievements, the value is the best one reached in a single game.
+// Achievements defined by a condition have a value of 1 once reached, 0 otherwise.
+type AchievementProgress struct {
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	Scope         string  `json:"scope"`
+	Value         float64 `json:"value"`
+	Tier          string  `json:"tier,omitempty"`
+	NextTier      string  `json:"nextTier,omitempty"`
+	NextThreshold float64 `json:"nextThreshold,omitempty"`
+	Percentage    float64 `json:"percentage"`
+	Completed     bool    `json:"completed"`
+}
+
+// Progress reports the progress of a player for every player achievement rule
+func (w *World) Progress(playerID string) []AchievementProgress {
+	lifetimeVars := w.LifetimeVariables(playerID, Stats{})
+	var gamesVars []map[string]float64
+	for _, g := range w.PlayerGames(playerID) {
+		gamesVars = append(gamesVars, statsVariables(g.Player(playerID).Stats))
+	}
+
+	progress := []AchievementProgress{}
+	for _, r := range w.Rules.Rules {
+		if r.Scope == ScopeTeam {
+			continue
+		}
+		e := r.metric
+		if r.condition != nil {
+			e = r.condition
+		}
+
+		// Evaluate the rule, keeping the best game for game achievements
+		var value float64
+		if r.Scope == ScopeLifetime {
+			value = e.Eval(lifetimeVars)
+		}
+		for i, vars := range gamesVars {
+			if v := e.Eval(vars); r.Scope == ScopeGame && (i == 0 || v > value) {
+				value = v
+			}
+		}
+
+		tiers := r.Tiers
+		if r.condition != nil {
+			value = boolToFloat(value != 0)
+			tiers = []Tier{{Threshold: 1}}
+		}
+
+		// The percentage is measured from the threshold of the tier reached
+		ap := AchievementProgress{ID: r.ID, Name: r.Name, Scope: r.Scope, Value: value, Completed: true, Percentage: 100}
+		from := 0.0
+		for _, t := range tiers {
+			if value >= t.Threshold {
+				ap.Tier = t.Name
+				from = t.Threshold
+				continue
+			}
+			ap.NextTier = t.Name
+			ap.NextThreshold = t.Threshold
+			ap.Completed = false
+			ap.Percentage = math.Max(0, (value-from)/(t.Threshold-from)*100)
+			break
+		}
+		progress = append(progress, ap)
+	}
+	return progress
+}
+
+// ruleFromForm fills in a rule from the parameters of a request.
+// Parameters that are not provided are left untouched, except that providing
+// a condition removes the metric and tiers, and providing a metric removes
+// the condition.
+// Tiers are provided as a comma separated list of name:threshold pairs
+// (e.g. bronze:100,silver:500,gold:1000), or as a single threshold.
+func ruleFromForm(r *http.Request, rule *AchievementRule) error {
+	for param, field := range map[string]*string{
+		"id":          &rule.ID,
+		"name":        &rule.Name,
+		"description": &rule.Description,
+		"icon":        &rule.Icon,
+		"scope":       &rule.Scope,
+	} {
+		if v := r.Form.Get(param); v != "" {
+			*field = v
+		}
+	}
+
+	// Translations are provided as name.<lang> and description.<lang>
+	// parameters (e.g. name.fr). Maps are copied so that an invalid update
+	// leaves the rule untouched.
+	names := map[string]string{}
+	for lang, name := range rule.Names {
+		names[lang] = name
+	}
+	descriptions := map[string]string{}
+	for lang, description := range rule.Descriptions {
+		descriptions[lang] = description
+	}
+	for param := range r.Form {
+		if strings.HasPrefix(param, "name.") {
+			names[strings.TrimPrefix(param, "name.")] = r.Form.Get(param)
+		}
+		if strings.HasPrefix(param, "description.") {
+			descriptions[strings.TrimPrefix(param, "description.")] = r.Form.Get(param)
+		}
+	}
+	if len(names) > 0 {
+		rule.Names = names
+	}
+	if len(descriptions) > 0 {
+		rule.Descriptions = descriptions
+	}
+
+	if v := r.Form.Get("hidden"); v != "" {
+		hidden, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("malformed hidden parameter %q", v)
+		}
+		rule.Hidden = hidden
+	}
+
+	if v := r.Form.Get("condition"); v != "" {
+		rule.Condition = v
+		rule.Metric = ""
+		rule.Tiers = nil
+	}
+	if v := r.Form.Get("metric"); v != "" {
+		rule.Metric = v
+		rule.Condition = ""
+	}
+	if v := r.Form.Get("live"); v != "" {
+		live, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("malformed live parameter %q", v)
+		}
+		rule.Live = live
+	}
+	if v := r.Form.Get("tiers"); v != "" {
+		tiers, err := parseTiers(v)
+		if err != nil {
+			return err
+		}
+		rule.Tiers = tiers
+	}
+	return nil
+}
+
+// parseTiers parses tiers such as bronze:100,silver:500,gold:1000
+func parseTiers(s string) ([]Tier, error) {
+	var tiers []Tier
+	for _, part := range strings.Split(s, ",") {
+		var t Tier
+		threshold := part
+		if i := strings.LastIndex(part, ":"); i >= 0 {
+			t.Name = strings.TrimSpace(part[:i])
+			threshold = part[i+1:]
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(threshold), 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed tier %q", part)
+		}
+		t.Threshold = v
+		tiers = append(tiers, t)
+	}
+	return tiers, nil
+}
+
+// progressListingHandler reports the progress of a player for every
+// achievement, so the client can render progress bars
+func progressListingHandler(w http.ResponseWriter, r *http.Request) {
+	wd, err := requestedWorld(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Progress could not be listed because of malformed as_of parameter"))
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	if wd.Player(vars["playerId"]) == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Player not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wd.Progress(vars["playerId"]))
+}
+
+// rulesListingHandler returns a json encoded list of all the achievement rules
+func rulesListingHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(world.Rules.Rules)
+}
+
+// ruleCreationHandler creates an achievement rule based on the id, name,
+// description, condition (or metric and tiers), scope and live parameters.
+// Translations, icon key and hidden flag can also be provided.
+func ruleCreationHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Rule could not be created because of malformed POST parameters"))
+		return
+	}
+
+	var rule AchievementRule
+	err = ruleFromForm(r, &rule)
+	if err == nil {
+		err = rule.Compile()
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Rule could not be created: " + err.Error()))
+		return
+	}
+	if world.Rules.Rule(rule.ID) != nil {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Rule could not be created because this id is already used"))
+		return
+	}
+
+	recordEvent(Event{Type: RuleCreated, Rule: &rule})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+// ruleUpdateHandler updates the name, description, translations, icon key,
+// hidden flag, condition, metric, tiers, scope or live flag of an achievement rule
+func ruleUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Rule could not be updated because of malformed PUT parameters"))
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	existing := world.Rules.Rule(vars["id"])
+	if existing == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Rule not found"))
+		return
+	}
+
+	// Work on a copy so that an invalid update leaves the rule untouched
+	rule := *existing
+	err = ruleFromForm(r, &rule)
+	rule.ID = existing.ID
+	if err == nil {
+		err = rule.Compile()
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Rule could not be updated: " + err.Error()))
+		return
+	}
+	recordEvent(Event{Type: RuleUpdated, Rule: &rule})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+// ruleDeletionHandler deletes an achievement rule
+func ruleDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if world.Rules.Rule(vars["id"]) == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Rule not found"))
+		return
+	}
+
+	recordEvent(Event{Type: RuleDeleted, RuleID: vars["id"]})
+	w.Write([]byte("Rule successfully deleted"))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// TestRuleCreationHandler tests that a new achievement rule is granted when
+// a game stops, and that invalid rules are rejected
+func TestRuleCreationHandler(t *testing.T) {
+	params := url.Values{
+		"id":          {"assassin"},
+		"name":        {"Assassin"},
+		"description": {"Get 2 kills in a game"},
+		"condition":   {"nbKills >= 2"},
+		"scope":       {ScopeGame},
+	}
+	rr := doRequest(t, "POST", "/admin/achievements/rules", params, true)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	defer resetRules()
+
+	// The same id cannot be used twice
+	rr = doRequest(t, "POST", "/admin/achievements/rules", params, true)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusConflict)
+	}
+
+	// Unknown variables, malformed conditions and scopes are rejected
+	for param, value := range map[string]string{"condition": "nbTeleports > 1", "scope": "season"} {
+		invalid := url.Values{"id": {"invalid"}, "name": {"Invalid"}, "condition": {"nbKills > 1"}, "scope": {ScopeGame}}
+		invalid.Set(param, value)
+		rr = doRequest(t, "POST", "/admin/achievements/rules", invalid, true)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code for %s %q: got %v want %v",
+				param, value, status, http.StatusBadRequest)
+		}
+	}
+
+	g := newTestGame(t, "Assassin Game")
+	player := g.Team1.Players[0]
+	for i := 0; i < 2; i++ {
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, player.ID), url.Values{"name": {"nbKills"}}, false)
+	}
+	doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {g.Team1.ID}}, false)
+
+	rr = doRequest(t, "GET", fmt.Sprintf("/games/%s/players/%s/achievements", g.ID, player.ID), nil, false)
+	var achievements Achievements
+	json.Unmarshal(rr.Body.Bytes(), &achievements)
+	if !achievements["assassin"] {
+		t.Errorf("handler returned unexpected assassin achievement in body: got %v want %v",
+			achievements["assassin"], true)
+	}
+	if achievements["bruiser"] {
+		t.Errorf("handler returned unexpected bruiser achievement in body: got %v want %v",
+			achievements["bruiser"], false)
+	}
+}
+
+// TestRuleUpdateHandler tests the update of a rule condition and that an
+// invalid update leaves the rule untouched
+func TestRuleUpdateHandler(t *testing.T) {
+	defer resetRules()
+
+	rr := doRequest(t, "PUT", "/admin/achievements/rules/bruiser", url.Values{"condition": {"totalDamage >= 10"}}, true)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if got := world.Rules.Calculate(ScopeGame, Stats{DamageDone: 10}); !got["bruiser"] {
+		t.Errorf("updated rule was not applied: got %v want %v", got["bruiser"], true)
+	}
+
+	rr = doRequest(t, "PUT", "/admin/achievements/rules/bruiser", url.Values{"condition": {"totalDamage >="}}, true)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	if world.Rules.Rule("bruiser").Condition != "totalDamage >= 10" {
+		t.Errorf("invalid update modified the rule: got %v", world.Rules.Rule("bruiser").Condition)
+	}
+}
+
+// TestRuleDeletionHandler tests the deletion of a rule
+func TestRuleDeletionHandler(t *testing.T) {
+	defer resetRules()
+
+	rr := doRequest(t, "DELETE", "/admin/achievements/rules/veteran", nil, true)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if _, ok := world.Rules.Calculate(ScopeLifetime, Stats{})["veteran:gold"]; ok {
+		t.Errorf("deleted rule is still calculated")
+	}
+
+	rr = doRequest(t, "DELETE", "/admin/achievements/rules/veteran", nil, true)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
+
+// TestTieredRules tests that each tier of a progressive achievement is
+// reached independently, and that invalid tiers are rejected
+func TestTieredRules(t *testing.T) {
+	defer resetRules()
+
+	params := url.Values{
+		"id":     {"killer"},
+		"name":   {"Killer"},
+		"metric": {"nbKills"},
+		"tiers":  {"bronze:1,silver:5,gold:10"},
+		"scope":  {ScopeLifetime},
+	}
+	rr := doRequest(t, "POST", "/admin/achievements/rules", params, true)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	got := world.Rules.Calculate(ScopeLifetime, Stats{NbKills: 7})
+	for id, want := range map[string]bool{"killer": true, "killer:bronze": true, "killer:silver": true, "killer:gold": false} {
+		if got[id] != want {
+			t.Errorf("unexpected %s achievement: got %v want %v", id, got[id], want)
+		}
+	}
+
+	for _, tiers := range []string{"bronze:5,silver:1", "1,5", "bronze:1,bronze:5", "bronze:many"} {
+		params.Set("id", "invalid")
+		params.Set("tiers", tiers)
+		rr = doRequest(t, "POST", "/admin/achievements/rules", params, true)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code for tiers %q: got %v want %v",
+				tiers, status, http.StatusBadRequest)
+		}
+	}
+}
+
+// TestProgressListingHandler tests the progress reported for single, single
+// tier and multiple tiers achievements
+func TestProgressListingHandler(t *testing.T) {
+	defer resetRules()
+	params := url.Values{"id": {"demolisher"}, "name": {"Demolisher"}, "metric": {"damageDone"}, "tiers": {"bronze:100,silver:200"}, "scope": {ScopeGame}}
+	doRequest(t, "POST", "/admin/achievements/rules", params, true)
+
+	g := newTestGame(t, "Progress Game")
+	player := g.Team1.Players[0]
+	for i := 0; i < 125; i++ {
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, player.ID), url.Values{"name": {"damageDone"}}, false)
+	}
+	doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {g.Team1.ID}}, false)
+
+	rr := doRequest(t, "GET", fmt.Sprintf("/players/%s/achievements/progress", player.ID), nil, false)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var progress []AchievementProgress
+	json.Unmarshal(rr.Body.Bytes(), &progress)
+	byID := map[string]AchievementProgress{}
+	for _, ap := range progress {
+		byID[ap.ID] = ap
+	}
+	nbPlayerRules := 0
+	for _, r := range world.Rules.Rules {
+		if r.Scope != ScopeTeam {
+			nbPlayerRules++
+		}
+	}
+	if len(byID) != nbPlayerRules {
+		t.Errorf("handler returned unexpected number of achievements: got %v want %v",
+			len(byID), nbPlayerRules)
+	}
+	if ap := byID["bruiser"]; ap.Value != 125 || ap.NextThreshold != 500 || ap.Percentage != 25 || ap.Completed {
+		t.Errorf("handler returned unexpected bruiser progress: got %+v", ap)
+	}
+	if ap := byID["veteran"]; ap.Value != 1 || ap.NextTier != "bronze" || ap.Percentage != 1 {
+		t.Errorf("handler returned unexpected veteran progress: got %+v", ap)
+	}
+	// Progress towards silver is measured from the bronze threshold
+	if ap := byID["demolisher"]; ap.Tier != "bronze" || ap.NextTier != "silver" || ap.Percentage != 25 {
+		t.Errorf("handler returned unexpected demolisher progress: got %+v", ap)
+	}
+	if ap := byID["sharpshooter"]; ap.Value != 0 || ap.NextThreshold != 1 {
+		t.Errorf("handler returned unexpected sharpshooter progress: got %+v", ap)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// AuditRecord is an immutable trace of a stat correction made by an admin
+type AuditRecord struct {
+	ID       string    `json:"id"`
+	Admin    string    `json:"admin"`
+	Action   string    `json:"action"`
+	GameID   string    `json:"gameId"`
+	PlayerID string    `json:"playerId"`
+	Stat     string    `json:"stat"`
+	OldValue int       `json:"oldValue"`
+	NewValue int       `json:"newValue"`
+	Reason   string    `json:"reason"`
+	RevertOf string    `json:"revertOf,omitempty"`
+	Seq      int       `json:"seq"`
+	Time     time.Time `json:"time"`
+}
+
+// Init admins (indexed by token) and audit trail.
+// The audit trail is append only: records are never modified or removed.
+var admins = map[string]string{}
+var auditTrail []AuditRecord
+
+// loadAdmins parses a comma separated list of name:token pairs
+// (typically the ADMIN_TOKENS environment variable) into the admins list
+func loadAdmins(spec string) {
+	for _, pair := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			continue
+		}
+		admins[parts[1]] = parts[0]
+	}
+}
+
+// adminOnly only lets requests through if they carry a known admin
+// token in the X-Admin-Token header
+func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := admins[r.Header.Get("X-Admin-Token")]; !ok {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("This operation is restricted to admins"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+// adminName returns the name of the admin performing the request
+func adminName(r *http.Request) string {
+	return admins[r.Header.Get("X-Admin-Token")]
+}
+
+// correctStat sets the stat of a player in a game and records the change in
+// the audit trail.
+// If the game is already stopped, the player achievements are calculated again
+// so they reflect the corrected stats.
+func correctStat(g *Game, p *Player, statName string, value int, rec AuditRecord) AuditRecord {
+	rec.ID = uuid.New().String()
+	rec.GameID = g.ID
+	rec.PlayerID = p.ID
+	rec.Stat = statName
+	rec.OldValue, _ = p.Stats.Stat(statName)
+	rec.NewValue = value
+
+	e := recordEvent(Event{Type: StatCorrected, GameID: g.ID, PlayerID: p.ID, Stat: statName, Value: value})
+	rec.Seq = e.Seq
+	rec.Time = e.Time
+
+	auditTrail = append(auditTrail, rec)
+	return rec
+}
+
+// statCorrectionHandler lets an admin set or adjust a player stat in any game,
+// even a stopped one.
+// The "set" action replaces the stat value while the "adjust" action adds
+// the (possibly negative) value to the current one.
+// A reason is mandatory so the audit trail explains every change.
+func statCorrectionHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Stat could not be corrected because of malformed PUT parameters"))
+		return
+	}
+	name := r.Form.Get("name")
+	action := r.Form.Get("action")
+	reason := r.Form.Get("reason")
+	value, err := strconv.Atoi(r.Form.Get("value"))
+	if name == "" || reason == "" || err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Stat could not be corrected because of empty or malformed PUT parameter"))
+		return
+	}
+	if action != "set" && action != "adjust" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Stat could not be corrected because action should be set or adjust"))
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	if g := world.Game(vars["gameId"]); g != nil {
+		p := g.Player(vars["playerId"])
+		if p == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Game or player could not be found"))
+			return
+		}
+
+		current, ok := p.Stats.Stat(name)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Stat could not be corrected because of unknown stat name"))
+			return
+		}
+		if action == "adjust" {
+			value = current + value
+		}
+		if value < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Stat could not be corrected because stats cannot be negative"))
+			return
+		}
+
+		rec := correctStat(g, p, name, value, AuditRecord{Admin: adminName(r), Action: action, Reason: reason})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rec)
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte("Game or player could not be found"))
+}
+
+// correctionRevertHandler reverts a previous stat correction by restoring the
+// old value of the stat.
+// A correction can only be reverted once, and only if the stat was not
+// modified since. Reverts cannot be reverted themselves.
+func correctionRevertHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Correction could not be reverted because of malformed POST parameters"))
+		return
+	}
+	reason := r.Form.Get("reason")
+	if reason == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Correction could not be reverted because of empty POST parameter"))
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	var orig *AuditRecord
+	for i, rec := range auditTrail {
+		if rec.RevertOf == vars["id"] {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("Correction has already been reverted"))
+			return
+		}
+		if rec.ID == vars["id"] {
+			orig = &auditTrail[i]
+		}
+	}
+	if orig == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Correction not found"))
+		return
+	}
+	if orig.Action == "revert" {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("A revert cannot be reverted"))
+		return
+	}
+
+	if g := world.Game(orig.GameID); g != nil {
+		p := g.Player(orig.PlayerID)
+		if p == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Game or player could not be found"))
+			return
+		}
+
+		if current, _ := p.Stats.Stat(orig.Stat); current != orig.NewValue {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("Correction cannot be reverted because the stat was modified since"))
+			return
+		}
+
+		rec := correctStat(g, p, orig.Stat, orig.OldValue, AuditRecord{Admin: adminName(r), Action: "revert", Reason: reason, RevertOf: orig.ID})
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rec)
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte("Game or player could not be found"))
+}
+
+// auditListingHandler returns the audit trail, optionally filtered by the
+// gameId and playerId query parameters
+func auditListingHandler(w http.ResponseWriter, r *http.Request) {
+	gameID := r.URL.Query().Get("gameId")
+	playerID := r.URL.Query().Get("playerId")
+
+	recs := []AuditRecord{}
+	for _, rec := range auditTrail {
+		if (gameID == "" || rec.GameID == gameID) && (playerID == "" || rec.PlayerID == playerID) {
+			recs = append(recs, rec)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recs)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// TestStatCorrectionHandler tests that only admins can correct stats, that
+// stats of a stopped game can be set and adjusted, and that achievements are
+// calculated again
+func TestStatCorrectionHandler(t *testing.T) {
+	g := newTestGame(t, "Corrected Game")
+	player := g.Team1.Players[0]
+	endpoint := fmt.Sprintf("/admin/games/%s/players/%s/stats", g.ID, player.ID)
+	doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {g.Team1.ID}}, false)
+
+	// Non admins should be rejected
+	params := url.Values{"name": {"damageDone"}, "action": {"set"}, "value": {"600"}, "reason": {"lost hits"}}
+	rr := doRequest(t, "PUT", endpoint, params, false)
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusForbidden)
+	}
+
+	// A reason is mandatory
+	rr = doRequest(t, "PUT", endpoint, url.Values{"name": {"damageDone"}, "action": {"set"}, "value": {"600"}}, true)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+
+	// Set the damage done so the player becomes a bruiser
+	rr = doRequest(t, "PUT", endpoint, params, true)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	var rec AuditRecord
+	json.Unmarshal(rr.Body.Bytes(), &rec)
+	if rec.Admin != "tester" || rec.OldValue != 0 || rec.NewValue != 600 {
+		t.Errorf("handler returned unexpected audit record in body: got %+v", rec)
+	}
+
+	// Adjust it back below the bruiser threshold
+	params.Set("action", "adjust")
+	params.Set("value", "-200")
+	rr = doRequest(t, "PUT", endpoint, params, true)
+	json.Unmarshal(rr.Body.Bytes(), &rec)
+	if rec.OldValue != 600 || rec.NewValue != 400 {
+		t.Errorf("handler returned unexpected audit record in body: got %+v", rec)
+	}
+
+	rr = doRequest(t, "GET", fmt.Sprintf("/games/%s/players/%s/achievements", g.ID, player.ID), nil, false)
+	var achievements Achievements
+	json.Unmarshal(rr.Body.Bytes(), &achievements)
+	if achievements["bruiser"] {
+		t.Errorf("handler returned unexpected bruiser achievement in body: got %v want %v",
+			achievements["bruiser"], false)
+	}
+}
+
+// TestCorrectionRevertHandler tests that a correction can be reverted only once
+// and that the audit trail keeps track of both operations
+func TestCorrectionRevertHandler(t *testing.T) {
+	g := newTestGame(t, "Reverted Game")
+	player := g.Team2.Players[1]
+	params := url.Values{"name": {"nbKills"}, "action": {"set"}, "value": {"12"}, "reason": {"fraud"}}
+	rr := doRequest(t, "PUT", fmt.Sprintf("/admin/games/%s/players/%s/stats", g.ID, player.ID), params, true)
+	var rec AuditRecord
+	json.Unmarshal(rr.Body.Bytes(), &rec)
+
+	endpoint := fmt.Sprintf("/admin/audit/%s/revert", rec.ID)
+	rr = doRequest(t, "POST", endpoint, url.Values{"reason": {"mistake"}}, true)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	var revert AuditRecord
+	json.Unmarshal(rr.Body.Bytes(), &revert)
+	if revert.RevertOf != rec.ID || revert.NewValue != 0 {
+		t.Errorf("handler returned unexpected audit record in body: got %+v", revert)
+	}
+
+	// Reverting twice should fail
+	rr = doRequest(t, "POST", endpoint, url.Values{"reason": {"mistake"}}, true)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusConflict)
+	}
+
+	// Reverting a revert should fail
+	rr = doRequest(t, "POST", fmt.Sprintf("/admin/audit/%s/revert", revert.ID), url.Values{"reason": {"mistake"}}, true)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code for a revert: got %v want %v",
+			status, http.StatusConflict)
+	}
+
+	rr = doRequest(t, "GET", "/admin/audit?playerId="+player.ID, nil, true)
+	var recs []AuditRecord
+	json.Unmarshal(rr.Body.Bytes(), &recs)
+	if len(recs) != 2 {
+		t.Errorf("handler returned unexpected number of audit records: got %v want %v",
+			len(recs), 2)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// rolePenalty is the rating difference considered as bad as each role
+// missing in a team compared to the other one
+const rolePenalty = 50
+
+// BalancedTeams is a split of a pool of players into two teams, with the
+// composite rating of each team and the probability that team 1 (or team 2)
+// wins. RoleImbalance is the number of roles which are not evenly split.
+// Team and game ids are given if a game was created between the teams.
+type BalancedTeams struct {
+	Mode                string   `json:"mode"`
+	Team1               []Player `json:"team1"`
+	Team2               []Player `json:"team2"`
+	Team1Rating         Rating   `json:"team1Rating"`
+	Team2Rating         Rating   `json:"team2Rating"`
+	Team1WinProbability float64  `json:"team1WinProbability"`
+	Team2WinProbability float64  `json:"team2WinProbability"`
+	RoleImbalance       int      `json:"roleImbalance"`
+	Team1ID             string   `json:"team1Id,omitempty"`
+	Team2ID             string   `json:"team2Id,omitempty"`
+	GameID              string   `json:"gameId,omitempty"`
+}
+
+// roleImbalance returns the number of players by which the roles of two
+// teams differ
+func roleImbalance(team1, team2 []Player) int {
+	counts := map[string]int{}
+	for _, p := range team1 {
+		if p.Role != "" {
+			counts[p.Role]++
+		}
+	}
+	for _, p := range team2 {
+		if p.Role != "" {
+			counts[p.Role]--
+		}
+	}
+	imbalance := 0
+	for _, c := range counts {
+		if c < 0 {
+			c = -c
+		}
+		imbalance += c
+	}
+	return imbalance
+}
+
+// BalanceTeams splits a pool of players (of even size) into the two most
+// balanced teams in a mode: all the splits are tried, and the one with the
+// smallest difference between the composite ratings of the teams, plus a
+// penalty for each role not evenly split, is kept.
+// Ratings are the ratings of the season in progress.
+func (w *World) BalanceTeams(pool []Player, mode string) BalancedTeams {
+	seasonID := w.seasonAt(time.Now())
+	ratings := make([]Rating, len(pool))
+	for i, p := range pool {
+		ratings[i] = w.Rating(mode, seasonID, p.ID)
+	}
+
+	var best BalancedTeams
+	bestScore := math.Inf(1)
+	teamSize := len(pool) / 2
+	inTeam1 := make([]bool, len(pool))
+
+	var search func(i, size1 int)
+	search = func(i, size1 int) {
+		if size1 > teamSize || i-size1 > teamSize {
+			return
+		}
+		if i == len(pool) {
+			var team1, team2 []Player
+			var ratings1, ratings2 []Rating
+			for j, p := range pool {
+				if inTeam1[j] {
+					team1 = append(team1, p)
+					ratings1 = append(ratings1, ratings[j])
+				} else {
+					team2 = append(team2, p)
+					ratings2 = append(ratings2, ratings[j])
+				}
+			}
+			r1, r2 := compositeRating(ratings1), compositeRating(ratings2)
+			imbalance := roleImbalance(team1, team2)
+			if score := math.Abs(r1.Rating-r2.Rating) + float64(imbalance)*rolePenalty; score < bestScore {
+				bestScore = score
+				best = BalancedTeams{Mode: mode, Team1: team1, Team2: team2, Team1Rating: r1, Team2Rating: r2, RoleImbalance: imbalance}
+			}
+			return
+		}
+		inTeam1[i] = true
+		search(i+1, size1+1)
+		// The first player is always in team 1 so that each split is only
+		// tried once
+		if i > 0 {
+			inTeam1[i] = false
+			search(i+1, size1)
+		}
+	}
+	search(0, 0)
+
+	best.Team1WinProbability = WinProbability(best.Team1Rating, best.Team2Rating)
+	best.Team2WinProbability = 1 - best.Team1WinProbability
+	return best
+}
+
+// teamBalancingHandler splits the pool of players provided by the comma
+// separated playerIds parameter (6, 8 or 10 players) into the two most
+// balanced teams for the mode provided.
+// If the create parameter is true, a game named after the name parameter is
+// created between the teams, on the map provided by the mapId parameter if
+// any, as ephemeral rosters named after the team1Name and team2Name
+// parameters: the players keep their own team, and the rosters are removed
+// once the game is over.
+func teamBalancingHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Teams could not be balanced because of malformed POST parameters"))
+		return
+	}
+	if r.Form.Get("playerIds") == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Teams could not be balanced because of empty POST parameter"))
+		return
+	}
+	mode := r.Form.Get("mode")
+	if mode == "" {
+		mode = DefaultMode
+	}
+	mapID := r.Form.Get("mapId")
+	if !validMap(mapID) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("No map could be found with this id"))
+		return
+	}
+	create := false
+	if v := r.Form.Get("create"); v != "" {
+		create, err = strconv.ParseBool(v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Teams could not be balanced because of malformed create parameter"))
+			return
+		}
+	}
+
+	ids := strings.Split(r.Form.Get("playerIds"), ",")
+	if len(ids)%2 != 0 || len(ids) < 6 || len(ids) > 10 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Teams could not be balanced because the pool should have 6, 8 or 10 players"))
+		return
+	}
+	var pool []Player
+	seen := map[string]bool{}
+	for _, id := range ids {
+		p := world.Player(id)
+		if p == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Player not found"))
+			return
+		}
+		if seen[id] {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Teams could not be balanced because a player is given twice"))
+			return
+		}
+		seen[id] = true
+		if create && world.ActiveGames[id] != "" {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("Game could not be created because a player is already in a game"))
+			return
+		}
+		pool = append(pool, *p)
+	}
+
+	teams := world.BalanceTeams(pool, mode)
+	if create {
+		names := [2]string{r.Form.Get("team1Name"), r.Form.Get("team2Name")}
+		for i := range names {
+			if names[i] == "" {
+				names[i] = "Balanced team " + strconv.Itoa(i+1)
+			}
+		}
+		name := r.Form.Get("name")
+		if name == "" {
+			name = "Balanced game"
+		}
+		teams.Team1ID = createRoster(names[0], teams.Team1)
+		teams.Team2ID = createRoster(names[1], teams.Team2)
+		e := recordEvent(Event{Type: GameCreated, GameID: uuid.New().String(), Name: name, Team1ID: teams.Team1ID, Team2ID: teams.Team2ID, Mode: mode, MapID: mapID})
+		teams.GameID = e.GameID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(teams)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestTeamBalancingHandler tests that a pool is split into teams with the
+// same rating and evenly split roles, and that the teams can be created
+func TestTeamBalancingHandler(t *testing.T) {
+	team := newTestTeam(t, "Pool", 0)
+	var ids []string
+	if world.Ratings == nil {
+		world.Ratings = map[string]map[string]Rating{}
+	}
+	world.Ratings[ratingKey("balance", "")] = map[string]Rating{}
+	for i, rating := range []float64{2000, 1900, 1500, 1500, 1100, 1000} {
+		role := "damage"
+		if i < 2 {
+			role = "tank"
+		}
+		rr := doRequest(t, "POST", fmt.Sprintf("/teams/%s/players", team.ID), url.Values{"pseudo": {fmt.Sprintf("Pool player %d", i)}, "role": {role}}, false)
+		var p Player
+		json.Unmarshal(rr.Body.Bytes(), &p)
+		if p.Role != role {
+			t.Errorf("handler returned unexpected role: got %v want %v", p.Role, role)
+		}
+		ids = append(ids, p.ID)
+		world.Ratings[ratingKey("balance", "")][p.ID] = Rating{Rating: rating, Deviation: 50, Volatility: initialVolatility}
+	}
+
+	rr := doRequest(t, "POST", "/teams/balance", url.Values{"playerIds": {strings.Join(ids, ",")}, "mode": {"balance"}, "create": {"true"}}, false)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	var teams BalancedTeams
+	json.Unmarshal(rr.Body.Bytes(), &teams)
+	if teams.Team1Rating.Rating != 1500 || teams.Team2Rating.Rating != 1500 || teams.RoleImbalance != 0 ||
+		math.Abs(teams.Team1WinProbability-0.5) > 0.001 {
+		t.Errorf("handler returned unbalanced teams: got %+v", teams)
+	}
+	for _, id := range []string{teams.Team1ID, teams.Team2ID} {
+		if created := world.Team(id); created == nil || len(created.Players) != 3 || !created.Ephemeral {
+			t.Errorf("balanced team was not created: got %+v", created)
+		}
+	}
+	if g := world.Game(teams.GameID); g == nil || g.Team1.ID != teams.Team1ID || g.Mode != "balance" {
+		t.Errorf("balanced game was not created: got %+v", g)
+	}
+	rr = doRequest(t, "POST", "/teams/balance", url.Values{"playerIds": {strings.Join(ids, ",")}, "create": {"true"}}, false)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusConflict)
+	}
+	doRequest(t, "PUT", "/games/"+teams.GameID, url.Values{"teamId": {teams.Team1ID}}, false)
+	if world.Team(teams.Team1ID) != nil || world.Team(teams.Team2ID) != nil || len(world.Team(team.ID).Players) != 6 {
+		t.Errorf("balanced rosters not removed once the game is over")
+	}
+
+	for _, pool := range []string{strings.Join(ids[:5], ","), strings.Join(ids[:5], ",") + "," + ids[0]} {
+		rr = doRequest(t, "POST", "/teams/balance", url.Values{"playerIds": {pool}}, false)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code for pool %q: got %v want %v",
+				pool, status, http.StatusBadRequest)
+		}
+	}
+}
+
+// TestPlayerRoleHandler tests that the role of a player can be changed
+func TestPlayerRoleHandler(t *testing.T) {
+	team := newTestTeam(t, "Role Team", 1)
+	id := team.Players[0].ID
+
+	rr := doRequest(t, "PUT", fmt.Sprintf("/players/%s/role", id), url.Values{"role": {"healer"}}, false)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	var p Player
+	json.Unmarshal(rr.Body.Bytes(), &p)
+	if p.Role != "healer" || world.Team(team.ID).Players[0].Role != "healer" {
+		t.Errorf("handler returned unexpected role: got %v want %v", p.Role, "healer")
+	}
+
+	rr = doRequest(t, "PUT", fmt.Sprintf("/players/%s/role", id), nil, false)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	rr = doRequest(t, "PUT", "/players/unknown/role", url.Values{"role": {"tank"}}, false)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// CatalogEntry describes an achievement, or a tier of a progressive
+// achievement, as displayed to players.
+// Rarity is the percentage of players (or teams for team achievements) who
+// have unlocked the achievement.
+type CatalogEntry struct {
+	ID          string  `json:"id"`
+	RuleID      string  `json:"ruleId"`
+	Name        string  `json:"name,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Icon        string  `json:"icon,omitempty"`
+	Tier        string  `json:"tier,omitempty"`
+	Threshold   float64 `json:"threshold,omitempty"`
+	Scope       string  `json:"scope"`
+	Hidden      bool    `json:"hidden"`
+	Rarity      float64 `json:"rarity"`
+}
+
+// PlayerIDs returns the ids of all the players, including the ones who left
+// their team
+func (w *World) PlayerIDs() map[string]bool {
+	ids := map[string]bool{}
+	for _, t := range w.Teams {
+		for _, p := range t.Players {
+			ids[p.ID] = true
+		}
+	}
+	for _, g := range w.Games {
+		for _, p := range append(append([]Player(nil), g.Team1.Players...), g.Team2.Players...) {
+			ids[p.ID] = true
+		}
+	}
+	return ids
+}
+
+// TeamIDs returns the ids of all the teams, including deleted teams which
+// played games, but not ephemeral teams
+func (w *World) TeamIDs() map[string]bool {
+	ids := map[string]bool{}
+	for _, t := range append(append([]Team(nil), w.Teams...), w.gameTeams()...) {
+		if !t.Ephemeral {
+			ids[t.ID] = true
+		}
+	}
+	return ids
+}
+
+// gameTeams returns the teams of all the games, as they played them
+func (w *World) gameTeams() []Team {
+	var teams []Team
+	for _, g := range w.Games {
+		teams = append(teams, g.Team1, g.Team2)
+	}
+	return teams
+}
+
+// Catalog lists all the achievements with their texts in the language
+// provided (falling back to the default texts).
+// Names and descriptions of hidden achievements are only given if the
+// player or team provided (owner, which can be empty) has unlocked them.
+func (w *World) Catalog(lang, owner string) []CatalogEntry {
+	// Count unlocks of each achievement
+	nbPlayerUnlocks := map[string]int{}
+	nbTeamUnlocks := map[string]int{}
+	unlocked := map[string]bool{}
+	for _, u := range w.Unlocks {
+		nbPlayerUnlocks[u.AchievementID]++
+		if u.PlayerID == owner {
+			unlocked[u.AchievementID] = true
+		}
+	}
+	for _, u := range w.TeamUnlocks {
+		nbTeamUnlocks[u.AchievementID]++
+		if u.TeamID == owner {
+			unlocked[u.AchievementID] = true
+		}
+	}
+	nbPlayers := float64(len(w.PlayerIDs()))
+	nbTeams := float64(len(w.TeamIDs()))
+
+	catalog := []CatalogEntry{}
+	for _, r := range w.Rules.Rules {
+		name, description := r.Name, r.Description
+		if v, ok := r.Names[lang]; ok {
+			name = v
+		}
+		if v, ok := r.Descriptions[lang]; ok {
+			description = v
+		}
+
+		tiers := r.Tiers
+		if r.condition != nil {
+			tiers = []Tier{{}}
+		}
+		for _, t := range tiers {
+			e := CatalogEntry{
+				ID:          r.TierID(t),
+				RuleID:      r.ID,
+				Name:        name,
+				Description: description,
+				Icon:        r.Icon,
+				Tier:        t.Name,
+				Threshold:   t.Threshold,
+				Scope:       r.Scope,
+				Hidden:      r.Hidden,
+			}
+			if e.Icon != "" && t.Name != "" {
+				e.Icon += "_" + t.Name
+			}
+			if r.Scope == ScopeTeam {
+				e.Rarity = 100 * ratio(float64(nbTeamUnlocks[e.ID]), nbTeams)
+			} else {
+				e.Rarity = 100 * ratio(float64(nbPlayerUnlocks[e.ID]), nbPlayers)
+			}
+			if r.Hidden && !unlocked[e.ID] {
+				e.Name = ""
+				e.Description = ""
+			}
+			catalog = append(catalog, e)
+		}
+	}
+	return catalog
+}
+
+// catalogHandler lists all the achievements with their metadata and rarity.
+// Texts are translated in the language provided by the lang query parameter.
+// Hidden achievements unlocked by the player or team provided by the
+// playerId or teamId query parameter are revealed.
+func catalogHandler(w http.ResponseWriter, r *http.Request) {
+	owner := r.URL.Query().Get("playerId")
+	if owner == "" {
+		owner = r.URL.Query().Get("teamId")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(world.Catalog(r.URL.Query().Get("lang"), owner))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// TestCatalogHandler tests the translation of the catalog, the rarity of
+// achievements and the reveal of hidden achievements
+func TestCatalogHandler(t *testing.T) {
+	g := newTestGame(t, "Catalog Game")
+	player := g.Team1.Players[0]
+	for i := 0; i < 500; i++ {
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, player.ID), url.Values{"name": {"damageDone"}}, false)
+	}
+	doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {g.Team1.ID}}, false)
+
+	catalog := func(query string) map[string]CatalogEntry {
+		rr := doRequest(t, "GET", "/achievements"+query, nil, false)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+		var entries []CatalogEntry
+		json.Unmarshal(rr.Body.Bytes(), &entries)
+		byID := map[string]CatalogEntry{}
+		for _, e := range entries {
+			byID[e.ID] = e
+		}
+		return byID
+	}
+
+	entries := catalog("")
+	for _, id := range []string{"sharpshooter", "bruiser", "veteran:bronze", "veteran:silver", "veteran:gold", "teamwork"} {
+		if _, ok := entries[id]; !ok {
+			t.Errorf("handler did not return the %s achievement", id)
+		}
+	}
+	if e := entries["veteran:gold"]; e.Name != "Veteran" || e.Tier != "gold" || e.Threshold != 1000 || e.Icon != "veteran_gold" {
+		t.Errorf("handler returned unexpected veteran gold entry: got %+v", e)
+	}
+	want := 100 * 1 / float64(len(world.PlayerIDs()))
+	if e := entries["bruiser"]; e.Rarity < want || e.Rarity <= 0 || e.Rarity > 100 {
+		t.Errorf("handler returned unexpected bruiser rarity: got %v want at least %v", e.Rarity, want)
+	}
+
+	// Team 1 won without being killed: the hidden flawless victory achievement
+	// is only revealed to them
+	if e := entries["flawlessVictory"]; !e.Hidden || e.Name != "" || e.Description != "" {
+		t.Errorf("handler revealed a hidden achievement: got %+v", e)
+	}
+	if e := catalog("?teamId=" + g.Team1.ID)["flawlessVictory"]; e.Name != "Flawless Victory" {
+		t.Errorf("handler did not reveal an unlocked hidden achievement: got %+v", e)
+	}
+
+	if e := catalog("?lang=fr")["bruiser"]; e.Name != "Cogneur" {
+		t.Errorf("handler returned unexpected translation: got %v want %v", e.Name, "Cogneur")
+	}
+	if e := catalog("?lang=de")["bruiser"]; e.Name != "Bruiser" {
+		t.Errorf("handler returned unexpected fallback name: got %v want %v", e.Name, "Bruiser")
+	}
+}
+
+// TestDefaultTranslations tests that the default achievements keep the
+// translations of every language
+func TestDefaultTranslations(t *testing.T) {
+	defaultTranslations["de"] = map[string][2]string{"bruiser": {"Schläger", "Mindestens 500 Schaden in einem Spiel verursachen"}}
+	defer delete(defaultTranslations, "de")
+
+	for _, r := range defaultAchievementRules() {
+		if r.ID != "bruiser" {
+			continue
+		}
+		if r.Names["fr"] != "Cogneur" || r.Names["de"] != "Schläger" || r.Descriptions["fr"] == "" || r.Descriptions["de"] == "" {
+			t.Errorf("unexpected translations: got %v %v", r.Names, r.Descriptions)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Challenge statuses
+const (
+	ChallengePending   = "pending"
+	ChallengeAccepted  = "accepted"
+	ChallengeDeclined  = "declined"
+	ChallengeExpired   = "expired"
+	ChallengeCancelled = "cancelled"
+)
+
+// Challenge responses
+const (
+	AcceptChallenge  = "accept"
+	DeclineChallenge = "decline"
+	CounterChallenge = "counter"
+	CancelChallenge  = "cancel"
+)
+
+// Proposal is the start time, mode and map (if any) of a game proposed by a
+// team
+type Proposal struct {
+	TeamID     string    `json:"teamId"`
+	Time       time.Time `json:"time"`
+	Mode       string    `json:"mode"`
+	MapID      string    `json:"mapId,omitempty"`
+	ProposedAt time.Time `json:"proposedAt"`
+}
+
+// Challenge is a game proposed by a team (the challenger) to another team
+// (the opponent).
+// The teams take turns: the team which did not make the last proposal
+// accepts it, declines it or makes a counter proposal. Once accepted, the
+// game is scheduled at the time proposed, until either team cancels it. A
+// challenge whose last proposal is not accepted in time expires.
+type Challenge struct {
+	ID           string     `json:"id"`
+	ChallengerID string     `json:"challengerId"`
+	OpponentID   string     `json:"opponentId"`
+	Status       string     `json:"status"`
+	Proposals    []Proposal `json:"proposals"`
+	GameID       string     `json:"gameId,omitempty"`
+}
+
+// Init challenges.
+// As the matchmaking queue, challenges are not part of the event log: only
+// the games they schedule are.
+var challenges []Challenge
+
+// challenge returns the challenge with the id provided, or nil if not found
+func challenge(id string) *Challenge {
+	for i := range challenges {
+		if challenges[i].ID == id {
+			return &challenges[i]
+		}
+	}
+	return nil
+}
+
+// Proposal returns the last proposal of a challenge
+func (c *Challenge) Proposal() Proposal {
+	return c.Proposals[len(c.Proposals)-1]
+}
+
+// startScheduledGames starts the scheduled games of accepted challenges
+// whose start time is reached, with the current rosters of the teams.
+// The game is cancelled if a team cannot play it anymore. Pending
+// challenges whose proposed time is reached expire.
+func startScheduledGames(now time.Time) {
+	for i := range challenges {
+		c := &challenges[i]
+		if now.Before(c.Proposal().Time) {
+			continue
+		}
+		if c.Status == ChallengePending {
+			c.Status = ChallengeExpired
+			continue
+		}
+		g := world.Game(c.GameID)
+		if c.Status != ChallengeAccepted || g == nil || g.Status != GameStatusScheduled {
+			continue
+		}
+		rosters := Game{}
+		if team := world.Team(c.ChallengerID); team != nil {
+			rosters.Team1 = *team
+		}
+		if team := world.Team(c.OpponentID); team != nil {
+			rosters.Team2 = *team
+		}
+		if rosters.Team1.ID == "" || rosters.Team2.ID == "" || !rosters.TeamSizesAreValid() {
+			recordEvent(Event{Type: GameCancelled, GameID: g.ID})
+			c.Status = ChallengeCancelled
+			log.Printf("Game %s cancelled because of invalid teams", g.Name)
+			continue
+		}
+		recordEvent(Event{Type: GameStarted, GameID: g.ID})
+		log.Printf("Game %s started", g.Name)
+	}
+}
+
+// proposal reads the time (RFC 3339 date in the future), mode and mapId (the
+// ones of the previous proposal by default) parameters of a proposal, and
+// returns the name of the first malformed parameter if any
+func proposal(r *http.Request, teamID string, previous Proposal) (Proposal, string) {
+	now := time.Now()
+	p := Proposal{TeamID: teamID, Mode: r.Form.Get("mode"), MapID: r.Form.Get("mapId"), ProposedAt: now}
+	t, err := time.Parse(time.RFC3339Nano, r.Form.Get("time"))
+	if err != nil || t.Before(now) {
+		return p, "time"
+	}
+	p.Time = t
+	if p.Mode == "" {
+		p.Mode = previous.Mode
+	}
+	if p.MapID == "" {
+		p.MapID = previous.MapID
+	}
+	if !validMap(p.MapID) {
+		return p, "mapId"
+	}
+	return p, ""
+}
+
+// challengeCreationHandler creates a challenge from the team provided by the
+// teamId parameter to the team provided by the opponentId parameter, for a
+// game at the time (RFC 3339 date), in the mode and on the map (optional)
+// provided
+func challengeCreationHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Challenge could not be created because of malformed POST parameters"))
+		return
+	}
+	team, opponent := world.Team(r.Form.Get("teamId")), world.Team(r.Form.Get("opponentId"))
+	if team == nil || opponent == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Team not found"))
+		return
+	}
+	if team.ID == opponent.ID {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("The teams should not be equal"))
+		return
+	}
+	if team.Ephemeral || opponent.Ephemeral {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Challenge could not be created because a team is the roster of a single game"))
+		return
+	}
+	p, malformed := proposal(r, team.ID, Proposal{Mode: DefaultMode})
+	if malformed != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Challenge could not be created because of malformed " + malformed + " parameter"))
+		return
+	}
+
+	c := Challenge{
+		ID:           uuid.New().String(),
+		ChallengerID: team.ID,
+		OpponentID:   opponent.ID,
+		Status:       ChallengePending,
+		Proposals:    []Proposal{p},
+	}
+	challenges = append(challenges, c)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// challengeResponseHandler answers a challenge on behalf of the team provided
+// by the teamId parameter, based on the action parameter:
+// - accept: schedule the game proposed (only the team which did not make the
+// last proposal can accept it)
+// - decline: decline the challenge (either team can decline it)
+// - counter: propose another time and mode with the time and mode (the same
+// mode by default) parameters (only the team which did not make the last
+// proposal can counter it)
+// - cancel: cancel the game scheduled by an accepted challenge before it
+// starts (either team can cancel it)
+func challengeResponseHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Challenge could not be answered because of malformed PUT parameters"))
+		return
+	}
+
+	c := challenge(mux.Vars(r)["id"])
+	if c == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Challenge not found"))
+		return
+	}
+	teamID := r.Form.Get("teamId")
+	if teamID != c.ChallengerID && teamID != c.OpponentID {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Team not found"))
+		return
+	}
+
+	action := r.Form.Get("action")
+	if action == CancelChallenge {
+		g := world.Game(c.GameID)
+		if c.Status != ChallengeAccepted || g == nil || g.Status != GameStatusScheduled {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("Challenge could not be cancelled because its game is not scheduled"))
+			return
+		}
+		recordEvent(Event{Type: GameCancelled, GameID: g.ID})
+		c.Status = ChallengeCancelled
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+		return
+	}
+
+	if c.Status != ChallengePending {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Challenge is already " + c.Status))
+		return
+	}
+	if !time.Now().Before(c.Proposal().Time) {
+		c.Status = ChallengeExpired
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Challenge could not be answered because it expired"))
+		return
+	}
+	if (action == AcceptChallenge || action == CounterChallenge) && teamID == c.Proposal().TeamID {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Challenge could not be answered because the other team should answer the last proposal"))
+		return
+	}
+
+	switch action {
+	case AcceptChallenge:
+		team1, team2 := world.Team(c.ChallengerID), world.Team(c.OpponentID)
+		if team1 == nil || team2 == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Team not found"))
+			return
+		}
+		g := Game{Team1: *team1, Team2: *team2}
+		if !g.TeamSizesAreValid() {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Challenge could not be accepted because teams should have 3 to 5 players and both the same size"))
+			return
+		}
+		e := recordEvent(Event{Type: GameScheduled, GameID: uuid.New().String(), Name: team1.Name + " vs " + team2.Name, Team1ID: team1.ID, Team2ID: team2.ID, Mode: c.Proposal().Mode, MapID: playableMap(c.Proposal().MapID)})
+		c.GameID = e.GameID
+		c.Status = ChallengeAccepted
+	case DeclineChallenge:
+		c.Status = ChallengeDeclined
+	case CounterChallenge:
+		p, malformed := proposal(r, teamID, c.Proposal())
+		if malformed != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Challenge could not be countered because of malformed " + malformed + " parameter"))
+			return
+		}
+		c.Proposals = append(c.Proposals, p)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Challenge could not be answered because of malformed action parameter"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// challengeRetrievalHandler returns a challenge
+func challengeRetrievalHandler(w http.ResponseWriter, r *http.Request) {
+	c := challenge(mux.Vars(r)["id"])
+	if c == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Challenge not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// teamChallengesHandler lists the challenges sent or received by a team,
+// optionally filtered by the status query parameter
+func teamChallengesHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if world.Team(id) == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Team not found"))
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	list := []Challenge{}
+	for _, c := range challenges {
+		if (c.ChallengerID == id || c.OpponentID == id) && (status == "" || c.Status == status) {
+			list = append(list, c)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestChallenges tests that a challenge can be countered then accepted, and
+// that the scheduled game starts at the time agreed
+func TestChallenges(t *testing.T) {
+	team1 := newTestTeam(t, "Challenger", 3)
+	team2 := newTestTeam(t, "Challenged", 3)
+	start := time.Now().Add(time.Hour)
+
+	rr := doRequest(t, "POST", "/challenges", url.Values{"teamId": {team1.ID}, "opponentId": {team2.ID}, "time": {start.Format(time.RFC3339Nano)}, "mode": {"scrim"}}, false)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	var c Challenge
+	json.Unmarshal(rr.Body.Bytes(), &c)
+
+	respond := func(teamID, action string, start time.Time) int {
+		rr := doRequest(t, "PUT", "/challenges/"+c.ID, url.Values{"teamId": {teamID}, "action": {action}, "time": {start.Format(time.RFC3339Nano)}}, false)
+		json.Unmarshal(rr.Body.Bytes(), &c)
+		return rr.Code
+	}
+	if status := respond(team1.ID, AcceptChallenge, start); status != http.StatusConflict {
+		t.Errorf("challenger should not accept its own proposal: got %v want %v", status, http.StatusConflict)
+	}
+	if status := respond(team2.ID, CounterChallenge, time.Now().Add(-time.Hour)); status != http.StatusBadRequest {
+		t.Errorf("proposals in the past should be refused: got %v want %v", status, http.StatusBadRequest)
+	}
+	start = start.Add(time.Hour)
+	if status := respond(team2.ID, CounterChallenge, start); status != http.StatusOK || len(c.Proposals) != 2 {
+		t.Fatalf("handler returned unexpected counter proposal: got %v %+v", status, c)
+	}
+	if status := respond(team1.ID, AcceptChallenge, start); status != http.StatusOK || c.Status != ChallengeAccepted {
+		t.Fatalf("handler returned unexpected acceptance: got %v %+v", status, c)
+	}
+
+	g := world.Game(c.GameID)
+	if g == nil || g.Status != GameStatusScheduled || !g.StartTime.IsZero() || g.Mode != "scrim" {
+		t.Fatalf("game was not scheduled: got %+v", g)
+	}
+	rr = doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, team1.Players[0].ID), url.Values{"name": {"nbKills"}}, false)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for a scheduled game: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+
+	startScheduledGames(start.Add(-time.Minute))
+	if g := world.Game(c.GameID); g.Status != GameStatusScheduled {
+		t.Errorf("game should not start before its start time: got %+v", g)
+	}
+	// The game is played by the rosters of the teams when it starts
+	for _, team := range []Team{team1, team2} {
+		doRequest(t, "POST", fmt.Sprintf("/teams/%s/players", team.ID), url.Values{"pseudo": {team.Name + " substitute"}}, false)
+	}
+	startScheduledGames(start)
+	if g := world.Game(c.GameID); g.Status != GameStatusRunning || g.StartTime.IsZero() || len(g.Team1.Players) != 4 || len(g.Team2.Players) != 4 {
+		t.Errorf("game should start at its start time with the current rosters: got %+v", g)
+	}
+
+	rr = doRequest(t, "GET", fmt.Sprintf("/teams/%s/challenges?status=accepted", team2.ID), nil, false)
+	var list []Challenge
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list) != 1 || list[0].ID != c.ID {
+		t.Errorf("handler returned unexpected challenges: got %+v", list)
+	}
+}
+
+// TestChallengeExpiryAndCancel tests that challenges cannot be accepted once
+// their proposed time has passed, and that scheduled games can be cancelled
+func TestChallengeExpiryAndCancel(t *testing.T) {
+	team1 := newTestTeam(t, "Expiry Challenger", 3)
+	team2 := newTestTeam(t, "Expiry Challenged", 3)
+	create := func() Challenge {
+		rr := doRequest(t, "POST", "/challenges", url.Values{"teamId": {team1.ID}, "opponentId": {team2.ID}, "time": {time.Now().Add(time.Hour).Format(time.RFC3339Nano)}}, false)
+		var c Challenge
+		json.Unmarshal(rr.Body.Bytes(), &c)
+		return c
+	}
+	respond := func(c Challenge, teamID, action string) (int, Challenge) {
+		rr := doRequest(t, "PUT", "/challenges/"+c.ID, url.Values{"teamId": {teamID}, "action": {action}}, false)
+		json.Unmarshal(rr.Body.Bytes(), &c)
+		return rr.Code, c
+	}
+
+	stale := create()
+	challenge(stale.ID).Proposals[0].Time = time.Now().Add(-time.Minute)
+	if status, _ := respond(stale, team2.ID, AcceptChallenge); status != http.StatusConflict || challenge(stale.ID).Status != ChallengeExpired {
+		t.Errorf("stale challenge should expire: got %v %+v", status, challenge(stale.ID))
+	}
+
+	pending := create()
+	startScheduledGames(time.Now().Add(2 * time.Hour))
+	if challenge(pending.ID).Status != ChallengeExpired {
+		t.Errorf("pending challenge should expire at its proposed time: got %+v", challenge(pending.ID))
+	}
+
+	accepted := create()
+	if status, _ := respond(accepted, team1.ID, CancelChallenge); status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusConflict)
+	}
+	respond(accepted, team2.ID, AcceptChallenge)
+	status, cancelled := respond(accepted, team1.ID, CancelChallenge)
+	if status != http.StatusOK || cancelled.Status != ChallengeCancelled {
+		t.Fatalf("handler returned unexpected cancellation: got %v %+v", status, cancelled)
+	}
+	if g := world.Game(cancelled.GameID); g == nil || g.Status != GameStatusCancelled {
+		t.Errorf("scheduled game was not cancelled: got %+v", g)
+	}
+	if status, _ := respond(accepted, team2.ID, CancelChallenge); status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusConflict)
+	}
+}
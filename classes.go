@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// ClassSummary sums up the stopped games of a player with a class (or hero)
+type ClassSummary struct {
+	Class   string  `json:"class"`
+	NbGames int     `json:"nbGames"`
+	NbWins  int     `json:"nbWins"`
+	WinRate float64 `json:"winRate"`
+	Stats   Stats   `json:"stats"`
+}
+
+// PlayerStats returns the stats of a player accumulated over his stopped
+// games of a season and in which he played a class. An empty season or class
+// means all seasons or all classes.
+func (w *World) PlayerStats(playerID, seasonID, class string) Stats {
+	var s Stats
+	for _, g := range w.StoppedGames() {
+		if seasonID != "" && g.SeasonID != seasonID {
+			continue
+		}
+		if p := g.Player(playerID); p != nil && (class == "" || p.Class == class) {
+			s.Add(p.Stats)
+		}
+	}
+	return s
+}
+
+// ClassSummaries returns the summary of each class played by a player in his
+// stopped games, the most played first
+func (w *World) ClassSummaries(playerID string) []ClassSummary {
+	index := map[string]int{}
+	summaries := []ClassSummary{}
+	for _, g := range w.StoppedGames() {
+		p := g.Player(playerID)
+		if p == nil || p.Class == "" {
+			continue
+		}
+		i, ok := index[p.Class]
+		if !ok {
+			i = len(summaries)
+			index[p.Class] = i
+			summaries = append(summaries, ClassSummary{Class: p.Class})
+		}
+		summaries[i].NbGames++
+		summaries[i].NbWins += p.Stats.TotalNbWins
+		summaries[i].Stats.Add(p.Stats)
+	}
+	for i := range summaries {
+		summaries[i].WinRate = ratio(float64(summaries[i].NbWins), float64(summaries[i].NbGames))
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].NbGames > summaries[j].NbGames
+	})
+	return summaries
+}
+
+// ClassAchievements evaluates the achievement rules on the stopped games of a
+// player with a class and returns the achievements he reached with it: game
+// achievements reached in one of these games and lifetime achievements reached
+// with his stats over these games.
+// Streaks and levels are not tracked per class, so rules relying on them are
+// not reached.
+func (w *World) ClassAchievements(playerID, class string) Achievements {
+	a := Achievements{}
+	for _, g := range w.StoppedGames() {
+		if p := g.Player(playerID); p != nil && p.Class == class {
+			for id, ok := range w.Rules.Calculate(ScopeGame, p.Stats) {
+				a[id] = a[id] || ok
+			}
+		}
+	}
+	vars := lifetimeVariables(w.PlayerStats(playerID, "", class), Streaks{}, Progression{})
+	for id, ok := range w.Rules.Evaluate(ScopeLifetime, vars) {
+		a[id] = ok
+	}
+	for id, ok := range a {
+		if !ok {
+			delete(a, id)
+		}
+	}
+	return a
+}
+
+// playedClass checks whether a player played a class in a game (any class if
+// the class is empty)
+func (w *World) playedClass(gameID, playerID, class string) bool {
+	if class == "" {
+		return true
+	}
+	if g := w.Game(gameID); g != nil {
+		if p := g.Player(playerID); p != nil {
+			return p.Class == class
+		}
+	}
+	return false
+}
+
+// classSelectionHandler records the class (or hero) played by a player in a
+// game, based on the class parameter, which must be the id of a hero of the
+// draft catalog.
+// The class can be changed until the game is stopped.
+func classSelectionHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Class could not be selected because of malformed PUT parameters"))
+		return
+	}
+	class := r.Form.Get("class")
+	if class == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Class could not be selected because of empty PUT parameter"))
+		return
+	}
+	if activeItem(class, "hero") == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Class could not be selected because it is not a hero of the catalog"))
+		return
+	}
+
+	vars := mux.Vars(r)
+	g := world.Game(vars["gameId"])
+	if g == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Game not found"))
+		return
+	}
+	p := g.Player(vars["playerId"])
+	if p == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Player not found"))
+		return
+	}
+	if g.Status == GameStatusStopped || g.Status == GameStatusCancelled {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Class could not be selected because this game is " + g.Status))
+		return
+	}
+
+	recordEvent(Event{Type: ClassSelected, GameID: g.ID, PlayerID: p.ID, Class: class})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(world.Game(g.ID).Player(p.ID))
+}
+
+// playerClassesHandler returns the number of games, win rate and stats of a
+// player with each class he played
+func playerClassesHandler(w http.ResponseWriter, r *http.Request) {
+	wd, err := requestedWorld(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Classes could not be listed because of malformed as_of parameter"))
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	if wd.Player(vars["playerId"]) == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Player not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wd.ClassSummaries(vars["playerId"]))
+}
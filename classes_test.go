@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// TestClasses tests that the class played by a player in each game is
+// recorded, that it must be a hero of the catalog, and that stats,
+// achievements, win rates and leaderboards can be restricted to a class
+func TestClasses(t *testing.T) {
+	defer func(catalog []DraftItem) { draftCatalog = catalog }(draftCatalog)
+	for _, id := range []string{"archer", "knight", "mage"} {
+		doRequest(t, "POST", "/admin/draft/items", url.Values{"id": {id}, "name": {id}, "kind": {"hero"}}, true)
+	}
+	doRequest(t, "POST", "/admin/draft/items", url.Values{"id": {"arena"}, "name": {"Arena"}, "kind": {"map"}}, true)
+	defer resetRules()
+	rule := AchievementRule{ID: "doubleKill", Name: "Double Kill", Metric: "nbKills", Tiers: []Tier{{Threshold: 2}}, Scope: ScopeLifetime}
+	if err := rule.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	recordEvent(Event{Type: RuleCreated, Rule: &rule})
+
+	team1 := newTestTeam(t, "Class Team 1", 3)
+	team2 := newTestTeam(t, "Class Team 2", 3)
+	player := team1.Players[0]
+	var games []Game
+	for i, class := range []string{"mage", "knight"} {
+		rr := doRequest(t, "POST", "/games", url.Values{"name": {"Class Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}, "mode": {"classes"}}, false)
+		var g Game
+		json.Unmarshal(rr.Body.Bytes(), &g)
+		for _, invalid := range []string{"arena", "necromancer"} {
+			rr = doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/class", g.ID, player.ID), url.Values{"class": {invalid}}, false)
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Errorf("handler returned wrong status code for a class which is not a hero: got %v want %v",
+					status, http.StatusBadRequest)
+			}
+		}
+		rr = doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/class", g.ID, player.ID), url.Values{"class": {class}}, false)
+		var p Player
+		json.Unmarshal(rr.Body.Bytes(), &p)
+		if rr.Code != http.StatusOK || p.Class != class {
+			t.Fatalf("handler returned unexpected player: got %v %+v", rr.Code, p)
+		}
+		for j := 0; j < 2-i; j++ {
+			doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, player.ID), url.Values{"name": {"nbKills"}}, false)
+		}
+		winnerID := team1.ID
+		if i == 1 {
+			winnerID = team2.ID
+		}
+		doRequest(t, "PUT", "/games/"+g.ID, url.Values{"teamId": {winnerID}}, false)
+		games = append(games, g)
+	}
+
+	rr := doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/class", games[0].ID, player.ID), url.Values{"class": {"archer"}}, false)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for a stopped game: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+
+	rr = doRequest(t, "GET", fmt.Sprintf("/players/%s?class=mage", player.ID), nil, false)
+	var p Player
+	json.Unmarshal(rr.Body.Bytes(), &p)
+	if p.Stats.NbKills != 2 || p.Stats.TotalNbGamesPlayed != 1 || p.Stats.TotalNbWins != 1 {
+		t.Errorf("handler returned unexpected class stats: got %+v", p.Stats)
+	}
+	if !p.Achievements["doubleKill"] {
+		t.Errorf("handler did not return an achievement reached with the class: got %+v", p.Achievements)
+	}
+	rr = doRequest(t, "GET", fmt.Sprintf("/players/%s?class=knight", player.ID), nil, false)
+	p = Player{}
+	json.Unmarshal(rr.Body.Bytes(), &p)
+	if p.Achievements["doubleKill"] {
+		t.Errorf("handler returned an achievement not reached with the class: got %+v", p.Achievements)
+	}
+
+	rr = doRequest(t, "GET", fmt.Sprintf("/players/%s/classes", player.ID), nil, false)
+	var summaries []ClassSummary
+	json.Unmarshal(rr.Body.Bytes(), &summaries)
+	if len(summaries) != 2 || summaries[0].Class != "mage" || summaries[0].WinRate != 1 ||
+		summaries[1].Class != "knight" || summaries[1].WinRate != 0 || summaries[1].Stats.NbKills != 1 {
+		t.Errorf("handler returned unexpected class summaries: got %+v", summaries)
+	}
+
+	rr = doRequest(t, "GET", fmt.Sprintf("/players/%s/achievements?class=knight", player.ID), nil, false)
+	var unlocks []Unlock
+	json.Unmarshal(rr.Body.Bytes(), &unlocks)
+	for _, u := range unlocks {
+		if u.GameID != games[1].ID {
+			t.Errorf("handler returned an achievement unlocked with another class: got %+v", u)
+		}
+	}
+
+	rr = doRequest(t, "GET", "/leaderboards/nbKills?mode=classes&class=knight", nil, false)
+	var page LeaderboardPage
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	if page.Total != 1 || page.Entries[0].ID != player.ID || page.Entries[0].Value != 1 {
+		t.Errorf("handler returned unexpected class leaderboard: got %+v", page)
+	}
+	rr = doRequest(t, "GET", "/leaderboards/nbKills?entity=teams&class=knight", nil, false)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
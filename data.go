@@ -1,38 +1,20 @@
 package main
 
 import (
+	"sort"
 	"time"
 )
 
-// AchievementsCalculator is an interface for player achievements calculation
+// AchievementsCalculator is an interface for player achievements calculation.
+// It returns the achievements of the scope provided (see achievements.go),
+// reached or not, with the stats provided.
 type AchievementsCalculator interface {
-	Calculate(Stats)
+	Calculate(scope string, stats Stats) Achievements
 }
 
 // Achievements represents which achievements have been
-// reached by a player
-type Achievements struct {
-	Sharpshooter bool `json:"sharpshooter"`
-	Bruiser      bool `json:"bruiser"`
-	Veteran      bool `json:"veteran"`
-	BigWinner    bool `json:"bigWinner"`
-}
-
-// CalculateAchievements calculates the achievements of a player
-func (a *Achievements) CalculateAchievements(stats Stats) {
-	if stats.NbHits != 0 && float64(stats.NbHits/stats.NbAttemptedAttacks) >= 0.75 {
-		a.Sharpshooter = true
-	}
-	if stats.DamageDone+stats.SpellDamageDone >= 500 {
-		a.Bruiser = true
-	}
-	if stats.TotalNbGamesPlayed >= 1000 {
-		a.Veteran = true
-	}
-	if stats.TotalNbWins >= 200 {
-		a.BigWinner = true
-	}
-}
+// reached by a player, indexed by achievement id
+type Achievements map[string]bool
 
 // StatsIncrementer is an interface for player stat
 // incrementation
@@ -63,6 +45,21 @@ func (s *Stats) CalculateGlobalStats(gameDuration int) {
 	s.TotalNbGamesPlayed++
 }
 
+// Add adds other stats to the stats
+func (s *Stats) Add(other Stats) {
+	s.NbAttemptedAttacks += other.NbAttemptedAttacks
+	s.NbHits += other.NbHits
+	s.DamageDone += other.DamageDone
+	s.NbKills += other.NbKills
+	s.NbFirstHitKills += other.NbFirstHitKills
+	s.NbAssists += other.NbAssists
+	s.NbSpellCasts += other.NbSpellCasts
+	s.SpellDamageDone += other.SpellDamageDone
+	s.TotalTimePlayedInSeconds += other.TotalTimePlayedInSeconds
+	s.TotalNbGamesPlayed += other.TotalNbGamesPlayed
+	s.TotalNbWins += other.TotalNbWins
+}
+
 // IncrementStats increments one of the player stats based on
 // the stat name provided
 func (s *Stats) IncrementStats(statName string) bool {
@@ -89,19 +86,77 @@ func (s *Stats) IncrementStats(statName string) bool {
 	return true
 }
 
+// Stat returns the value of one of the player stats based on
+// the stat name provided, and reports whether the stat exists
+func (s *Stats) Stat(statName string) (int, bool) {
+	switch statName {
+	case "nbAttemptedAttacks":
+		return s.NbAttemptedAttacks, true
+	case "nbHits":
+		return s.NbHits, true
+	case "damageDone":
+		return s.DamageDone, true
+	case "nbKills":
+		return s.NbKills, true
+	case "nbFirstHitKills":
+		return s.NbFirstHitKills, true
+	case "nbAssists":
+		return s.NbAssists, true
+	case "nbSpellCasts":
+		return s.NbSpellCasts, true
+	case "spellDamageDone":
+		return s.SpellDamageDone, true
+	}
+	return 0, false
+}
+
+// SetStat sets one of the player stats to the value provided.
+// Only stats that can be incremented can be set.
+func (s *Stats) SetStat(statName string, value int) bool {
+	switch statName {
+	case "nbAttemptedAttacks":
+		s.NbAttemptedAttacks = value
+	case "nbHits":
+		s.NbHits = value
+	case "damageDone":
+		s.DamageDone = value
+	case "nbKills":
+		s.NbKills = value
+	case "nbFirstHitKills":
+		s.NbFirstHitKills = value
+	case "nbAssists":
+		s.NbAssists = value
+	case "nbSpellCasts":
+		s.NbSpellCasts = value
+	case "spellDamageDone":
+		s.SpellDamageDone = value
+	default:
+		return false
+	}
+	return true
+}
+
 // Player represents a game player within a team
 type Player struct {
 	ID           string       `json:"id"`
 	Pseudo       string       `json:"pseudo"`
+	Role         string       `json:"role,omitempty"`
+	Class        string       `json:"class,omitempty"`
 	Stats        Stats        `json:"stats"`
 	Achievements Achievements `json:"achievements"`
+	XP           int          `json:"xp"`
+	Level        int          `json:"level"`
 }
 
 // Team represents a gaming team of players
 type Team struct {
-	ID      string   `json:"id"`
-	Name    string   `json:"name"`
-	Players []Player `json:"players"`
+	ID           string       `json:"id"`
+	Name         string       `json:"name"`
+	Players      []Player     `json:"players"`
+	Achievements Achievements `json:"achievements,omitempty"`
+	// Ephemeral teams are the rosters of a single game (e.g. formed by the
+	// matchmaker), removed once the game is over
+	Ephemeral bool `json:"ephemeral,omitempty"`
 }
 
 // AddPlayer adds a player to the team
@@ -126,12 +181,19 @@ func (t *Team) RemovePlayer(id string) (bool, []Player) {
 // MarkAsWinner increments all the TotalNbWins of the players
 // of the team
 func (t *Team) MarkAsWinner() []Player {
-	for _, p := range t.Players {
-		p.Stats.TotalNbWins++
+	for i := range t.Players {
+		t.Players[i].Stats.TotalNbWins++
 	}
 	return t.Players
 }
 
+// Copy returns a copy of the team that does not share its players
+// with the original team
+func (t Team) Copy() Team {
+	t.Players = append([]Player(nil), t.Players...)
+	return t
+}
+
 // Game represents a game matching 2 teams of equal sizes
 // with a limited duration
 type Game struct {
@@ -139,10 +201,33 @@ type Game struct {
 	Team1     Team      `json:"team1"`
 	Team2     Team      `json:"team2"`
 	Name      string    `json:"name"`
+	Mode      string    `json:"mode"`
+	MapID     string    `json:"mapId,omitempty"`
+	Status    string    `json:"status"`
 	StartTime time.Time `json:"startTime"`
 	StopTime  time.Time `json:"stopTime"`
+	WinnerID  string    `json:"winnerId,omitempty"`
+	SeasonID  string    `json:"seasonId,omitempty"`
+
+	// Players who joined the lobby of the game, and who are ready
+	JoinedPlayerIDs []string `json:"joinedPlayerIds,omitempty"`
+	ReadyPlayerIDs  []string `json:"readyPlayerIds,omitempty"`
+
+	// Items banned and picked by the teams during the draft of the game
+	Bans  []DraftChoice `json:"bans,omitempty"`
+	Picks []DraftChoice `json:"picks,omitempty"`
 }
 
+// Game statuses.
+// Scheduled games and games in lobby have no start time yet.
+const (
+	GameStatusScheduled = "scheduled"
+	GameStatusLobby     = "lobby"
+	GameStatusRunning   = "running"
+	GameStatusStopped   = "stopped"
+	GameStatusCancelled = "cancelled"
+)
+
 // TeamSizesAreValid checks that game teams have the right size (3 to 5 players)
 // and both the same size
 func (g *Game) TeamSizesAreValid() bool {
@@ -152,35 +237,272 @@ func (g *Game) TeamSizesAreValid() bool {
 	return false
 }
 
-// Stop stops the game by filling in the stop time and computes the duration
+// IsDraw checks whether the game stopped without a winner
+func (g *Game) IsDraw() bool {
+	return !g.StopTime.IsZero() && g.WinnerID == ""
+}
+
+// Score returns the score of a team in the game: the number of kills of its
+// players
+func (g *Game) Score(teamID string) int {
+	score := 0
+	for _, team := range []Team{g.Team1, g.Team2} {
+		if team.ID == teamID {
+			for _, p := range team.Players {
+				score += p.Stats.NbKills
+			}
+		}
+	}
+	return score
+}
+
+// Player returns the player of one of the 2 teams matching the id
+// provided, or nil if the player is not part of this game
+func (g *Game) Player(id string) *Player {
+	for i := range g.Team1.Players {
+		if g.Team1.Players[i].ID == id {
+			return &g.Team1.Players[i]
+		}
+	}
+	for i := range g.Team2.Players {
+		if g.Team2.Players[i].ID == id {
+			return &g.Team2.Players[i]
+		}
+	}
+	return nil
+}
+
+// Stop stops the game at the time provided and computes the duration
 // in seconds.
-// It also updates all the players' TotalTimePlayedInSeconds, TotalNbGamesPlayed
-// and TotalNbWins stat.
-// It also calculates all the players achievements.
-func (g *Game) Stop() {
+// It marks the players of the winning team as winners, and updates all
+// the players' TotalTimePlayedInSeconds, TotalNbGamesPlayed and TotalNbWins stat.
+// Achievements are calculated afterwards by the world, as some of them
+// depend on the other games of the players.
+func (g *Game) Stop(winnerID string, stopTime time.Time) {
+	// Increment the TotalNbWins of the players of the winning team
+	g.WinnerID = winnerID
+	if winnerID == g.Team1.ID {
+		g.Team1.MarkAsWinner()
+	} else if winnerID == g.Team2.ID {
+		g.Team2.MarkAsWinner()
+	}
+
 	// Fill the stop time
-	g.StopTime = time.Now()
+	g.StopTime = stopTime
+	g.Status = GameStatusStopped
 
 	// Compute the duration and convert it to seconds
 	gameDuration := int(g.StopTime.Sub(g.StartTime) / time.Second)
 
 	// Update all the players TotalTimePlayedInSeconds and TotalNbGamesPlayed stats
-	// and calculate their achievements
-	var players1, players2 []Player
-	for _, p := range g.Team1.Players {
-		p.Stats.CalculateGlobalStats(gameDuration)
-		p.Achievements.CalculateAchievements(p.Stats)
-		players1 = append(players1, p)
+	for _, players := range [][]Player{g.Team1.Players, g.Team2.Players} {
+		for i := range players {
+			players[i].Stats.CalculateGlobalStats(gameDuration)
+		}
+	}
+}
+
+// World holds the current list of teams and list of games.
+// It is never modified directly but built by applying the events of the
+// event log (see events.go).
+type World struct {
+	Teams       []Team
+	Games       []Game
+	Unlocks     []Unlock
+	TeamUnlocks []TeamUnlock
+	Streaks     map[string]Streaks
+	Progression map[string]Progression
+	LevelUps    []LevelUp
+	Ratings     map[string]map[string]Rating
+	RatingLog   []RatingChange
+	Predictions map[string]Prediction
+	Seasons     []Season
+
+	// Indexes (in Games) of the stopped games of each player and team, in
+	// the order they were stopped, and lifetime stats of each player. They
+	// are kept up to date when games stop, so that the games of a player or
+	// team are never looked for among all the games.
+	PlayerGameIndexes map[string][]int
+	TeamGameIndexes   map[string][]int
+	Lifetime          map[string]Stats
+
+	// Game in lobby or running of each player, if any
+	ActiveGames map[string]string
+
+	// Indexes (in RatingLog) of the rating changes of each player and team,
+	// in the order they happened
+	RatingIndexes map[string][]int
+
+	// RankedVersion changes each time the data ranked by leaderboards
+	// (stopped games, rosters, ratings, experience) changes, so that cached
+	// leaderboards are not calculated again on every stat increment
+	RankedVersion int
+
+	// Achievement rules and XP config in force, and highest level reached
+	// by each player
+	Rules         RulesEngine
+	XPConfig      XPConfig
+	HighestLevels map[string]int
+
+	// Levels reached for the first time while applying the last event, to
+	// be recorded as LevelReached events
+	levelUps []LevelUp
+}
+
+// newWorld returns an empty world, with the default achievement rules and
+// XP config
+func newWorld() *World {
+	return &World{Rules: RulesEngine{Rules: defaultAchievementRules()}, XPConfig: defaultXPConfig()}
+}
+
+// Team returns the team matching the id provided, or nil if not found
+func (w *World) Team(id string) *Team {
+	for i := range w.Teams {
+		if w.Teams[i].ID == id {
+			return &w.Teams[i]
+		}
+	}
+	return nil
+}
+
+// Player returns the player matching the id provided, looking in teams first
+// and then in games for players who left their team, or nil if not found
+func (w *World) Player(id string) *Player {
+	for i := range w.Teams {
+		for j := range w.Teams[i].Players {
+			if w.Teams[i].Players[j].ID == id {
+				return &w.Teams[i].Players[j]
+			}
+		}
+	}
+	for i := range w.Games {
+		if p := w.Games[i].Player(id); p != nil {
+			return p
+		}
 	}
-	g.Team1.Players = players1
-	for _, p := range g.Team2.Players {
-		p.Stats.CalculateGlobalStats(gameDuration)
-		p.Achievements.CalculateAchievements(p.Stats)
-		players2 = append(players2, p)
+	return nil
+}
+
+// Game returns the game matching the id provided, or nil if not found
+func (w *World) Game(id string) *Game {
+	for i := range w.Games {
+		if w.Games[i].ID == id {
+			return &w.Games[i]
+		}
+	}
+	return nil
+}
+
+// updateActiveGame keeps the active game of the players of a game up to date
+// after a change of its status, and removes its ephemeral teams once it is
+// over
+func (w *World) updateActiveGame(g *Game) {
+	if w.ActiveGames == nil {
+		w.ActiveGames = map[string]string{}
 	}
-	g.Team2.Players = players2
+	active := g.Status == GameStatusLobby || g.Status == GameStatusRunning
+	over := g.Status == GameStatusStopped || g.Status == GameStatusCancelled
+	for _, t := range []Team{g.Team1, g.Team2} {
+		for _, p := range t.Players {
+			if active {
+				w.ActiveGames[p.ID] = g.ID
+			} else if w.ActiveGames[p.ID] == g.ID {
+				delete(w.ActiveGames, p.ID)
+			}
+		}
+		if over {
+			if team := w.Team(t.ID); team != nil && team.Ephemeral {
+				w.removeTeam(t.ID)
+			}
+		}
+	}
+}
+
+// removeTeam removes the team matching the id provided
+func (w *World) removeTeam(id string) {
+	for i, t := range w.Teams {
+		if t.ID == id {
+			w.Teams = append(w.Teams[:i], w.Teams[i+1:]...)
+			return
+		}
+	}
+}
+
+// StoppedGames returns all the stopped games, in the order they were stopped
+func (w *World) StoppedGames() []*Game {
+	var stopped []*Game
+	for i := range w.Games {
+		if !w.Games[i].StopTime.IsZero() {
+			stopped = append(stopped, &w.Games[i])
+		}
+	}
+	sort.SliceStable(stopped, func(i, j int) bool {
+		return stopped[i].StopTime.Before(stopped[j].StopTime)
+	})
+	return stopped
+}
+
+// PlayerGames returns the stopped games of a player, in the order they were
+// stopped
+func (w *World) PlayerGames(playerID string) []*Game {
+	var games []*Game
+	for _, i := range w.PlayerGameIndexes[playerID] {
+		games = append(games, &w.Games[i])
+	}
+	return games
+}
+
+// TeamGames returns the stopped games of a team, in the order they were
+// stopped
+func (w *World) TeamGames(teamID string) []*Game {
+	var games []*Game
+	for _, i := range w.TeamGameIndexes[teamID] {
+		games = append(games, &w.Games[i])
+	}
+	return games
+}
+
+// indexStoppedGame adds a game which just stopped to the stopped games of
+// its teams and players, and adds it to their lifetime stats and streaks
+func (w *World) indexStoppedGame(g *Game) {
+	if w.PlayerGameIndexes == nil {
+		w.PlayerGameIndexes = map[string][]int{}
+		w.TeamGameIndexes = map[string][]int{}
+		w.Lifetime = map[string]Stats{}
+		w.Streaks = map[string]Streaks{}
+	}
+	i := 0
+	for i = range w.Games {
+		if &w.Games[i] == g {
+			break
+		}
+	}
+
+	for _, team := range []Team{g.Team1, g.Team2} {
+		w.TeamGameIndexes[team.ID] = append(w.TeamGameIndexes[team.ID], i)
+		for _, p := range team.Players {
+			w.PlayerGameIndexes[p.ID] = append(w.PlayerGameIndexes[p.ID], i)
+			lifetime := w.Lifetime[p.ID]
+			lifetime.Add(p.Stats)
+			w.Lifetime[p.ID] = lifetime
+			s := w.Streaks[p.ID]
+			s.AddGame(p.Stats.TotalNbWins > 0, p.Stats.NbFirstHitKills > 0, g.StopTime)
+			w.Streaks[p.ID] = s
+		}
+	}
+}
+
+// correctStoppedGame updates the lifetime stats and streaks of a player
+// after the correction of one of his stats in a stopped game, given the
+// value of the stat before the correction
+func (w *World) correctStoppedGame(p *Player, stat string, old int) {
+	lifetime := w.Lifetime[p.ID]
+	v, _ := lifetime.Stat(stat)
+	corrected, _ := p.Stats.Stat(stat)
+	lifetime.SetStat(stat, v-old+corrected)
+	w.Lifetime[p.ID] = lifetime
+	w.UpdateStreaks(p.ID)
 }
 
-// Init current list of teams and list of games
-var teams []Team
-var games []Game
+// Init the current world
+var world = newWorld()
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Draft actions
+const (
+	Ban  = "ban"
+	Pick = "pick"
+)
+
+// defaultDraftSequence is the pick-ban sequence of a draft by default: each
+// team bans an item, then teams pick 2 items each in a snake order
+const defaultDraftSequence = "ban:1,ban:2,pick:1,pick:2,pick:2,pick:1"
+
+// defaultTurnTime is the time given by default to a team to play its turn
+const defaultTurnTime = 30 * time.Second
+
+// DraftItem is an item of the catalog which can be picked or banned in a
+// draft, e.g. a hero or a map.
+// Deleted items are kept so that the stats of the games in which they were
+// used can still be retrieved, but they can no longer be chosen.
+type DraftItem struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`
+	Description string `json:"description,omitempty"`
+	Deleted     bool   `json:"deleted,omitempty"`
+}
+
+// DraftChoice is an item picked or banned by a team
+type DraftChoice struct {
+	TeamID string `json:"teamId"`
+	ItemID string `json:"itemId"`
+}
+
+// DraftStep is a turn of a draft: the action and the team (1 or 2) playing it
+type DraftStep struct {
+	Action string `json:"action"`
+	Team   int    `json:"team"`
+}
+
+// Draft holds the settings and the progress of the draft of a game: the
+// pick-ban sequence, the kind of items drafted (all kinds if empty), the time
+// given to each turn, the current step and its deadline.
+// A team which does not play in time loses its ban, or is given the first
+// item available for a pick.
+type Draft struct {
+	GameID       string      `json:"gameId"`
+	Kind         string      `json:"kind,omitempty"`
+	Sequence     []DraftStep `json:"sequence"`
+	TurnTime     int         `json:"turnTime"`
+	Step         int         `json:"step"`
+	TurnDeadline time.Time   `json:"turnDeadline"`
+}
+
+// DraftStatus is the state of the draft of a game
+type DraftStatus struct {
+	*Draft
+	Done      bool          `json:"done"`
+	Turn      *DraftStep    `json:"turn,omitempty"`
+	TurnTeam  string        `json:"turnTeamId,omitempty"`
+	Bans      []DraftChoice `json:"bans"`
+	Picks     []DraftChoice `json:"picks"`
+	Available []DraftItem   `json:"available"`
+}
+
+// ItemStats sums up the games in which an item was picked or banned.
+// Stats are the stats of the players of the teams which picked the item
+// added together.
+type ItemStats struct {
+	ItemID   string  `json:"itemId"`
+	Name     string  `json:"name"`
+	NbPicks  int     `json:"nbPicks"`
+	NbBans   int     `json:"nbBans"`
+	NbWins   int     `json:"nbWins"`
+	WinRate  float64 `json:"winRate"`
+	PickRate float64 `json:"pickRate"`
+	BanRate  float64 `json:"banRate"`
+	Stats    Stats   `json:"stats"`
+}
+
+// Init the catalog of draft items.
+// As tournaments, the catalog is not part of the event log.
+var draftCatalog []DraftItem
+
+// Init drafts by game id.
+// As lobby settings, drafts are not part of the event log: only the items
+// picked and banned are. They are dropped once the game leaves the lobby.
+var drafts = map[string]*Draft{}
+
+// draftItem returns the item of the catalog with the id provided, or nil if
+// not found
+func draftItem(id string) *DraftItem {
+	for i := range draftCatalog {
+		if draftCatalog[i].ID == id {
+			return &draftCatalog[i]
+		}
+	}
+	return nil
+}
+
+// activeItem returns the item of the catalog with the id and of the kind
+// provided (any kind if empty), or nil if not found or deleted
+func activeItem(id, kind string) *DraftItem {
+	item := draftItem(id)
+	if item == nil || item.Deleted || (kind != "" && item.Kind != kind) {
+		return nil
+	}
+	return item
+}
+
+// deleteItem soft-deletes the item of the catalog with the id and of the kind
+// provided (any kind if empty), and checks whether it was found
+func deleteItem(id, kind string) bool {
+	item := activeItem(id, kind)
+	if item == nil {
+		return false
+	}
+	item.Deleted = true
+	return true
+}
+
+// parseDraftSequence parses a pick-ban sequence made up of comma separated
+// steps such as ban:1 or pick:2
+func parseDraftSequence(sequence string) ([]DraftStep, error) {
+	var steps []DraftStep
+	for _, s := range strings.Split(sequence, ",") {
+		parts := strings.Split(strings.TrimSpace(s), ":")
+		if len(parts) != 2 || (parts[0] != Ban && parts[0] != Pick) || (parts[1] != "1" && parts[1] != "2") {
+			return nil, fmt.Errorf("malformed draft step %q", s)
+		}
+		team, _ := strconv.Atoi(parts[1])
+		steps = append(steps, DraftStep{Action: parts[0], Team: team})
+	}
+	return steps, nil
+}
+
+// Done checks whether all the steps of a draft were played
+func (d *Draft) Done() bool {
+	return d.Step >= len(d.Sequence)
+}
+
+// teamID returns the id of the team playing a step of the draft of a game
+func (s DraftStep) teamID(g *Game) string {
+	if s.Team == 1 {
+		return g.Team1.ID
+	}
+	return g.Team2.ID
+}
+
+// Available returns the items of the catalog of the kind of a draft which
+// were neither deleted, picked nor banned in a game
+func (d *Draft) Available(g *Game) []DraftItem {
+	taken := map[string]bool{}
+	for _, c := range append(append([]DraftChoice{}, g.Bans...), g.Picks...) {
+		taken[c.ItemID] = true
+	}
+	available := []DraftItem{}
+	for _, item := range draftCatalog {
+		if !item.Deleted && !taken[item.ID] && (d.Kind == "" || item.Kind == d.Kind) {
+			available = append(available, item)
+		}
+	}
+	return available
+}
+
+// play records the action of the current step of a draft with the item
+// provided (none to skip a ban), and moves to the next step. A map picked
+// becomes the map the game is played on. Once the draft is done, the game
+// starts if all its players are ready.
+func (d *Draft) play(g *Game, itemID string, now time.Time) {
+	step := d.Sequence[d.Step]
+	if itemID != "" {
+		e := Event{Type: ItemBanned, GameID: g.ID, TeamID: step.teamID(g), ItemID: itemID}
+		if step.Action == Pick {
+			e.Type = ItemPicked
+			if item := draftItem(itemID); item != nil && item.Kind == "map" {
+				e.MapID = itemID
+			}
+		}
+		recordEvent(e)
+	}
+	d.Step++
+	d.TurnDeadline = now.Add(time.Duration(d.TurnTime) * time.Second)
+	if g := world.Game(d.GameID); d.Done() && g.Status == GameStatusLobby && g.AllReady() {
+		recordEvent(Event{Type: GameStarted, GameID: g.ID})
+		closeLobby(g.ID)
+	}
+}
+
+// draftDone checks whether the draft of a game, if any, is done
+func draftDone(gameID string) bool {
+	d, ok := drafts[gameID]
+	return !ok || d.Done()
+}
+
+// status returns the state of the draft of a game
+func (d *Draft) status(g *Game) DraftStatus {
+	s := DraftStatus{Draft: d, Done: d.Done(), Bans: []DraftChoice{}, Picks: []DraftChoice{}, Available: d.Available(g)}
+	s.Bans = append(s.Bans, g.Bans...)
+	s.Picks = append(s.Picks, g.Picks...)
+	if !s.Done {
+		step := d.Sequence[d.Step]
+		s.Turn = &step
+		s.TurnTeam = step.teamID(g)
+	}
+	return s
+}
+
+// timeOut plays the current turn of a draft as if it timed out at the time
+// provided: a ban is skipped, and the first item available is picked
+func (d *Draft) timeOut(g *Game, now time.Time) {
+	itemID := ""
+	if available := d.Available(g); d.Sequence[d.Step].Action == Pick && len(available) > 0 {
+		itemID = available[0].ID
+	}
+	log.Printf("Draft turn %d of game %s timed out", d.Step+1, g.Name)
+	d.play(g, itemID, now)
+}
+
+// finish plays all the remaining turns of a draft as if they timed out at
+// the time provided
+func (d *Draft) finish(g *Game, now time.Time) {
+	for !d.Done() {
+		d.timeOut(g, now)
+		g = world.Game(d.GameID)
+	}
+}
+
+// expireDraftTurns plays the turns of the drafts which timed out at the time
+// provided, and drops the drafts of the games which left the lobby
+func expireDraftTurns(now time.Time) {
+	for id, d := range drafts {
+		g := world.Game(id)
+		if g == nil || g.Status != GameStatusLobby {
+			delete(drafts, id)
+			continue
+		}
+		for !d.Done() && !now.Before(d.TurnDeadline) {
+			d.timeOut(g, d.TurnDeadline)
+			g = world.Game(id)
+		}
+	}
+}
+
+// ItemStats calculates the stats of each item of the catalog over the
+// stopped games of a mode (all modes if empty)
+func (w *World) ItemStats(mode string) []ItemStats {
+	index := map[string]int{}
+	all := []ItemStats{}
+	for _, item := range draftCatalog {
+		index[item.ID] = len(all)
+		all = append(all, ItemStats{ItemID: item.ID, Name: item.Name})
+	}
+
+	nbGames := 0
+	for _, g := range w.StoppedGames() {
+		if (mode != "" && g.Mode != mode) || len(g.Picks)+len(g.Bans) == 0 {
+			continue
+		}
+		nbGames++
+		for _, b := range g.Bans {
+			if i, ok := index[b.ItemID]; ok {
+				all[i].NbBans++
+			}
+		}
+		for _, p := range g.Picks {
+			i, ok := index[p.ItemID]
+			if !ok {
+				continue
+			}
+			all[i].NbPicks++
+			if g.WinnerID == p.TeamID {
+				all[i].NbWins++
+			}
+			for _, team := range []Team{g.Team1, g.Team2} {
+				if team.ID == p.TeamID {
+					for _, player := range team.Players {
+						all[i].Stats.Add(player.Stats)
+					}
+				}
+			}
+		}
+	}
+
+	for i := range all {
+		all[i].WinRate = ratio(float64(all[i].NbWins), float64(all[i].NbPicks))
+		all[i].PickRate = ratio(float64(all[i].NbPicks), float64(nbGames))
+		all[i].BanRate = ratio(float64(all[i].NbBans), float64(nbGames))
+	}
+	return all
+}
+
+// draftCreationHandler attaches a draft to a game in lobby based on the
+// sequence (comma separated steps such as ban:1 or pick:2), turnTime (in
+// seconds, 30 by default) and kind (kind of the items drafted, all kinds by
+// default) parameters
+func draftCreationHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Draft could not be created because of malformed POST parameters"))
+		return
+	}
+
+	g := world.Game(mux.Vars(r)["gameId"])
+	if g == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Game not found"))
+		return
+	}
+	if g.Status != GameStatusLobby {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Draft could not be created because the game is not in lobby"))
+		return
+	}
+	if _, ok := drafts[g.ID]; ok {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Draft could not be created because the game already has one"))
+		return
+	}
+
+	sequence := r.Form.Get("sequence")
+	if sequence == "" {
+		sequence = defaultDraftSequence
+	}
+	steps, err := parseDraftSequence(sequence)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Draft could not be created because of malformed sequence parameter"))
+		return
+	}
+	turnTime := int(defaultTurnTime / time.Second)
+	if v := r.Form.Get("turnTime"); v != "" {
+		turnTime, err = strconv.Atoi(v)
+		if err != nil || turnTime < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Draft could not be created because of malformed turnTime parameter"))
+			return
+		}
+	}
+
+	d := &Draft{
+		GameID:       g.ID,
+		Kind:         r.Form.Get("kind"),
+		Sequence:     steps,
+		TurnTime:     turnTime,
+		TurnDeadline: time.Now().Add(time.Duration(turnTime) * time.Second),
+	}
+	drafts[g.ID] = d
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.status(g))
+}
+
+// draftTurnHandler plays the current turn of the draft of a game on behalf of
+// the team provided by the teamId parameter, by picking or banning the item
+// provided by the itemId parameter
+func draftTurnHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Draft turn could not be played because of malformed PUT parameters"))
+		return
+	}
+
+	g := world.Game(mux.Vars(r)["gameId"])
+	d, ok := drafts[mux.Vars(r)["gameId"]]
+	if g == nil || !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Draft not found"))
+		return
+	}
+	if d.Done() || g.Status != GameStatusLobby {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Draft is over"))
+		return
+	}
+	if r.Form.Get("teamId") != d.Sequence[d.Step].teamID(g) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Draft turn could not be played because it is the turn of the other team"))
+		return
+	}
+
+	itemID := r.Form.Get("itemId")
+	available := false
+	for _, item := range d.Available(g) {
+		if item.ID == itemID {
+			available = true
+		}
+	}
+	if !available {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Draft turn could not be played because the item is not available"))
+		return
+	}
+
+	d.play(g, itemID, time.Now())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.status(world.Game(g.ID)))
+}
+
+// draftRetrievalHandler returns the state of the draft of a game in lobby.
+// Drafts are dropped once their game leaves the lobby: the items banned and
+// picked remain part of the game.
+func draftRetrievalHandler(w http.ResponseWriter, r *http.Request) {
+	g := world.Game(mux.Vars(r)["gameId"])
+	d, ok := drafts[mux.Vars(r)["gameId"]]
+	if g == nil || !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Draft not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.status(g))
+}
+
+// draftCatalogHandler lists the items of the draft catalog which are not
+// deleted, optionally filtered by the kind query parameter
+func draftCatalogHandler(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	items := []DraftItem{}
+	for _, item := range draftCatalog {
+		if !item.Deleted && (kind == "" || item.Kind == kind) {
+			items = append(items, item)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// draftItemCreationHandler adds an item to the draft catalog based on the
+// id, name, kind and optional description parameters.
+// The id of a deleted item cannot be reused.
+func draftItemCreationHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Item could not be created because of malformed POST parameters"))
+		return
+	}
+	item := DraftItem{ID: r.Form.Get("id"), Name: r.Form.Get("name"), Kind: r.Form.Get("kind"), Description: r.Form.Get("description")}
+	if item.ID == "" || item.Name == "" || item.Kind == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Item could not be created because of empty POST parameter"))
+		return
+	}
+	if draftItem(item.ID) != nil {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Item could not be created because an item with the same id already exists"))
+		return
+	}
+
+	draftCatalog = append(draftCatalog, item)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(item)
+}
+
+// draftItemDeletionHandler deletes an item of the draft catalog.
+// Games in which the item was already drafted keep it.
+func draftItemDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	if !deleteItem(mux.Vars(r)["id"], "") {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Item not found"))
+		return
+	}
+
+	w.Write([]byte("Item successfully deleted"))
+}
+
+// itemStatsHandler returns the stats of each item of the draft catalog (pick,
+// ban and win rates, and the stats of the teams which picked it), optionally
+// restricted to the mode provided by the mode query parameter
+func itemStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(world.ItemStats(r.URL.Query().Get("mode")))
+}
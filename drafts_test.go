@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestDrafts tests a pick-ban draft: turn order, validation against the
+// catalog, picks made when a turn times out, the game starting once the
+// draft is done, and the stats of the items drafted
+func TestDrafts(t *testing.T) {
+	defer func(catalog []DraftItem) { draftCatalog = catalog }(draftCatalog)
+	for _, id := range []string{"archer", "knight", "mage"} {
+		rr := doRequest(t, "POST", "/admin/draft/items", url.Values{"id": {id}, "name": {id}, "kind": {"hero"}}, true)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+	}
+	doRequest(t, "POST", "/admin/draft/items", url.Values{"id": {"arena"}, "name": {"Arena"}, "kind": {"map"}}, true)
+
+	team1 := newTestTeam(t, "Draft Team 1", 3)
+	team2 := newTestTeam(t, "Draft Team 2", 3)
+	rr := doRequest(t, "POST", "/games", url.Values{"name": {"Draft Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}, "mode": {"draft"}, "lobby": {"true"}}, false)
+	var g Game
+	json.Unmarshal(rr.Body.Bytes(), &g)
+	rr = doRequest(t, "POST", fmt.Sprintf("/games/%s/draft", g.ID), url.Values{"sequence": {"ban:1,pick:2,pick:1"}, "kind": {"hero"}}, false)
+	var d DraftStatus
+	json.Unmarshal(rr.Body.Bytes(), &d)
+	if rr.Code != http.StatusOK || d.TurnTeam != team1.ID || len(d.Available) != 3 {
+		t.Fatalf("handler returned unexpected draft: got %v %+v", rr.Code, d)
+	}
+
+	play := func(teamID, itemID string) int {
+		rr := doRequest(t, "PUT", fmt.Sprintf("/games/%s/draft", g.ID), url.Values{"teamId": {teamID}, "itemId": {itemID}}, false)
+		return rr.Code
+	}
+	if status := play(team2.ID, "archer"); status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code out of turn: got %v want %v", status, http.StatusConflict)
+	}
+	if status := play(team1.ID, "archer"); status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	for _, itemID := range []string{"archer", "arena", "dragon"} {
+		if status := play(team2.ID, itemID); status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code for item %s: got %v want %v", itemID, status, http.StatusBadRequest)
+		}
+	}
+	play(team2.ID, "knight")
+
+	for _, p := range append(append([]Player{}, team1.Players...), team2.Players...) {
+		doRequest(t, "POST", fmt.Sprintf("/games/%s/lobby/players/%s", g.ID, p.ID), nil, false)
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s/lobby/players/%s/ready", g.ID, p.ID), nil, false)
+	}
+	if g := world.Game(g.ID); g.Status != GameStatusLobby {
+		t.Errorf("game should not start before the draft is done: got %+v", g)
+	}
+
+	expireDraftTurns(time.Now().Add(time.Minute))
+	started := world.Game(g.ID)
+	if started.Status != GameStatusRunning || len(started.Picks) != 2 || started.Picks[1] != (DraftChoice{TeamID: team1.ID, ItemID: "mage"}) ||
+		len(started.Bans) != 1 {
+		t.Errorf("game should start once the last pick timed out: got %+v", started)
+	}
+
+	doRequest(t, "PUT", "/games/"+g.ID, url.Values{"teamId": {team1.ID}}, false)
+	rr = doRequest(t, "GET", "/draft/items/stats?mode=draft", nil, false)
+	var stats []ItemStats
+	json.Unmarshal(rr.Body.Bytes(), &stats)
+	if len(stats) != 4 || stats[0].BanRate != 1 || stats[1].NbPicks != 1 || stats[1].WinRate != 0 ||
+		stats[2].NbWins != 1 || stats[2].Stats.TotalNbGamesPlayed != 3 {
+		t.Errorf("handler returned unexpected item stats: got %+v", stats)
+	}
+}
+
+// TestDraftLobbyTimeout tests that the remaining turns of a draft are played
+// before its game starts when the lobby times out, and that the draft is
+// dropped once the game left the lobby
+func TestDraftLobbyTimeout(t *testing.T) {
+	defer func(catalog []DraftItem) { draftCatalog = catalog }(draftCatalog)
+	for _, id := range []string{"rogue", "priest"} {
+		doRequest(t, "POST", "/admin/draft/items", url.Values{"id": {id}, "name": {id}, "kind": {"hero"}}, true)
+	}
+
+	team1 := newTestTeam(t, "Timeout Draft Team 1", 3)
+	team2 := newTestTeam(t, "Timeout Draft Team 2", 3)
+	rr := doRequest(t, "POST", "/games", url.Values{"name": {"Timeout Draft Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}, "lobby": {"true"}, "timeout": {"10"}, "onTimeout": {StartOnTimeout}}, false)
+	var g Game
+	json.Unmarshal(rr.Body.Bytes(), &g)
+	doRequest(t, "POST", fmt.Sprintf("/games/%s/draft", g.ID), url.Values{"sequence": {"pick:1,pick:2"}, "kind": {"hero"}, "turnTime": {"60"}}, false)
+
+	expireLobbies(time.Now().Add(time.Minute))
+	started := world.Game(g.ID)
+	if started.Status != GameStatusRunning || len(started.Picks) != 2 {
+		t.Errorf("game should start with its draft done: got %+v", started)
+	}
+	if _, ok := drafts[g.ID]; ok {
+		t.Errorf("draft not dropped once the game left the lobby")
+	}
+	rr = doRequest(t, "GET", fmt.Sprintf("/games/%s/draft", g.ID), nil, false)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -28,12 +31,11 @@ func teamCreationHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Generate a UUID to avoid ids collision
-	t := Team{ID: uuid.New().String(), Name: name}
-	teams = append(teams, t)
+	e := recordEvent(Event{Type: TeamCreated, TeamID: uuid.New().String(), Name: name})
 
 	// Return the created team to user
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(t)
+	json.NewEncoder(w).Encode(world.Team(e.TeamID))
 }
 
 // teamDeletionHandler takes a team id and removes the matching team.
@@ -44,12 +46,10 @@ func teamDeletionHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Look for a team with the id retrieved from user and delete it
 	// if found
-	for i, t := range teams {
-		if t.ID == vars["id"] {
-			teams = append(teams[:i], teams[i+1:]...)
-			w.Write([]byte("Team successfully deleted"))
-			return
-		}
+	if world.Team(vars["id"]) != nil {
+		recordEvent(Event{Type: TeamDeleted, TeamID: vars["id"]})
+		w.Write([]byte("Team successfully deleted"))
+		return
 	}
 
 	w.WriteHeader(http.StatusNotFound)
@@ -59,11 +59,13 @@ func teamDeletionHandler(w http.ResponseWriter, r *http.Request) {
 // teamsListingHandler returns a json encoded list of all the teams
 func teamsListingHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(teams)
+	json.NewEncoder(w).Encode(world.Teams)
 }
 
 // playerCreationHandler creates a player and affects him to a team based
-// on the team id received
+// on the team id received.
+// The role of the player in the team (e.g. tank, healer) can be provided
+// with the role parameter.
 func playerCreationHandler(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
@@ -81,15 +83,11 @@ func playerCreationHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
 	// Find the correct team, create the new player, and affect him to the team
-	for i, t := range teams {
-		if t.ID == vars["id"] {
-			p := Player{ID: uuid.New().String(), Pseudo: pseudo}
-			t.AddPlayer(p)
-			teams[i] = t
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(p)
-			return
-		}
+	if world.Team(vars["id"]) != nil {
+		e := recordEvent(Event{Type: PlayerAdded, TeamID: vars["id"], PlayerID: uuid.New().String(), Name: pseudo, Role: r.Form.Get("role")})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Player{ID: e.PlayerID, Pseudo: pseudo, Role: e.Role, Level: 1})
+		return
 	}
 
 	w.WriteHeader(http.StatusNotFound)
@@ -97,17 +95,44 @@ func playerCreationHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// playerRoleHandler changes the role of a player (playerId route variable)
+// to the role parameter, in every team he is part of. An empty role removes
+// the role of the player.
+func playerRoleHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Role could not be changed because of malformed PUT parameters"))
+		return
+	}
+	if _, ok := r.Form["role"]; !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Role could not be changed because of missing PUT parameter"))
+		return
+	}
+
+	p := world.Player(mux.Vars(r)["playerId"])
+	if p == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Player not found"))
+		return
+	}
+
+	recordEvent(Event{Type: RoleChanged, PlayerID: p.ID, Role: r.Form.Get("role")})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(world.Player(p.ID))
+}
+
 // playerDeletionHandler deletes a player based on the player id received
 func playerDeletionHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
 	// Look for the right team
-	for i, t := range teams {
+	if t := world.Team(vars["teamId"]); t != nil {
 		// Look for the right player and remove him if found
-		if t.ID == vars["teamId"] {
-			ok, _ := t.RemovePlayer(vars["playerId"])
-			if ok {
-				teams[i] = t
+		for _, p := range t.Players {
+			if p.ID == vars["playerId"] {
+				recordEvent(Event{Type: PlayerRemoved, TeamID: t.ID, PlayerID: p.ID})
 				w.Write([]byte("Player successfully deleted"))
 				return
 			}
@@ -135,30 +160,38 @@ func gameCreationHandler(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Game could not be created because of empty POST parameter"))
 		return
 	}
+	// Games are rated separately for each mode
+	mode := r.Form.Get("mode")
+	if mode == "" {
+		mode = DefaultMode
+	}
+	// Games can be played on a map of the catalog
+	mapID := r.Form.Get("mapId")
+	if !validMap(mapID) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("No map could be found with this id"))
+		return
+	}
 
-	// Create the game and set a starting time
-	g := Game{ID: uuid.New().String(), Name: name, StartTime: time.Now()}
+	// Prepare the game in order to check it before creating it
+	var g Game
 
 	// Affect team 1 and team 2 to the game.
 	// If at least of the teams cannot be found, stop here and return an error.
-	for _, t := range teams {
-		if t.ID == team1Id {
-			g.Team1 = t
-		}
+	if t := world.Team(team1Id); t != nil {
+		g.Team1 = *t
 	}
 	if g.Team1.ID == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("No team 1 could be found with this id"))
 		return
 	}
-	for _, t := range teams {
-		if t.ID == team2Id {
-			g.Team2 = t
-		}
+	if t := world.Team(team2Id); t != nil {
+		g.Team2 = *t
 	}
 	if g.Team2.ID == "" {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("No team 1 could be found with this id"))
+		w.Write([]byte("No team 2 could be found with this id"))
 		return
 	}
 
@@ -176,15 +209,44 @@ func gameCreationHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	games = append(games, g)
+	// Games can start with a lobby phase, until all their players are ready
+	lobby := false
+	if v := r.Form.Get("lobby"); v != "" {
+		lobby, err = strconv.ParseBool(v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Game could not be created because of malformed lobby parameter"))
+			return
+		}
+	}
+	now := time.Now()
+	l, malformed := newLobby(r, now)
+	if lobby && malformed != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Game could not be created because of malformed " + malformed + " parameter"))
+		return
+	}
+
+	// Create the game, the starting time being the event time (or the time
+	// all the players are ready for a lobby)
+	eventType := GameCreated
+	if lobby {
+		eventType = LobbyOpened
+	}
+	e := recordEvent(Event{Type: eventType, GameID: uuid.New().String(), Name: name, Team1ID: team1Id, Team2ID: team2Id, Mode: mode, MapID: mapID})
+	if lobby {
+		l.GameID = e.GameID
+		lobbies[e.GameID] = l
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(g)
+	json.NewEncoder(w).Encode(world.Game(e.GameID))
 
 }
 
 // gameStopHandler stops a game by setting a stop time.
-// It also declares which team won.
+// It also declares which team won, or that the game is a draw if the draw
+// parameter is true.
 func gameStopHandler(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
@@ -193,7 +255,21 @@ func gameStopHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	teamID := r.Form.Get("teamId")
-	if teamID == "" {
+	draw := false
+	if v := r.Form.Get("draw"); v != "" {
+		draw, err = strconv.ParseBool(v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Game could not be stoped because of malformed draw parameter"))
+			return
+		}
+	}
+	if draw && teamID != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Game could not be stoped because a draw has no winning team"))
+		return
+	}
+	if teamID == "" && !draw {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte("Game could not be created because of empty PUT parameter"))
 		return
@@ -201,32 +277,43 @@ func gameStopHandler(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
 
-	// Look for the right game
-	for i, g := range games {
-		// Stop the game if found
-		if g.ID == vars["id"] {
-			// If winning team id provided matches no team, stop here
-			if teamID != g.Team1.ID && teamID != g.Team2.ID {
-				w.WriteHeader(http.StatusNotFound)
-				w.Write([]byte("Winning team not found"))
-				return
-			}
-
-			// Increment the TotalNbWins of the players of the winning team
-			if teamID == g.Team1.ID {
-				g.Team1.MarkAsWinner()
-			} else {
-				g.Team2.MarkAsWinner()
-			}
+	// Look for the right game and stop it if found
+	if g := world.Game(vars["id"]); g != nil {
+		// A game can only be stopped once
+		if !g.StopTime.IsZero() {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Game is already stopped"))
+			return
+		}
+		if g.Status != GameStatusRunning {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Game is not running"))
+			return
+		}
 
-			// Stop the game
-			g.Stop()
-			games[i] = g
+		// Tournament matches need a winner to advance
+		if t, _ := tournamentMatch(g.ID); draw && t != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Tournament games cannot end in a draw"))
+			return
+		}
 
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(g)
+		// If winning team id provided matches no team, stop here
+		if !draw && teamID != g.Team1.ID && teamID != g.Team2.ID {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Winning team not found"))
 			return
 		}
+
+		// Stop the game and mark the winning team (none for a draw)
+		recordEvent(Event{Type: GameStopped, GameID: g.ID, TeamID: teamID})
+
+		// Advance the winner if the game is a tournament match
+		advanceTournaments(world.Game(g.ID))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(world.Game(vars["id"]))
+		return
 	}
 
 	w.WriteHeader(http.StatusNotFound)
@@ -235,8 +322,36 @@ func gameStopHandler(w http.ResponseWriter, r *http.Request) {
 
 // gamesListingHandler returns a json encoded list of all the games
 func gamesListingHandler(w http.ResponseWriter, r *http.Request) {
+	wd, err := requestedWorld(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Games could not be listed because of malformed as_of parameter"))
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(games)
+	json.NewEncoder(w).Encode(wd.Games)
+}
+
+// gameRetrievalHandler returns a json encoded game
+func gameRetrievalHandler(w http.ResponseWriter, r *http.Request) {
+	wd, err := requestedWorld(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Game could not be retrieved because of malformed as_of parameter"))
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	if g := wd.Game(vars["id"]); g != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(g)
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte("Game not found"))
 }
 
 // incrementStat increments a player stat
@@ -267,49 +382,35 @@ func incrementStatHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 
 	// Look for the right game
-	for i, g := range games {
-		if g.ID == vars["gameId"] {
-			// If game is stopped, stats shouldn't be incremented
-			if !g.StopTime.IsZero() {
+	if g := world.Game(vars["gameId"]); g != nil {
+		// If game is stopped, stats shouldn't be incremented
+		if !g.StopTime.IsZero() {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Stat could not be incremented because this game is stopped"))
+			return
+		}
+		if g.Status != GameStatusRunning {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Stat could not be incremented because this game is not running"))
+			return
+		}
+
+		// Look for the right player in one of the 2 teams
+		if p := g.Player(vars["playerId"]); p != nil {
+			// If the PUT parameter matches an existing stat, increment it.
+			// Otherwise return an error.
+			stats := p.Stats
+			ok := incrementStat(&stats, name)
+			if !ok {
 				w.WriteHeader(http.StatusBadRequest)
-				w.Write([]byte("Stat could not be incremented because this game is stopped"))
+				w.Write([]byte("Stat could not be incremented because of malformed PUT parameter"))
 				return
 			}
+			recordEvent(Event{Type: StatIncremented, GameID: g.ID, PlayerID: p.ID, Stat: name})
 
-			// Concatenate the 2 teams into 1 for easier player search
-			players := g.Team1.Players
-			players = append(players, g.Team2.Players...)
-
-			// Look for the righ player in one of the 2 teams
-			for _, p := range players {
-				if p.ID == vars["playerId"] {
-					// If the PUT parameter matches an existing stat, increment it.
-					// Otherwise return an error.
-					ok := incrementStat(&p.Stats, name)
-					if !ok {
-						w.WriteHeader(http.StatusBadRequest)
-						w.Write([]byte("Stat could not be incremented because of malformed PUT parameter"))
-						return
-					}
-
-					// Update the player team and game with the new stats
-					for k, p1 := range g.Team1.Players {
-						if p.ID == p1.ID {
-							g.Team1.Players[k] = p
-						}
-					}
-					for k, p1 := range g.Team2.Players {
-						if p.ID == p1.ID {
-							g.Team2.Players[k] = p
-						}
-					}
-					games[i] = g
-
-					w.Header().Set("Content-Type", "application/json")
-					json.NewEncoder(w).Encode(p)
-					return
-				}
-			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(world.Game(g.ID).Player(p.ID))
+			return
 		}
 	}
 
@@ -319,18 +420,20 @@ func incrementStatHandler(w http.ResponseWriter, r *http.Request) {
 
 // statsListingHandler lists all the stats for a player in a game
 func statsListingHandler(w http.ResponseWriter, r *http.Request) {
+	wd, err := requestedWorld(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Stats could not be listed because of malformed as_of parameter"))
+		return
+	}
+
 	vars := mux.Vars(r)
 
-	for _, g := range games {
-		if g.ID == vars["gameId"] {
-			players := g.Team1.Players
-			players = append(players, g.Team2.Players...)
-			for _, p := range players {
-				if p.ID == vars["playerId"] {
-					w.Header().Set("Content-Type", "application/json")
-					json.NewEncoder(w).Encode(p.Stats)
-				}
-			}
+	if g := wd.Game(vars["gameId"]); g != nil {
+		if p := g.Player(vars["playerId"]); p != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(p.Stats)
+			return
 		}
 	}
 
@@ -341,37 +444,142 @@ func statsListingHandler(w http.ResponseWriter, r *http.Request) {
 // achievementsListingHandler lists all the achievements for a player in a game
 // once a game is done
 func achievementsListingHandler(w http.ResponseWriter, r *http.Request) {
+	wd, err := requestedWorld(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Achievements could not be listed because of malformed as_of parameter"))
+		return
+	}
+
 	vars := mux.Vars(r)
 
-	for _, g := range games {
-		if g.ID == vars["gameId"] {
-			players := g.Team1.Players
-			players = append(players, g.Team2.Players...)
-			for _, p := range players {
-				if p.ID == vars["playerId"] {
-					w.Header().Set("Content-Type", "application/json")
-					json.NewEncoder(w).Encode(p.Achievements)
-				}
-			}
+	if g := wd.Game(vars["gameId"]); g != nil {
+		if p := g.Player(vars["playerId"]); p != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(p.Achievements)
+			return
 		}
 	}
+
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte("Game or player could not be found"))
 }
 
-func main() {
-	// Declare HTTP routes
+// worldMutex serializes the access to the world, the event log and the
+// other global state (admins, audit trail, tournaments, leagues), between HTTP handlers
+// and background jobs
+var worldMutex sync.Mutex
+
+// serialized runs handlers one at a time
+func serialized(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		worldMutex.Lock()
+		defer worldMutex.Unlock()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// newRouter declares all the HTTP routes of the backend
+func newRouter() *mux.Router {
 	r := mux.NewRouter()
+	r.Use(serialized)
 	r.HandleFunc("/teams", teamCreationHandler).Methods("POST")
+	r.HandleFunc("/teams/balance", teamBalancingHandler).Methods("POST")
 	r.HandleFunc("/teams/{id}", teamDeletionHandler).Methods("DELETE")
 	r.HandleFunc("/teams", teamsListingHandler).Methods("GET")
 	r.HandleFunc("/teams/{id}/players", playerCreationHandler).Methods("POST")
 	r.HandleFunc("/teams/{teamId}/players/{playerId}", playerDeletionHandler).Methods("DELETE")
+	r.HandleFunc("/teams/{id}/achievements", teamUnlocksHandler).Methods("GET")
+	r.HandleFunc("/teams/{id}/ratings", ratingsHandler).Methods("GET")
+	r.HandleFunc("/teams/{id}/ratings/history", ratingHistoryHandler).Methods("GET")
+	r.HandleFunc("/teams/{id}/headtohead/{opponentId}", headToHeadHandler).Methods("GET")
 	r.HandleFunc("/games", gameCreationHandler).Methods("POST")
 	r.HandleFunc("/games/{id}", gameStopHandler).Methods("PUT")
 	r.HandleFunc("/games", gamesListingHandler).Methods("GET")
+	r.HandleFunc("/games/{id}", gameRetrievalHandler).Methods("GET")
 	r.HandleFunc("/games/{gameId}/players/{playerId}/stats", incrementStatHandler).Methods("PUT")
 	r.HandleFunc("/games/{gameId}/players/{playerId}/stats", statsListingHandler).Methods("GET")
 	r.HandleFunc("/games/{gameId}/players/{playerId}/achievements", achievementsListingHandler).Methods("GET")
+	r.HandleFunc("/games/{id}/timeline", gameTimelineHandler).Methods("GET")
+	r.HandleFunc("/games/{gameId}/unlocks", gameUnlocksHandler).Methods("GET")
+	r.HandleFunc("/games/{gameId}/lobby", lobbyRetrievalHandler).Methods("GET")
+	r.HandleFunc("/games/{gameId}/lobby/players/{playerId}", lobbyJoinHandler).Methods("POST")
+	r.HandleFunc("/games/{gameId}/lobby/players/{playerId}/ready", lobbyReadyHandler).Methods("PUT")
+	r.HandleFunc("/games/{gameId}/draft", draftCreationHandler).Methods("POST")
+	r.HandleFunc("/games/{gameId}/draft", draftTurnHandler).Methods("PUT")
+	r.HandleFunc("/games/{gameId}/draft", draftRetrievalHandler).Methods("GET")
+	r.HandleFunc("/draft/items", draftCatalogHandler).Methods("GET")
+	r.HandleFunc("/draft/items/stats", itemStatsHandler).Methods("GET")
+	r.HandleFunc("/games/{gameId}/players/{playerId}/class", classSelectionHandler).Methods("PUT")
+	r.HandleFunc("/games/{id}/prediction", gamePredictionHandler).Methods("GET")
+	r.HandleFunc("/predictions", predictionHandler).Methods("GET")
+	r.HandleFunc("/predictions/calibration", calibrationHandler).Methods("GET")
+	r.HandleFunc("/achievements", catalogHandler).Methods("GET")
+	r.HandleFunc("/maps", mapsListingHandler).Methods("GET")
+	r.HandleFunc("/maps/stats", mapsStatsHandler).Methods("GET")
+	r.HandleFunc("/maps/{id}/stats", mapStatsHandler).Methods("GET")
+	r.HandleFunc("/matchmaking/queue", queueJoinHandler).Methods("POST")
+	r.HandleFunc("/matchmaking/queue/{ticketId}", queueStatusHandler).Methods("GET")
+	r.HandleFunc("/matchmaking/queue/{ticketId}", queueLeaveHandler).Methods("DELETE")
+	r.HandleFunc("/tournaments", tournamentsListingHandler).Methods("GET")
+	r.HandleFunc("/tournaments/{id}", tournamentRetrievalHandler).Methods("GET")
+	r.HandleFunc("/challenges", challengeCreationHandler).Methods("POST")
+	r.HandleFunc("/challenges/{id}", challengeResponseHandler).Methods("PUT")
+	r.HandleFunc("/challenges/{id}", challengeRetrievalHandler).Methods("GET")
+	r.HandleFunc("/teams/{id}/challenges", teamChallengesHandler).Methods("GET")
+	r.HandleFunc("/leagues", leaguesListingHandler).Methods("GET")
+	r.HandleFunc("/leagues/{id}", leagueRetrievalHandler).Methods("GET")
+	r.HandleFunc("/leagues/{id}/standings", leagueStandingsHandler).Methods("GET")
+	r.HandleFunc("/leagues/{id}/fixtures/{fixtureId}/game", fixtureGameHandler).Methods("POST")
+	r.HandleFunc("/seasons", seasonsListingHandler).Methods("GET")
+	r.HandleFunc("/seasons/{id}", seasonRetrievalHandler).Methods("GET")
+	r.HandleFunc("/leaderboards/{metric}", leaderboardHandler).Methods("GET")
+	r.HandleFunc("/leaderboards/{metric}/around/{id}", leaderboardAroundHandler).Methods("GET")
+	r.HandleFunc("/players/{playerId}/achievements", playerUnlocksHandler).Methods("GET")
+	r.HandleFunc("/players/{playerId}/achievements/progress", progressListingHandler).Methods("GET")
+	r.HandleFunc("/players/{playerId}", playerRetrievalHandler).Methods("GET")
+	r.HandleFunc("/players/{playerId}/role", playerRoleHandler).Methods("PUT")
+	r.HandleFunc("/players/{playerId}/streaks", streaksHandler).Methods("GET")
+	r.HandleFunc("/players/{playerId}/levelups", levelUpsHandler).Methods("GET")
+	r.HandleFunc("/players/{playerId}/classes", playerClassesHandler).Methods("GET")
+	r.HandleFunc("/players/{playerId}/ratings", ratingsHandler).Methods("GET")
+	r.HandleFunc("/players/{playerId}/ratings/history", ratingHistoryHandler).Methods("GET")
+	r.HandleFunc("/players/{playerId}/headtohead/{opponentId}", headToHeadHandler).Methods("GET")
+
+	// Admin routes
+	r.HandleFunc("/admin/games/{gameId}/players/{playerId}/stats", adminOnly(statCorrectionHandler)).Methods("PUT")
+	r.HandleFunc("/admin/audit", adminOnly(auditListingHandler)).Methods("GET")
+	r.HandleFunc("/admin/audit/{id}/revert", adminOnly(correctionRevertHandler)).Methods("POST")
+	r.HandleFunc("/admin/rebuild", adminOnly(rebuildHandler)).Methods("POST")
+	r.HandleFunc("/admin/achievements/rules", adminOnly(rulesListingHandler)).Methods("GET")
+	r.HandleFunc("/admin/achievements/rules", adminOnly(ruleCreationHandler)).Methods("POST")
+	r.HandleFunc("/admin/achievements/rules/{id}", adminOnly(ruleUpdateHandler)).Methods("PUT")
+	r.HandleFunc("/admin/achievements/rules/{id}", adminOnly(ruleDeletionHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/seasons", adminOnly(seasonCreationHandler)).Methods("POST")
+	r.HandleFunc("/admin/seasons/{id}", adminOnly(seasonUpdateHandler)).Methods("PUT")
+	r.HandleFunc("/admin/tournaments", adminOnly(tournamentCreationHandler)).Methods("POST")
+	r.HandleFunc("/admin/tournaments/{id}/teams", adminOnly(tournamentRegistrationHandler)).Methods("POST")
+	r.HandleFunc("/admin/tournaments/{id}/start", adminOnly(tournamentStartHandler)).Methods("POST")
+	r.HandleFunc("/admin/leagues", adminOnly(leagueCreationHandler)).Methods("POST")
+	r.HandleFunc("/admin/leagues/{id}/teams", adminOnly(leagueRegistrationHandler)).Methods("POST")
+	r.HandleFunc("/admin/leagues/{id}/seasons", adminOnly(leagueSeasonStartHandler)).Methods("POST")
+	r.HandleFunc("/admin/draft/items", adminOnly(draftItemCreationHandler)).Methods("POST")
+	r.HandleFunc("/admin/draft/items/{id}", adminOnly(draftItemDeletionHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/maps", adminOnly(mapCreationHandler)).Methods("POST")
+	r.HandleFunc("/admin/maps/{id}", adminOnly(mapDeletionHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/xp", adminOnly(xpConfigHandler)).Methods("GET")
+	r.HandleFunc("/admin/xp", adminOnly(xpConfigUpdateHandler)).Methods("PUT")
+
+	return r
+}
+
+func main() {
+	// Load admins allowed to perform admin operations
+	loadAdmins(os.Getenv("ADMIN_TOKENS"))
+
+	// Run the background jobs
+	go runScheduler(time.Second)
 
 	// Start HTTP server
-	log.Fatal(http.ListenAndServe(":8000", r))
+	log.Fatal(http.ListenAndServe(":8000", newRouter()))
 }
@@ -366,9 +366,9 @@ func TestAchievementsListingHandler(t *testing.T) {
 	json.Unmarshal([]byte(rr.Body.String()), &achievements)
 
 	// Bruiser achievement should be true
-	if !achievements.Bruiser {
+	if !achievements["bruiser"] {
 		t.Errorf("handler returned unexpected bruiser achievement in body: got %v want %v",
-			achievements.Bruiser, true)
+			achievements["bruiser"], true)
 	}
 }
 
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Domain event types.
+// Every mutation of teams and games is recorded as one of these events, as
+// well as achievement rule and XP config changes, the levels reached by players and the seasons.
+const (
+	TeamCreated     = "TeamCreated"
+	TeamDeleted     = "TeamDeleted"
+	PlayerAdded     = "PlayerAdded"
+	PlayerRemoved   = "PlayerRemoved"
+	RoleChanged     = "RoleChanged"
+	GameCreated     = "GameCreated"
+	GameScheduled   = "GameScheduled"
+	LobbyOpened     = "LobbyOpened"
+	PlayerJoined    = "PlayerJoined"
+	PlayerReady     = "PlayerReady"
+	GameStarted     = "GameStarted"
+	GameCancelled   = "GameCancelled"
+	ItemBanned      = "ItemBanned"
+	ItemPicked      = "ItemPicked"
+	ClassSelected   = "ClassSelected"
+	StatIncremented = "StatIncremented"
+	StatCorrected   = "StatCorrected"
+	GameStopped     = "GameStopped"
+	LevelReached    = "LevelReached"
+	RuleCreated     = "RuleCreated"
+	RuleUpdated     = "RuleUpdated"
+	RuleDeleted     = "RuleDeleted"
+	XPConfigChanged = "XPConfigChanged"
+	SeasonCreated   = "SeasonCreated"
+	SeasonUpdated   = "SeasonUpdated"
+	SeasonArchived  = "SeasonArchived"
+)
+
+// Event represents a domain event of the event log.
+// Only the fields relevant to the event type are filled in.
+type Event struct {
+	Seq       int              `json:"seq"`
+	Type      string           `json:"type"`
+	Time      time.Time        `json:"time"`
+	TeamID    string           `json:"teamId,omitempty"`
+	PlayerID  string           `json:"playerId,omitempty"`
+	GameID    string           `json:"gameId,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Team1ID   string           `json:"team1Id,omitempty"`
+	Team2ID   string           `json:"team2Id,omitempty"`
+	Mode      string           `json:"mode,omitempty"`
+	Role      string           `json:"role,omitempty"`
+	Stat      string           `json:"stat,omitempty"`
+	Value     int              `json:"value,omitempty"`
+	ItemID    string           `json:"itemId,omitempty"`
+	Class     string           `json:"class,omitempty"`
+	MapID     string           `json:"mapId,omitempty"`
+	Rule      *AchievementRule `json:"rule,omitempty"`
+	RuleID    string           `json:"ruleId,omitempty"`
+	XPConfig  *XPConfig        `json:"xpConfig,omitempty"`
+	SeasonID  string           `json:"seasonId,omitempty"`
+	Season    *Season          `json:"season,omitempty"`
+	Ephemeral bool             `json:"ephemeral,omitempty"`
+}
+
+// Apply applies an event to the world.
+// Events are expected to be valid: checks are made by handlers before
+// recording them.
+func (w *World) Apply(e Event) {
+	w.levelUps = nil
+	switch e.Type {
+	case TeamCreated:
+		w.Teams = append(w.Teams, Team{ID: e.TeamID, Name: e.Name, Ephemeral: e.Ephemeral})
+	case TeamDeleted:
+		w.removeTeam(e.TeamID)
+	case PlayerAdded:
+		if t := w.Team(e.TeamID); t != nil {
+			p := Player{ID: e.PlayerID, Pseudo: e.Name, Role: e.Role, Level: 1}
+			// A player who already played can join another team
+			if pr, ok := w.Progression[e.PlayerID]; ok {
+				p.XP, p.Level = pr.XP, pr.Level
+			}
+			t.AddPlayer(p)
+		}
+	case PlayerRemoved:
+		if t := w.Team(e.TeamID); t != nil {
+			t.RemovePlayer(e.PlayerID)
+		}
+	case RoleChanged:
+		for i := range w.Teams {
+			for j := range w.Teams[i].Players {
+				if w.Teams[i].Players[j].ID == e.PlayerID {
+					w.Teams[i].Players[j].Role = e.Role
+				}
+			}
+		}
+	case GameCreated, GameScheduled, LobbyOpened:
+		g := Game{ID: e.GameID, Name: e.Name, Mode: e.Mode, MapID: e.MapID, Status: GameStatusRunning, StartTime: e.Time}
+		switch e.Type {
+		case GameScheduled:
+			g.Status, g.StartTime = GameStatusScheduled, time.Time{}
+		case LobbyOpened:
+			g.Status, g.StartTime = GameStatusLobby, time.Time{}
+		}
+		if g.Mode == "" {
+			g.Mode = DefaultMode
+		}
+		if t := w.Team(e.Team1ID); t != nil {
+			g.Team1 = t.Copy()
+		}
+		if t := w.Team(e.Team2ID); t != nil {
+			g.Team2 = t.Copy()
+		}
+		w.Games = append(w.Games, g)
+		// Predictions are made before the game starts, to be compared with
+		// its outcome
+		if w.Predictions == nil {
+			w.Predictions = map[string]Prediction{}
+		}
+		w.Predictions[g.ID] = w.Predict(g.Team1, g.Team2, g.Mode, e.Time)
+	case PlayerJoined:
+		if g := w.Game(e.GameID); g != nil {
+			g.JoinedPlayerIDs = append(g.JoinedPlayerIDs, e.PlayerID)
+		}
+	case PlayerReady:
+		if g := w.Game(e.GameID); g != nil {
+			g.ReadyPlayerIDs = append(g.ReadyPlayerIDs, e.PlayerID)
+		}
+	case ItemBanned:
+		if g := w.Game(e.GameID); g != nil {
+			g.Bans = append(g.Bans, DraftChoice{TeamID: e.TeamID, ItemID: e.ItemID})
+		}
+	case ItemPicked:
+		if g := w.Game(e.GameID); g != nil {
+			g.Picks = append(g.Picks, DraftChoice{TeamID: e.TeamID, ItemID: e.ItemID})
+			if e.MapID != "" {
+				g.MapID = e.MapID
+			}
+		}
+	case ClassSelected:
+		if g := w.Game(e.GameID); g != nil {
+			if p := g.Player(e.PlayerID); p != nil {
+				p.Class = e.Class
+			}
+		}
+	case GameStarted:
+		if g := w.Game(e.GameID); g != nil {
+			// Scheduled games are played by the rosters of the teams when
+			// they start
+			if g.Status == GameStatusScheduled {
+				if t := w.Team(g.Team1.ID); t != nil {
+					g.Team1 = t.Copy()
+				}
+				if t := w.Team(g.Team2.ID); t != nil {
+					g.Team2 = t.Copy()
+				}
+			}
+			g.Status = GameStatusRunning
+			g.StartTime = e.Time
+		}
+	case GameCancelled:
+		if g := w.Game(e.GameID); g != nil {
+			g.Status = GameStatusCancelled
+		}
+	case StatIncremented:
+		if g := w.Game(e.GameID); g != nil {
+			if p := g.Player(e.PlayerID); p != nil {
+				p.Stats.IncrementStats(e.Stat)
+				w.UpdateLiveUnlocks(g, p, e)
+			}
+		}
+	case StatCorrected:
+		if g := w.Game(e.GameID); g != nil {
+			if p := g.Player(e.PlayerID); p != nil {
+				before := p.Stats
+				old, _ := before.Stat(e.Stat)
+				p.Stats.SetStat(e.Stat, e.Value)
+				// Achievements of a stopped game must reflect the corrected stats
+				if !g.StopTime.IsZero() {
+					w.correctStoppedGame(p, e.Stat, old)
+					w.updateStoppedPlayer(g, p, e, w.XPConfig.GameXP(before))
+					w.UpdateTeamAchievements(g, e)
+				}
+			}
+		}
+	case GameStopped:
+		if g := w.Game(e.GameID); g != nil {
+			g.Stop(e.TeamID, e.Time)
+			g.SeasonID = w.seasonAt(e.Time)
+			w.indexStoppedGame(g)
+			w.UpdateRatings(g, e)
+			for _, players := range [][]Player{g.Team1.Players, g.Team2.Players} {
+				for i := range players {
+					w.updateStoppedPlayer(g, &players[i], e, 0)
+				}
+			}
+			w.UpdateTeamAchievements(g, e)
+		}
+	case LevelReached:
+		w.LevelUps = append(w.LevelUps, LevelUp{
+			PlayerID: e.PlayerID,
+			Level:    e.Value,
+			At:       e.Time,
+			GameID:   e.GameID,
+			Seq:      e.Seq,
+		})
+	case RuleCreated, RuleUpdated:
+		w.Rules.SetRule(*e.Rule)
+	case RuleDeleted:
+		w.Rules.DeleteRule(e.RuleID)
+	case XPConfigChanged:
+		w.ChangeXPConfig(*e.XPConfig)
+	case SeasonCreated, SeasonUpdated:
+		w.setSeason(*e.Season)
+	case SeasonArchived:
+		w.archiveSeason(e.SeasonID)
+	}
+
+	switch e.Type {
+	case GameCreated, LobbyOpened, GameStarted, GameCancelled, GameStopped:
+		if g := w.Game(e.GameID); g != nil {
+			w.updateActiveGame(g)
+		}
+	}
+
+	switch e.Type {
+	case TeamCreated, TeamDeleted, PlayerAdded, PlayerRemoved, StatCorrected, GameStopped:
+		w.RankedVersion++
+	}
+}
+
+// updateStoppedPlayer calculates everything derived from the stopped games of
+// a player, once one of them is stopped or corrected: experience,
+// achievements and unlocks.
+// The achievements of this game and of the games the player stopped after it
+// are evaluated with the lifetime variables he had once each game was over.
+// The experience the game awarded before a correction is provided, so that
+// only the difference is added.
+func (w *World) updateStoppedPlayer(g *Game, p *Player, e Event, previousXP int) {
+	w.AddXP(p.ID, w.XPConfig.GameXP(p.Stats)-previousXP, g.ID)
+	games, vars := w.LifetimeVariablesFrom(p.ID, g)
+	for i, sg := range games {
+		sp := sg.Player(p.ID)
+		sp.Achievements = w.PlayerAchievements(sp.Stats, vars[i])
+	}
+	// Achievements unlocked (or not anymore) in this game award experience too
+	nbUnlocks := w.UpdateUnlocks(g, p.ID, games, e)
+	w.AddXP(p.ID, nbUnlocks*w.XPConfig.PerAchievement, g.ID)
+}
+
+// replay builds a new world by applying events in order
+func replay(events []Event) *World {
+	w := newWorld()
+	for _, e := range events {
+		w.Apply(e)
+	}
+	return w
+}
+
+// worldAt builds the world as it was at the time provided, by applying
+// the events recorded until then.
+// Events are filtered by time rather than cut at the first later one, so
+// that an event stamped out of order does not hide the events after it.
+func worldAt(t time.Time) *World {
+	var events []Event
+	for _, e := range eventLog {
+		if !e.Time.After(t) {
+			events = append(events, e)
+		}
+	}
+	return replay(events)
+}
+
+// requestedWorld returns the world a read request should be served from:
+// the current world, or the world reconstructed at the time provided in
+// the as_of query parameter (RFC 3339 format)
+func requestedWorld(r *http.Request) (*World, error) {
+	asOf := r.URL.Query().Get("as_of")
+	if asOf == "" {
+		return world, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, asOf)
+	if err != nil {
+		return nil, err
+	}
+	return worldAt(t), nil
+}
+
+// requestedTime returns the time a read request is served at: the time
+// provided in the as_of query parameter, or now
+func requestedTime(r *http.Request) (time.Time, error) {
+	asOf := r.URL.Query().Get("as_of")
+	if asOf == "" {
+		return time.Now(), nil
+	}
+	return time.Parse(time.RFC3339Nano, asOf)
+}
+
+// Init the event log
+var eventLog []Event
+
+// recordEvent appends an event to the event log and applies it to the world.
+// It returns the recorded event, with its sequence number and time.
+func recordEvent(e Event) Event {
+	e.Seq = len(eventLog) + 1
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	eventLog = append(eventLog, e)
+	world.Apply(e)
+
+	// Levels reached are derived from the event, but recorded too so that
+	// they never change afterwards
+	levelUps := world.levelUps
+	for _, l := range levelUps {
+		recordEvent(Event{Type: LevelReached, PlayerID: l.PlayerID, GameID: l.GameID, Value: l.Level, Time: e.Time})
+	}
+	return e
+}
+
+// gameTimelineHandler returns the ordered list of events of a game
+func gameTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	if world.Game(vars["id"]) == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Game not found"))
+		return
+	}
+
+	timeline := []Event{}
+	for _, e := range eventLog {
+		if e.GameID == vars["id"] {
+			timeline = append(timeline, e)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timeline)
+}
+
+// rebuildHandler throws away the current world and builds it again from
+// the event log, so all the projections (teams, games, stats, achievements...)
+// are calculated again
+func rebuildHandler(w http.ResponseWriter, r *http.Request) {
+	world = replay(eventLog)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		"events": len(eventLog),
+		"teams":  len(world.Teams),
+		"games":  len(world.Games),
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestGameTimelineHandler tests that the creation, stat increments and stop
+// of a game are returned in order
+func TestGameTimelineHandler(t *testing.T) {
+	g := newTestGame(t, "Timeline Game")
+	player := g.Team1.Players[0]
+	for i := 0; i < 2; i++ {
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, player.ID), url.Values{"name": {"nbKills"}}, false)
+	}
+	doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {g.Team2.ID}}, false)
+
+	rr := doRequest(t, "GET", fmt.Sprintf("/games/%s/timeline", g.ID), nil, false)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var timeline []Event
+	json.Unmarshal(rr.Body.Bytes(), &timeline)
+	want := []string{GameCreated, StatIncremented, StatIncremented, GameStopped}
+	if len(timeline) != len(want) {
+		t.Fatalf("handler returned unexpected number of events: got %v want %v",
+			len(timeline), len(want))
+	}
+	for i, e := range timeline {
+		if e.Type != want[i] {
+			t.Errorf("handler returned unexpected event type at position %d: got %v want %v",
+				i, e.Type, want[i])
+		}
+		if i > 0 && e.Seq <= timeline[i-1].Seq {
+			t.Errorf("handler returned events out of order: %v after %v", e.Seq, timeline[i-1].Seq)
+		}
+	}
+}
+
+// TestRebuildHandler tests that rebuilding the world from the event log gives
+// back the same teams and game
+func TestRebuildHandler(t *testing.T) {
+	g := newTestGame(t, "Rebuilt Game")
+	doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, g.Team2.Players[2].ID), url.Values{"name": {"damageDone"}}, false)
+	doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {g.Team1.ID}}, false)
+	before := *world
+
+	rr := doRequest(t, "POST", "/admin/rebuild", nil, true)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	// Achievement rule changes are replayed too, so every team and game is
+	// rebuilt as it was
+	if !reflect.DeepEqual(before.Teams, world.Teams) || !reflect.DeepEqual(before.Games, world.Games) {
+		t.Errorf("rebuilt world differs from the original one")
+	}
+	if p := world.Game(g.ID).Team1.Players[0]; p.Stats.TotalNbWins != 1 {
+		t.Errorf("rebuilt world has unexpected wins: got %v want %v", p.Stats.TotalNbWins, 1)
+	}
+}
+
+// TestAsOfQueries tests that the game, stats and achievements endpoints return
+// the state reconstructed at the as_of time
+func TestAsOfQueries(t *testing.T) {
+	beforeCreation := time.Now().UTC()
+	g := newTestGame(t, "As Of Game")
+	player := g.Team1.Players[0]
+	statsEndpoint := fmt.Sprintf("/games/%s/players/%s/stats", g.ID, player.ID)
+	for i := 0; i < 499; i++ {
+		doRequest(t, "PUT", statsEndpoint, url.Values{"name": {"damageDone"}}, false)
+	}
+	beforeLastHit := time.Now().UTC()
+	doRequest(t, "PUT", statsEndpoint, url.Values{"name": {"damageDone"}}, false)
+	beforeStop := time.Now().UTC()
+	doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {g.Team1.ID}}, false)
+
+	asOf := func(t time.Time) string {
+		return "?as_of=" + url.QueryEscape(t.Format(time.RFC3339Nano))
+	}
+
+	// The game did not exist yet
+	rr := doRequest(t, "GET", fmt.Sprintf("/games/%s", g.ID)+asOf(beforeCreation), nil, false)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+
+	// The game was running
+	rr = doRequest(t, "GET", fmt.Sprintf("/games/%s", g.ID)+asOf(beforeStop), nil, false)
+	var game Game
+	json.Unmarshal(rr.Body.Bytes(), &game)
+	if !game.StopTime.IsZero() {
+		t.Errorf("handler returned unexpected stop time in body: got %v want %v",
+			game.StopTime, time.Time{})
+	}
+
+	// The last hit was not recorded yet
+	rr = doRequest(t, "GET", statsEndpoint+asOf(beforeLastHit), nil, false)
+	var stats Stats
+	json.Unmarshal(rr.Body.Bytes(), &stats)
+	if stats.DamageDone != 499 {
+		t.Errorf("handler returned unexpected damage done in body: got %v want %v",
+			stats.DamageDone, 499)
+	}
+
+	// The bruiser achievement was not reached yet
+	rr = doRequest(t, "GET", fmt.Sprintf("/games/%s/players/%s/achievements", g.ID, player.ID)+asOf(beforeLastHit), nil, false)
+	var achievements Achievements
+	json.Unmarshal(rr.Body.Bytes(), &achievements)
+	if achievements["bruiser"] {
+		t.Errorf("handler returned unexpected bruiser achievement in body: got %v want %v",
+			achievements["bruiser"], false)
+	}
+
+	// Malformed dates are rejected
+	rr = doRequest(t, "GET", statsEndpoint+"?as_of=yesterday", nil, false)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+// TestAsOfRules tests that as_of queries and rebuilds evaluate the games
+// with the achievement rules in force when they stopped
+func TestAsOfRules(t *testing.T) {
+	g := newTestGame(t, "Rule Change Game")
+	player := g.Team1.Players[0]
+	doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, player.ID), url.Values{"name": {"nbKills"}}, false)
+	doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {g.Team1.ID}}, false)
+	beforeRule := url.QueryEscape(time.Now().UTC().Format(time.RFC3339Nano))
+
+	defer resetRules()
+	params := url.Values{"id": {"firstBlood"}, "name": {"First Blood"}, "condition": {"nbKills >= 1"}, "scope": {ScopeGame}}
+	if rr := doRequest(t, "POST", "/admin/achievements/rules", params, true); rr.Code != http.StatusOK {
+		t.Fatalf("rule creation failed: %v %s", rr.Code, rr.Body.String())
+	}
+
+	// The rule did not exist yet
+	rr := doRequest(t, "GET", fmt.Sprintf("/players/%s/achievements/progress?as_of=%s", player.ID, beforeRule), nil, false)
+	var progress []AchievementProgress
+	json.Unmarshal(rr.Body.Bytes(), &progress)
+	for _, ap := range progress {
+		if ap.ID == "firstBlood" {
+			t.Errorf("handler returned a rule created after the as_of time")
+		}
+	}
+
+	// The game stopped before the rule was created, even once rebuilt
+	doRequest(t, "POST", "/admin/rebuild", nil, true)
+	if world.Rules.Rule("firstBlood") == nil {
+		t.Fatalf("rebuilt world lost the rule created")
+	}
+	if world.Game(g.ID).Player(player.ID).Achievements["firstBlood"] {
+		t.Errorf("rebuilt game was evaluated with a rule created after it stopped")
+	}
+}
+
+// TestAsOfOutOfOrderEvents tests that an event stamped later than the events
+// recorded after it does not hide them from as_of queries
+func TestAsOfOutOfOrderEvents(t *testing.T) {
+	recordEvent(Event{Type: SeasonArchived, SeasonID: "unknown", Time: time.Now().Add(time.Hour)})
+	g := newTestGame(t, "Out Of Order Game")
+
+	asOf := url.QueryEscape(time.Now().UTC().Format(time.RFC3339Nano))
+	rr := doRequest(t, "GET", fmt.Sprintf("/games/%s?as_of=%s", g.ID, asOf), nil, false)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a compiled expression over named variables, used by achievement
+// conditions.
+// Booleans are represented as numbers: 0 is false, anything else is true.
+type Expr interface {
+	Eval(vars map[string]float64) float64
+}
+
+type numberExpr float64
+
+type variableExpr string
+
+type unaryExpr struct {
+	op string
+	x  Expr
+}
+
+type binaryExpr struct {
+	op   string
+	x, y Expr
+}
+
+// Eval returns the number itself
+func (e numberExpr) Eval(vars map[string]float64) float64 {
+	return float64(e)
+}
+
+// Eval returns the value of the variable, or 0 if it is not defined
+func (e variableExpr) Eval(vars map[string]float64) float64 {
+	return vars[string(e)]
+}
+
+// Eval applies the unary operator to its operand
+func (e unaryExpr) Eval(vars map[string]float64) float64 {
+	x := e.x.Eval(vars)
+	if e.op == "!" {
+		return boolToFloat(x == 0)
+	}
+	return -x
+}
+
+// Eval applies the binary operator to its operands.
+// Dividing by 0 gives 0 so ratios of empty stats are simply 0.
+func (e binaryExpr) Eval(vars map[string]float64) float64 {
+	x := e.x.Eval(vars)
+	// Short-circuit logical operators
+	switch e.op {
+	case "&&":
+		return boolToFloat(x != 0 && e.y.Eval(vars) != 0)
+	case "||":
+		return boolToFloat(x != 0 || e.y.Eval(vars) != 0)
+	}
+	y := e.y.Eval(vars)
+	switch e.op {
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	case "/":
+		if y == 0 {
+			return 0
+		}
+		return x / y
+	case "==":
+		return boolToFloat(x == y)
+	case "!=":
+		return boolToFloat(x != y)
+	case "<":
+		return boolToFloat(x < y)
+	case "<=":
+		return boolToFloat(x <= y)
+	case ">":
+		return boolToFloat(x > y)
+	case ">=":
+		return boolToFloat(x >= y)
+	}
+	return 0
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// exprVariables returns the names of the variables used in an expression
+func exprVariables(e Expr) []string {
+	switch e := e.(type) {
+	case variableExpr:
+		return []string{string(e)}
+	case unaryExpr:
+		return exprVariables(e.x)
+	case binaryExpr:
+		return append(exprVariables(e.x), exprVariables(e.y)...)
+	}
+	return nil
+}
+
+// exprOperators lists the operators of the expression language, longest first
+// so that "<=" is not read as "<" followed by "="
+var exprOperators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "!", "(", ")"}
+
+// tokenize splits an expression into numbers, identifiers and operators
+func tokenize(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			found := false
+			for _, op := range exprOperators {
+				if strings.HasPrefix(s[i:], op) {
+					tokens = append(tokens, op)
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// parser is a recursive descent parser of expressions.
+// From lowest to highest precedence: ||, &&, comparisons, + and -, * and /,
+// unary ! and -.
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+// ParseExpr compiles an expression such as "nbHits > 0 && nbHits / nbAttemptedAttacks >= 0.75"
+func ParseExpr(s string) (Expr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return e, nil
+}
+
+// peek returns the current token, or an empty string at the end of the expression
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// parseBinary parses a left associative sequence of operands separated by
+// one of the operators provided
+func (p *parser) parseBinary(next func() (Expr, error), ops ...string) (Expr, error) {
+	x, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		matched := false
+		for _, o := range ops {
+			if op == o {
+				matched = true
+			}
+		}
+		if !matched {
+			return x, nil
+		}
+		p.pos++
+		y, err := next()
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpr{op: op, x: x, y: y}
+	}
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	return p.parseBinary(p.parseComparison, "&&")
+}
+
+func (p *parser) parseComparison() (Expr, error) {
+	return p.parseBinary(p.parseAdditive, "==", "!=", "<", "<=", ">", ">=")
+}
+
+func (p *parser) parseAdditive() (Expr, error) {
+	return p.parseBinary(p.parseMultiplicative, "+", "-")
+}
+
+func (p *parser) parseMultiplicative() (Expr, error) {
+	return p.parseBinary(p.parseUnary, "*", "/")
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if op := p.peek(); op == "!" || op == "-" {
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr{op: op, x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.peek()
+	if tok == "" {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+
+	switch c := rune(tok[0]); {
+	case tok == "(":
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return e, nil
+	case unicode.IsDigit(c) || c == '.':
+		n, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed number %q", tok)
+		}
+		return numberExpr(n), nil
+	case unicode.IsLetter(c) || c == '_':
+		return variableExpr(tok), nil
+	}
+	return nil, fmt.Errorf("unexpected %q", tok)
+}
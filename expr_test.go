@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestParseExpr tests the evaluation of expressions and the rejection of
+// malformed ones
+func TestParseExpr(t *testing.T) {
+	vars := map[string]float64{"nbHits": 3, "nbAttemptedAttacks": 4, "nbKills": 0}
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"-nbHits + 1", -2},
+		{"nbHits / nbAttemptedAttacks", 0.75},
+		{"nbHits / nbKills", 0},
+		{"nbHits > 0 && nbHits / nbAttemptedAttacks >= 0.75", 1},
+		{"nbKills >= 1 || nbHits == 2", 0},
+		{"!(nbKills >= 1)", 1},
+		{"unknown + 1", 1},
+	}
+	for _, tt := range tests {
+		e, err := ParseExpr(tt.expr)
+		if err != nil {
+			t.Errorf("ParseExpr(%q) returned unexpected error: %v", tt.expr, err)
+			continue
+		}
+		if got := e.Eval(vars); got != tt.want {
+			t.Errorf("ParseExpr(%q) evaluated to %v want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"", "1 +", "(1 + 2", "1 2", "nbHits % 2", "1..2"} {
+		if _, err := ParseExpr(expr); err == nil {
+			t.Errorf("ParseExpr(%q) should have returned an error", expr)
+		}
+	}
+}
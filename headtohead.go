@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultHeadToHeadLimit is the number of recent games of a head-to-head
+// record when no limit is provided
+const defaultHeadToHeadLimit = 5
+
+// HeadToHeadSide is the record of one of the two teams (or players) of a
+// head-to-head, with the stats accumulated over their games against each
+// other
+type HeadToHeadSide struct {
+	ID       string  `json:"id"`
+	NbWins   int     `json:"nbWins"`
+	NbLosses int     `json:"nbLosses"`
+	WinRate  float64 `json:"winRate"`
+	Score    int     `json:"score"`
+	Stats    Stats   `json:"stats"`
+}
+
+// HeadToHeadGame is a game of a head-to-head record.
+// Scores are the ones of the teams each side played for, and the winner is
+// the id of the side which won (empty for a draw).
+type HeadToHeadGame struct {
+	GameID   string    `json:"gameId"`
+	Name     string    `json:"name"`
+	Mode     string    `json:"mode"`
+	MapID    string    `json:"mapId,omitempty"`
+	StopTime time.Time `json:"stopTime"`
+	WinnerID string    `json:"winnerId,omitempty"`
+	Score1   int       `json:"score1"`
+	Score2   int       `json:"score2"`
+}
+
+// HeadToHeadRecord sums up the stopped games in which two teams, or two
+// players, played against each other, the most recent games first
+type HeadToHeadRecord struct {
+	NbGames     int              `json:"nbGames"`
+	Draws       int              `json:"draws"`
+	Side1       HeadToHeadSide   `json:"side1"`
+	Side2       HeadToHeadSide   `json:"side2"`
+	RecentGames []HeadToHeadGame `json:"recentGames"`
+}
+
+// sideOf returns the team of a game a team (or a player if players is true)
+// played for, or nil if it did not play the game
+func sideOf(g *Game, id string, players bool) *Team {
+	for _, team := range []*Team{&g.Team1, &g.Team2} {
+		if !players && team.ID == id {
+			return team
+		}
+		if players {
+			for _, p := range team.Players {
+				if p.ID == id {
+					return team
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// HeadToHeadRecord calculates the head-to-head record of two teams (or two
+// players if players is true) over the stopped games of a mode and season
+// (all modes and seasons if empty), with at most limit recent games.
+// Players only face each other when they play for opposite teams.
+func (w *World) HeadToHeadRecord(id1, id2 string, players bool, mode, seasonID string, limit int) HeadToHeadRecord {
+	h := HeadToHeadRecord{Side1: HeadToHeadSide{ID: id1}, Side2: HeadToHeadSide{ID: id2}, RecentGames: []HeadToHeadGame{}}
+	stopped := w.TeamGames(id1)
+	if players {
+		stopped = w.PlayerGames(id1)
+	}
+	for i := len(stopped) - 1; i >= 0; i-- {
+		g := stopped[i]
+		if (mode != "" && g.Mode != mode) || (seasonID != "" && g.SeasonID != seasonID) {
+			continue
+		}
+		team1, team2 := sideOf(g, id1, players), sideOf(g, id2, players)
+		if team1 == nil || team2 == nil || team1 == team2 {
+			continue
+		}
+
+		h.NbGames++
+		hg := HeadToHeadGame{
+			GameID:   g.ID,
+			Name:     g.Name,
+			Mode:     g.Mode,
+			MapID:    g.MapID,
+			StopTime: g.StopTime,
+			Score1:   g.Score(team1.ID),
+			Score2:   g.Score(team2.ID),
+		}
+		switch g.WinnerID {
+		case team1.ID:
+			h.Side1.NbWins++
+			h.Side2.NbLosses++
+			hg.WinnerID = id1
+		case team2.ID:
+			h.Side2.NbWins++
+			h.Side1.NbLosses++
+			hg.WinnerID = id2
+		default:
+			h.Draws++
+		}
+		h.Side1.Score += hg.Score1
+		h.Side2.Score += hg.Score2
+
+		for _, side := range []struct {
+			s    *HeadToHeadSide
+			team *Team
+		}{{&h.Side1, team1}, {&h.Side2, team2}} {
+			if players {
+				side.s.Stats.Add(g.Player(side.s.ID).Stats)
+				continue
+			}
+			for _, p := range side.team.Players {
+				side.s.Stats.Add(p.Stats)
+			}
+		}
+
+		if len(h.RecentGames) < limit {
+			h.RecentGames = append(h.RecentGames, hg)
+		}
+	}
+
+	h.Side1.WinRate = ratio(float64(h.Side1.NbWins), float64(h.NbGames))
+	h.Side2.WinRate = ratio(float64(h.Side2.NbWins), float64(h.NbGames))
+	return h
+}
+
+// headToHeadHandler returns the head-to-head record of a player (playerId
+// route variable) or team (id route variable) against another one
+// (opponentId route variable), optionally restricted to the mode and season
+// query parameters. The number of recent games is set by the limit query
+// parameter.
+func headToHeadHandler(w http.ResponseWriter, r *http.Request) {
+	wd, err := requestedWorld(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Head-to-head could not be retrieved because of malformed as_of parameter"))
+		return
+	}
+
+	id, ok := ratedEntity(wd, w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	_, players := vars["playerId"]
+	opponentID := vars["opponentId"]
+	if (players && wd.Player(opponentID) == nil) || (!players && !wd.TeamIDs()[opponentID]) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Opponent not found"))
+		return
+	}
+	if opponentID == id {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Head-to-head could not be retrieved because the opponent is the same"))
+		return
+	}
+
+	limit, err := intQueryParam(r, "limit", defaultHeadToHeadLimit)
+	if err != nil || limit < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Head-to-head could not be retrieved because of malformed limit parameter"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wd.HeadToHeadRecord(id, opponentID, players, r.URL.Query().Get("mode"), r.URL.Query().Get("season"), limit))
+}
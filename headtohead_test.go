@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// TestHeadToHead tests the head-to-head records between two teams and
+// between two players: wins, losses, draws, recent games and stats
+func TestHeadToHead(t *testing.T) {
+	team1 := newTestTeam(t, "Head-to-head Team 1", 3)
+	team2 := newTestTeam(t, "Head-to-head Team 2", 3)
+	player1, player2 := team1.Players[0], team2.Players[0]
+	var games []Game
+	for i, winnerID := range []string{team1.ID, team2.ID, team1.ID, ""} {
+		team1ID, team2ID := team1.ID, team2.ID
+		if i%2 == 1 {
+			team1ID, team2ID = team2ID, team1ID
+		}
+		rr := doRequest(t, "POST", "/games", url.Values{"name": {"Head-to-head Game"}, "team1Id": {team1ID}, "team2Id": {team2ID}, "mode": {"headtohead"}}, false)
+		var g Game
+		json.Unmarshal(rr.Body.Bytes(), &g)
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, player1.ID), url.Values{"name": {"nbKills"}}, false)
+		if winnerID == "" {
+			doRequest(t, "PUT", "/games/"+g.ID, url.Values{"draw": {"true"}}, false)
+		} else {
+			doRequest(t, "PUT", "/games/"+g.ID, url.Values{"teamId": {winnerID}}, false)
+		}
+		games = append(games, g)
+	}
+
+	rr := doRequest(t, "GET", fmt.Sprintf("/teams/%s/headtohead/%s?mode=headtohead&limit=2", team1.ID, team2.ID), nil, false)
+	var h HeadToHeadRecord
+	json.Unmarshal(rr.Body.Bytes(), &h)
+	if h.NbGames != 4 || h.Draws != 1 || h.Side1.NbWins != 2 || h.Side1.NbLosses != 1 || h.Side2.NbWins != 1 ||
+		h.Side1.WinRate != 0.5 || h.Side1.Score != 4 || h.Side2.Score != 0 || h.Side1.Stats.NbKills != 4 {
+		t.Errorf("handler returned unexpected head-to-head: got %+v", h)
+	}
+	if len(h.RecentGames) != 2 || h.RecentGames[0].GameID != games[3].ID || h.RecentGames[0].WinnerID != "" ||
+		h.RecentGames[1].WinnerID != team1.ID || h.RecentGames[1].Score1 != 1 {
+		t.Errorf("handler returned unexpected recent games: got %+v", h.RecentGames)
+	}
+
+	rr = doRequest(t, "GET", fmt.Sprintf("/players/%s/headtohead/%s?mode=headtohead", player2.ID, player1.ID), nil, false)
+	h = HeadToHeadRecord{}
+	json.Unmarshal(rr.Body.Bytes(), &h)
+	if h.NbGames != 4 || h.Side1.NbWins != 1 || h.Side2.NbWins != 2 || h.Side2.Stats.NbKills != 4 || len(h.RecentGames) != 4 {
+		t.Errorf("handler returned unexpected player head-to-head: got %+v", h)
+	}
+
+	rr = doRequest(t, "GET", fmt.Sprintf("/players/%s/headtohead/%s", player1.ID, team1.Players[1].ID), nil, false)
+	h = HeadToHeadRecord{}
+	json.Unmarshal(rr.Body.Bytes(), &h)
+	if h.NbGames != 0 {
+		t.Errorf("teammates should not face each other: got %+v", h)
+	}
+
+	rr = doRequest(t, "GET", fmt.Sprintf("/teams/%s/headtohead/unknown", team1.ID), nil, false)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAdminToken is the token used by tests to perform admin operations
+const testAdminToken = "test-admin-token"
+
+func init() {
+	loadAdmins("tester:" + testAdminToken)
+}
+
+// doRequest sends a request with form urlencoded parameters through the full
+// router and records the response
+func doRequest(t *testing.T, method, target string, params url.Values, admin bool) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, target, strings.NewReader(params.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	if admin {
+		req.Header.Add("X-Admin-Token", testAdminToken)
+	}
+	rr := httptest.NewRecorder()
+	newRouter().ServeHTTP(rr, req)
+	return rr
+}
+
+// resetRules records the events restoring the default achievement rules, so
+// that rules added by a test are not brought back by a later rebuild
+func resetRules() {
+	var ids []string
+	for _, r := range world.Rules.Rules {
+		ids = append(ids, r.ID)
+	}
+	for _, id := range ids {
+		recordEvent(Event{Type: RuleDeleted, RuleID: id})
+	}
+	for _, r := range defaultAchievementRules() {
+		rule := r
+		recordEvent(Event{Type: RuleCreated, Rule: &rule})
+	}
+}
+
+// newTestTeam creates a team made up of nbPlayers players
+func newTestTeam(t *testing.T, name string, nbPlayers int) Team {
+	rr := doRequest(t, "POST", "/teams", url.Values{"name": {name}}, false)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("team creation failed: %v %s", rr.Code, rr.Body.String())
+	}
+	var team Team
+	json.Unmarshal(rr.Body.Bytes(), &team)
+
+	for i := 0; i < nbPlayers; i++ {
+		rr := doRequest(t, "POST", fmt.Sprintf("/teams/%s/players", team.ID), url.Values{"pseudo": {fmt.Sprintf("%s player %d", name, i)}}, false)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("player creation failed: %v %s", rr.Code, rr.Body.String())
+		}
+		var p Player
+		json.Unmarshal(rr.Body.Bytes(), &p)
+		team.Players = append(team.Players, p)
+	}
+	return team
+}
+
+// newTestGame creates and starts a game between 2 new teams of 3 players
+func newTestGame(t *testing.T, name string) Game {
+	team1 := newTestTeam(t, name+" team 1", 3)
+	team2 := newTestTeam(t, name+" team 2", 3)
+	rr := doRequest(t, "POST", "/games", url.Values{"name": {name}, "team1Id": {team1.ID}, "team2Id": {team2.ID}}, false)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("game creation failed: %v %s", rr.Code, rr.Body.String())
+	}
+	var g Game
+	json.Unmarshal(rr.Body.Bytes(), &g)
+	return g
+}
+
+// endSeasons ends the seasons still in progress, so that the games of the
+// following tests are played outside of any season
+func endSeasons() {
+	now := time.Now()
+	for _, s := range world.Seasons {
+		if now.Before(s.EndTime) {
+			ended := s
+			ended.EndTime = now
+			recordEvent(Event{Type: SeasonUpdated, Season: &ended, Time: now})
+		}
+	}
+}
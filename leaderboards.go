@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Leaderboard entities
+const (
+	EntityPlayers = "players"
+	EntityTeams   = "teams"
+)
+
+// defaultLeaderboardLimit is the number of entries of a leaderboard page when
+// no limit is provided, and maxLeaderboardLimit the maximum page size
+const (
+	defaultLeaderboardLimit = 20
+	maxLeaderboardLimit     = 100
+)
+
+// LeaderboardQuery defines a leaderboard: the metric ranked, the ranked
+// entity (players or teams) and the scope. An empty team, mode, season or
+// class means all teams, all modes, all seasons or all classes.
+// Classes only apply to players.
+type LeaderboardQuery struct {
+	Metric string `json:"metric"`
+	Entity string `json:"entity"`
+	TeamID string `json:"teamId,omitempty"`
+	Mode   string `json:"mode,omitempty"`
+	Season string `json:"season,omitempty"`
+	Class  string `json:"class,omitempty"`
+}
+
+// LeaderboardEntry is the rank of a player or team in a leaderboard.
+// Entries with the same value share the same rank.
+type LeaderboardEntry struct {
+	Rank  int     `json:"rank"`
+	ID    string  `json:"id"`
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+// LeaderboardPage is a page of a leaderboard
+type LeaderboardPage struct {
+	LeaderboardQuery
+	Total   int                `json:"total"`
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+// leaderboardVariables returns the metrics leaderboards can rank: the stats
+// and derived stats (see statsVariables), the rating, and the experience and
+// level of players
+func leaderboardVariables(stats Stats, rating Rating, pr Progression) map[string]float64 {
+	vars := statsVariables(stats)
+	vars["rating"] = rating.Rating
+	vars["xp"] = float64(pr.XP)
+	vars["level"] = float64(pr.Level)
+	return vars
+}
+
+// Leaderboard ranks the players or teams which played at least one stopped
+// game in the scope of the query.
+// Stats are accumulated in a single pass over the stopped games. The stats of
+// a team in a game are the stats of its members added together, and the team
+// plays and wins the game once. Ephemeral teams are not ranked.
+// Ratings are the ratings in the mode of the query (or in the default mode)
+// and in the season of the query (or in the rating season provided).
+func (w *World) Leaderboard(q LeaderboardQuery, ratingSeason string) []LeaderboardEntry {
+	stats := map[string]*Stats{}
+	names := map[string]string{}
+	var ids []string
+	add := func(id, name string, s Stats) {
+		if stats[id] == nil {
+			stats[id] = &Stats{}
+			ids = append(ids, id)
+		}
+		stats[id].Add(s)
+		names[id] = name
+	}
+
+	var members map[string]bool
+	if q.TeamID != "" {
+		members = map[string]bool{}
+		if t := w.Team(q.TeamID); t != nil {
+			for _, p := range t.Players {
+				members[p.ID] = true
+			}
+		}
+	}
+
+	for _, g := range w.StoppedGames() {
+		if (q.Mode != "" && g.Mode != q.Mode) || (q.Season != "" && g.SeasonID != q.Season) {
+			continue
+		}
+		for _, t := range []Team{g.Team1, g.Team2} {
+			if q.Entity == EntityTeams {
+				if t.Ephemeral || (q.TeamID != "" && t.ID != q.TeamID) {
+					continue
+				}
+				var s Stats
+				for _, p := range t.Players {
+					s.Add(p.Stats)
+				}
+				s.TotalNbGamesPlayed = 1
+				s.TotalNbWins = 0
+				if g.WinnerID == t.ID {
+					s.TotalNbWins = 1
+				}
+				s.TotalTimePlayedInSeconds = int(g.StopTime.Sub(g.StartTime).Seconds())
+				add(t.ID, t.Name, s)
+				continue
+			}
+			for _, p := range t.Players {
+				if (members == nil || members[p.ID]) && (q.Class == "" || p.Class == q.Class) {
+					add(p.ID, p.Pseudo, p.Stats)
+				}
+			}
+		}
+	}
+
+	mode := q.Mode
+	if mode == "" {
+		mode = DefaultMode
+	}
+	if q.Season != "" {
+		ratingSeason = q.Season
+	}
+	entries := make([]LeaderboardEntry, len(ids))
+	for i, id := range ids {
+		vars := leaderboardVariables(*stats[id], w.Rating(mode, ratingSeason, id), w.Progression[id])
+		entries[i] = LeaderboardEntry{ID: id, Name: names[id], Value: vars[q.Metric]}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Value != entries[j].Value {
+			return entries[i].Value > entries[j].Value
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	for i := range entries {
+		if i > 0 && entries[i].Value == entries[i-1].Value {
+			entries[i].Rank = entries[i-1].Rank
+		} else {
+			entries[i].Rank = i + 1
+		}
+	}
+	return entries
+}
+
+// maxCachedLeaderboards is the maximum number of leaderboards kept in cache,
+// as queries are made of parameters provided by clients
+const maxCachedLeaderboards = 1000
+
+// cachedLeaderboard is a leaderboard calculated from a version of the ranked
+// data of a world, during a season
+type cachedLeaderboard struct {
+	world   *World
+	version int
+	season  string
+	entries []LeaderboardEntry
+}
+
+// fresh checks whether a cached leaderboard can still be served: the ranked
+// data did not change (see World.RankedVersion), the world was not rebuilt
+// and the season did not change since it was calculated
+func (c cachedLeaderboard) fresh(season string) bool {
+	return c.world == world && c.version == world.RankedVersion && c.season == season
+}
+
+// Init leaderboards cache
+var leaderboardCache = map[LeaderboardQuery]cachedLeaderboard{}
+
+// cachedLeaderboardEntries returns the leaderboard of the current world,
+// calculating it only if the cache is outdated.
+// Outdated leaderboards are dropped from the cache, and the whole cache when
+// it is full.
+func cachedLeaderboardEntries(q LeaderboardQuery) []LeaderboardEntry {
+	season := world.seasonAt(time.Now())
+	if c, ok := leaderboardCache[q]; ok && c.fresh(season) {
+		return c.entries
+	}
+	entries := world.Leaderboard(q, season)
+
+	for key, c := range leaderboardCache {
+		if !c.fresh(season) {
+			delete(leaderboardCache, key)
+		}
+	}
+	if len(leaderboardCache) >= maxCachedLeaderboards {
+		leaderboardCache = map[LeaderboardQuery]cachedLeaderboard{}
+	}
+	leaderboardCache[q] = cachedLeaderboard{world: world, version: world.RankedVersion, season: season, entries: entries}
+	return entries
+}
+
+// leaderboardQuery reads a leaderboard query from the metric route variable
+// and the entity, teamId, mode and season query parameters.
+// If the query is invalid, a 400 response is written.
+func leaderboardQuery(w http.ResponseWriter, r *http.Request) (LeaderboardQuery, bool) {
+	q := LeaderboardQuery{
+		Metric: mux.Vars(r)["metric"],
+		Entity: r.URL.Query().Get("entity"),
+		TeamID: r.URL.Query().Get("teamId"),
+		Mode:   r.URL.Query().Get("mode"),
+		Season: r.URL.Query().Get("season"),
+		Class:  r.URL.Query().Get("class"),
+	}
+	if q.Entity == "" {
+		q.Entity = EntityPlayers
+	}
+	if q.Entity != EntityPlayers && q.Entity != EntityTeams {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Leaderboard could not be retrieved because of unknown entity"))
+		return q, false
+	}
+	if q.Entity == EntityTeams && q.Class != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Leaderboard could not be retrieved because classes only apply to players"))
+		return q, false
+	}
+	if _, ok := leaderboardVariables(Stats{}, Rating{}, Progression{})[q.Metric]; !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Leaderboard could not be retrieved because of unknown metric"))
+		return q, false
+	}
+	return q, true
+}
+
+// intQueryParam returns the value of an integer query parameter, or the
+// default value provided if the parameter is missing
+func intQueryParam(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// leaderboardHandler returns a page of the leaderboard of a metric.
+// The page is selected with the offset and limit query parameters.
+func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := leaderboardQuery(w, r)
+	if !ok {
+		return
+	}
+
+	offset, err := intQueryParam(r, "offset", 0)
+	if err != nil || offset < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Leaderboard could not be retrieved because of malformed offset parameter"))
+		return
+	}
+	limit, err := intQueryParam(r, "limit", defaultLeaderboardLimit)
+	if err != nil || limit < 1 || limit > maxLeaderboardLimit {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Leaderboard could not be retrieved because of malformed limit parameter"))
+		return
+	}
+
+	entries := cachedLeaderboardEntries(q)
+	page := LeaderboardPage{LeaderboardQuery: q, Total: len(entries), Entries: []LeaderboardEntry{}}
+	if offset < len(entries) {
+		end := offset + limit
+		if end > len(entries) {
+			end = len(entries)
+		}
+		page.Entries = entries[offset:end]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// leaderboardAroundHandler returns the entry of a player or team in the
+// leaderboard of a metric, along with the entries ranked just before and
+// after it (as many as the range query parameter, 5 by default)
+func leaderboardAroundHandler(w http.ResponseWriter, r *http.Request) {
+	q, ok := leaderboardQuery(w, r)
+	if !ok {
+		return
+	}
+
+	around, err := intQueryParam(r, "range", 5)
+	if err != nil || around < 0 || 2*around+1 > maxLeaderboardLimit {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Leaderboard could not be retrieved because of malformed range parameter"))
+		return
+	}
+
+	entries := cachedLeaderboardEntries(q)
+	for i, e := range entries {
+		if e.ID != mux.Vars(r)["id"] {
+			continue
+		}
+		start, end := i-around, i+around+1
+		if start < 0 {
+			start = 0
+		}
+		if end > len(entries) {
+			end = len(entries)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(LeaderboardPage{LeaderboardQuery: q, Total: len(entries), Entries: entries[start:end]})
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte("No entry in this leaderboard for this id"))
+}
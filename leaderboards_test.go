@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestLeaderboardHandler tests the ranking of players and teams of a mode,
+// pagination, "around me" queries, and that the cached leaderboard is
+// updated after new events
+func TestLeaderboardHandler(t *testing.T) {
+	team1 := newTestTeam(t, "Leaderboard Team 1", 3)
+	team2 := newTestTeam(t, "Leaderboard Team 2", 3)
+	playGame := func(kills map[string]int, winnerID string) {
+		rr := doRequest(t, "POST", "/games", url.Values{"name": {"Leaderboard Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}, "mode": {"leaderboard"}}, false)
+		var g Game
+		json.Unmarshal(rr.Body.Bytes(), &g)
+		for playerID, n := range kills {
+			for i := 0; i < n; i++ {
+				doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, playerID), url.Values{"name": {"nbKills"}}, false)
+			}
+		}
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {winnerID}}, false)
+	}
+	getPage := func(target string) LeaderboardPage {
+		rr := doRequest(t, "GET", target, nil, false)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code for %s: got %v want %v",
+				target, status, http.StatusOK)
+		}
+		var page LeaderboardPage
+		json.Unmarshal(rr.Body.Bytes(), &page)
+		return page
+	}
+
+	playGame(map[string]int{team1.Players[0].ID: 3, team2.Players[1].ID: 5}, team1.ID)
+
+	page := getPage("/leaderboards/nbKills?mode=leaderboard&limit=2")
+	if page.Total != 6 || len(page.Entries) != 2 ||
+		page.Entries[0].ID != team2.Players[1].ID || page.Entries[0].Value != 5 ||
+		page.Entries[1].ID != team1.Players[0].ID || page.Entries[1].Rank != 2 {
+		t.Errorf("handler returned unexpected leaderboard: got %+v", page)
+	}
+
+	page = getPage("/leaderboards/nbKills?mode=leaderboard&offset=2")
+	if len(page.Entries) != 4 || page.Entries[0].Rank != 3 || page.Entries[3].Rank != 3 {
+		t.Errorf("handler returned unexpected tied entries: got %+v", page)
+	}
+
+	page = getPage("/leaderboards/totalNbGamesWins?mode=leaderboard&entity=teams")
+	if page.Total != 2 || page.Entries[0].ID != team1.ID || page.Entries[0].Value != 1 || page.Entries[1].Value != 0 {
+		t.Errorf("handler returned unexpected team leaderboard: got %+v", page)
+	}
+
+	page = getPage(fmt.Sprintf("/leaderboards/nbKills?mode=leaderboard&teamId=%s", team1.ID))
+	if page.Total != 3 || page.Entries[0].ID != team1.Players[0].ID {
+		t.Errorf("handler returned unexpected team scoped leaderboard: got %+v", page)
+	}
+
+	page = getPage(fmt.Sprintf("/leaderboards/nbKills/around/%s?mode=leaderboard&range=1", team1.Players[0].ID))
+	if len(page.Entries) != 3 || page.Entries[1].ID != team1.Players[0].ID {
+		t.Errorf("handler returned unexpected entries around player: got %+v", page)
+	}
+
+	// A new game must not be hidden by the cache
+	playGame(map[string]int{team1.Players[0].ID: 3}, team1.ID)
+	page = getPage("/leaderboards/nbKills?mode=leaderboard&limit=1")
+	if page.Entries[0].ID != team1.Players[0].ID || page.Entries[0].Value != 6 {
+		t.Errorf("handler returned outdated leaderboard: got %+v", page)
+	}
+
+	for _, target := range []string{"/leaderboards/nbTeleports", "/leaderboards/nbKills?entity=games", "/leaderboards/nbKills?limit=0"} {
+		rr := doRequest(t, "GET", target, nil, false)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code for %s: got %v want %v",
+				target, status, http.StatusBadRequest)
+		}
+	}
+}
+
+// TestLeaderboardCache tests that cached leaderboards survive stat increments
+// in running games, and that outdated leaderboards are dropped from the
+// cache once the ranked data changes
+func TestLeaderboardCache(t *testing.T) {
+	team1 := newTestTeam(t, "Cached Leaderboard Team 1", 3)
+	team2 := newTestTeam(t, "Cached Leaderboard Team 2", 3)
+	q := LeaderboardQuery{Metric: "nbKills", Entity: EntityPlayers, Mode: "cache"}
+	other := LeaderboardQuery{Metric: "nbKills", Entity: EntityPlayers, TeamID: team1.ID}
+	cachedLeaderboardEntries(q)
+	cachedLeaderboardEntries(other)
+
+	rr := doRequest(t, "POST", "/games", url.Values{"name": {"Cached Leaderboard Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}, "mode": {"cache"}}, false)
+	var g Game
+	json.Unmarshal(rr.Body.Bytes(), &g)
+	doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, team1.Players[0].ID), url.Values{"name": {"nbKills"}}, false)
+	if c, ok := leaderboardCache[q]; !ok || !c.fresh(world.seasonAt(time.Now())) {
+		t.Errorf("cached leaderboard should survive a stat increment in a running game")
+	}
+
+	doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {team1.ID}}, false)
+	if entries := cachedLeaderboardEntries(q); len(entries) != 6 || entries[0].Value != 1 {
+		t.Errorf("cached leaderboard should be calculated again once the game stopped: got %+v", entries)
+	}
+	if _, ok := leaderboardCache[other]; ok {
+		t.Errorf("outdated leaderboards should be dropped from the cache")
+	}
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Division is a division of a league, the first division of a league being
+// the top one
+type Division struct {
+	Name    string   `json:"name"`
+	TeamIDs []string `json:"teamIds"`
+}
+
+// Fixture is a scheduled game between two teams of a division (given by its
+// index) of a league. Its game is created when the teams are ready to play.
+type Fixture struct {
+	ID       string `json:"id"`
+	Division int    `json:"division"`
+	Round    int    `json:"round"`
+	Team1ID  string `json:"team1Id"`
+	Team2ID  string `json:"team2Id"`
+	GameID   string `json:"gameId,omitempty"`
+}
+
+// LeagueStanding is the record of a team in its division.
+// Games have no score of their own: the score of a team in a game is the
+// number of kills of its players (see Game.Score), so ScoreFor is the number
+// of kills of the team over its games, and ScoreAgainst the number of kills
+// of its opponents.
+type LeagueStanding struct {
+	Rank            int    `json:"rank"`
+	TeamID          string `json:"teamId"`
+	Played          int    `json:"played"`
+	Wins            int    `json:"wins"`
+	Draws           int    `json:"draws"`
+	Losses          int    `json:"losses"`
+	ScoreFor        int    `json:"scoreFor"`
+	ScoreAgainst    int    `json:"scoreAgainst"`
+	ScoreDifference int    `json:"scoreDifference"`
+	Points          int    `json:"points"`
+}
+
+// DivisionStandings is the standings table of a division
+type DivisionStandings struct {
+	Division  string           `json:"division"`
+	Standings []LeagueStanding `json:"standings"`
+}
+
+// LeagueSeason is the archived final standings of a league season, with the
+// teams promoted and relegated at its end
+type LeagueSeason struct {
+	SeasonID  string              `json:"seasonId"`
+	Divisions []DivisionStandings `json:"divisions"`
+	Promoted  []string            `json:"promoted"`
+	Relegated []string            `json:"relegated"`
+}
+
+// League represents a league of teams split in divisions.
+// During a season, the teams of each division play each other twice. At the
+// end of the season, the NbPromoted best teams of each division are promoted
+// to the division above, and as many of the worst teams of the division above
+// are relegated.
+// Games are played on the map of the league, if any.
+type League struct {
+	ID            string         `json:"id"`
+	Name          string         `json:"name"`
+	Mode          string         `json:"mode"`
+	MapID         string         `json:"mapId,omitempty"`
+	PointsForWin  int            `json:"pointsForWin"`
+	PointsForDraw int            `json:"pointsForDraw"`
+	PointsForLoss int            `json:"pointsForLoss"`
+	NbPromoted    int            `json:"nbPromoted"`
+	Divisions     []Division     `json:"divisions"`
+	SeasonID      string         `json:"seasonId,omitempty"`
+	Fixtures      []Fixture      `json:"fixtures"`
+	History       []LeagueSeason `json:"history"`
+}
+
+// Init leagues.
+// As tournaments, leagues are not part of the event log: only the games of their
+// fixtures are.
+var leagues []League
+
+// league returns the league with the id provided, or nil if not found
+func league(id string) *League {
+	for i := range leagues {
+		if leagues[i].ID == id {
+			return &leagues[i]
+		}
+	}
+	return nil
+}
+
+// division returns the index of the division of a team in a league, or -1
+// if the team is not part of the league
+func (l *League) division(teamID string) int {
+	for i, d := range l.Divisions {
+		for _, id := range d.TeamIDs {
+			if id == teamID {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// StartSeason schedules the fixtures of a season: the teams of each division
+// play each other twice, once as team 1 and once as team 2
+func (l *League) StartSeason(seasonID string) {
+	l.SeasonID = seasonID
+	l.Fixtures = []Fixture{}
+	for d, division := range l.Divisions {
+		rounds := roundRobin(division.TeamIDs)
+		for leg := 0; leg < 2; leg++ {
+			for i, pairs := range rounds {
+				round := leg*len(rounds) + i + 1
+				for _, pair := range pairs {
+					if leg == 1 {
+						pair[0], pair[1] = pair[1], pair[0]
+					}
+					l.Fixtures = append(l.Fixtures, Fixture{
+						ID:       fmt.Sprintf("D%d-R%d-%d", d+1, round, len(l.Fixtures)+1),
+						Division: d,
+						Round:    round,
+						Team1ID:  pair[0],
+						Team2ID:  pair[1],
+					})
+				}
+			}
+		}
+	}
+}
+
+// Standings calculates the standings table of a division from the stopped
+// games of its fixtures.
+// Teams are ranked by points, then teams with the same points by the points
+// earned in the games between them (head-to-head), then by score difference
+// and by score.
+func (l *League) Standings(division int) []LeagueStanding {
+	standings := []LeagueStanding{}
+	index := map[string]int{}
+	for _, id := range l.Divisions[division].TeamIDs {
+		index[id] = len(standings)
+		standings = append(standings, LeagueStanding{TeamID: id})
+	}
+
+	// Points earned by each team against each other team
+	headToHead := map[string]map[string]int{}
+	for _, f := range l.Fixtures {
+		g := world.Game(f.GameID)
+		if f.Division != division || g == nil || g.StopTime.IsZero() {
+			continue
+		}
+		for _, teams := range [][2]string{{f.Team1ID, f.Team2ID}, {f.Team2ID, f.Team1ID}} {
+			i, ok := index[teams[0]]
+			if !ok {
+				continue
+			}
+			s := &standings[i]
+			s.Played++
+			s.ScoreFor += g.Score(teams[0])
+			s.ScoreAgainst += g.Score(teams[1])
+			points := l.PointsForLoss
+			switch {
+			case g.IsDraw():
+				s.Draws++
+				points = l.PointsForDraw
+			case g.WinnerID == teams[0]:
+				s.Wins++
+				points = l.PointsForWin
+			default:
+				s.Losses++
+			}
+			s.Points += points
+			if headToHead[teams[0]] == nil {
+				headToHead[teams[0]] = map[string]int{}
+			}
+			headToHead[teams[0]][teams[1]] += points
+		}
+	}
+	for i := range standings {
+		standings[i].ScoreDifference = standings[i].ScoreFor - standings[i].ScoreAgainst
+	}
+
+	sort.SliceStable(standings, func(i, j int) bool {
+		return standings[i].Points > standings[j].Points
+	})
+	for start := 0; start < len(standings); {
+		end := start + 1
+		for end < len(standings) && standings[end].Points == standings[start].Points {
+			end++
+		}
+		tied := standings[start:end]
+		h2h := map[string]int{}
+		for _, s := range tied {
+			for _, other := range tied {
+				h2h[s.TeamID] += headToHead[s.TeamID][other.TeamID]
+			}
+		}
+		sort.SliceStable(tied, func(i, j int) bool {
+			if h2h[tied[i].TeamID] != h2h[tied[j].TeamID] {
+				return h2h[tied[i].TeamID] > h2h[tied[j].TeamID]
+			}
+			if tied[i].ScoreDifference != tied[j].ScoreDifference {
+				return tied[i].ScoreDifference > tied[j].ScoreDifference
+			}
+			return tied[i].ScoreFor > tied[j].ScoreFor
+		})
+		start = end
+	}
+
+	for i := range standings {
+		standings[i].Rank = i + 1
+	}
+	return standings
+}
+
+// AllStandings calculates the standings tables of all the divisions
+func (l *League) AllStandings() []DivisionStandings {
+	var all []DivisionStandings
+	for i, d := range l.Divisions {
+		all = append(all, DivisionStandings{Division: d.Name, Standings: l.Standings(i)})
+	}
+	return all
+}
+
+// EndSeason archives the final standings of the season in progress, and
+// promotes and relegates teams between adjacent divisions.
+// All the moves are decided from the archived standings before any team is
+// moved, so that each team moves at most once: a team promoted from a
+// division cannot be relegated from it as well.
+func (l *League) EndSeason() {
+	archive := LeagueSeason{SeasonID: l.SeasonID, Divisions: l.AllStandings(), Promoted: []string{}, Relegated: []string{}}
+
+	moves := map[string]int{}
+	for i := 0; i+1 < len(l.Divisions); i++ {
+		var upper []LeagueStanding
+		for _, s := range archive.Divisions[i].Standings {
+			if _, moved := moves[s.TeamID]; !moved {
+				upper = append(upper, s)
+			}
+		}
+		lower := archive.Divisions[i+1].Standings
+		n := l.NbPromoted
+		if n > len(upper) {
+			n = len(upper)
+		}
+		if n > len(lower) {
+			n = len(lower)
+		}
+		for j := 0; j < n; j++ {
+			promoted, relegated := lower[j].TeamID, upper[len(upper)-1-j].TeamID
+			moves[promoted] = i
+			moves[relegated] = i + 1
+			archive.Promoted = append(archive.Promoted, promoted)
+			archive.Relegated = append(archive.Relegated, relegated)
+		}
+	}
+	for _, ids := range [][]string{archive.Promoted, archive.Relegated} {
+		for _, id := range ids {
+			l.moveTeam(id, moves[id])
+		}
+	}
+
+	l.History = append(l.History, archive)
+	l.SeasonID = ""
+	l.Fixtures = []Fixture{}
+}
+
+// moveTeam moves a team of a league to another division
+func (l *League) moveTeam(teamID string, division int) {
+	if from := l.division(teamID); from >= 0 {
+		ids := l.Divisions[from].TeamIDs
+		for i, id := range ids {
+			if id == teamID {
+				l.Divisions[from].TeamIDs = append(ids[:i:i], ids[i+1:]...)
+				break
+			}
+		}
+	}
+	l.Divisions[division].TeamIDs = append(l.Divisions[division].TeamIDs, teamID)
+}
+
+// rolloverLeagues ends the league seasons of a season which is over
+func rolloverLeagues(seasonID string) {
+	for i := range leagues {
+		if leagues[i].SeasonID == seasonID {
+			leagues[i].EndSeason()
+			log.Printf("Season of league %s ended", leagues[i].Name)
+		}
+	}
+}
+
+// leagueCreationHandler creates a league based on the name, mode, mapId
+// (optional), divisions (comma separated names, one division by default),
+// promoted (number of teams promoted at the end of a season, 1 by default),
+// and win, draw and loss (points earned, 3, 1 and 0 by default) parameters
+func leagueCreationHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("League could not be created because of malformed POST parameters"))
+		return
+	}
+	l := League{
+		ID:       uuid.New().String(),
+		Name:     r.Form.Get("name"),
+		Mode:     r.Form.Get("mode"),
+		MapID:    r.Form.Get("mapId"),
+		Fixtures: []Fixture{},
+		History:  []LeagueSeason{},
+	}
+	if l.Name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("League could not be created because of empty POST parameter"))
+		return
+	}
+	if l.Mode == "" {
+		l.Mode = DefaultMode
+	}
+	if !validMap(l.MapID) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("No map could be found with this id"))
+		return
+	}
+
+	names := []string{"Division 1"}
+	if v := r.Form.Get("divisions"); v != "" {
+		names = strings.Split(v, ",")
+	}
+	for _, name := range names {
+		l.Divisions = append(l.Divisions, Division{Name: name, TeamIDs: []string{}})
+	}
+
+	for _, param := range []struct {
+		name  string
+		value *int
+		def   int
+	}{
+		{"promoted", &l.NbPromoted, 1},
+		{"win", &l.PointsForWin, 3},
+		{"draw", &l.PointsForDraw, 1},
+		{"loss", &l.PointsForLoss, 0},
+	} {
+		*param.value = param.def
+		if v := r.Form.Get(param.name); v != "" {
+			*param.value, err = strconv.Atoi(v)
+			if err != nil || *param.value < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("League could not be created because of malformed " + param.name + " parameter"))
+				return
+			}
+		}
+	}
+
+	leagues = append(leagues, l)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l)
+}
+
+// leagueRegistrationHandler registers a team in a division of a league based
+// on the teamId and division (number of the division, 1 being the top one and
+// the default) parameters
+func leagueRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Team could not be registered because of malformed POST parameters"))
+		return
+	}
+
+	l := league(mux.Vars(r)["id"])
+	if l == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("League not found"))
+		return
+	}
+	if l.SeasonID != "" {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Team could not be registered because a league season is in progress"))
+		return
+	}
+
+	team := world.Team(r.Form.Get("teamId"))
+	if team == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Team not found"))
+		return
+	}
+	if team.Ephemeral {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Team could not be registered because it is the roster of a single game"))
+		return
+	}
+	if l.division(team.ID) >= 0 {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Team could not be registered because it is already registered"))
+		return
+	}
+
+	division := 1
+	if v := r.Form.Get("division"); v != "" {
+		division, err = strconv.Atoi(v)
+		if err != nil || division < 1 || division > len(l.Divisions) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Team could not be registered because of malformed division parameter"))
+			return
+		}
+	}
+
+	l.moveTeam(team.ID, division-1)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l)
+}
+
+// leagueSeasonStartHandler starts a league season in the season provided by
+// the season parameter (the current season by default), and schedules its
+// fixtures.
+// The league season ends with the season.
+func leagueSeasonStartHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("League season could not be started because of malformed POST parameters"))
+		return
+	}
+
+	l := league(mux.Vars(r)["id"])
+	if l == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("League not found"))
+		return
+	}
+	if l.SeasonID != "" {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("League season is already in progress"))
+		return
+	}
+
+	seasonID := r.Form.Get("season")
+	if seasonID == "" {
+		seasonID = world.seasonAt(time.Now())
+	}
+	s := world.season(seasonID)
+	if s == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Season not found"))
+		return
+	}
+	if s.Archived {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("League season could not be started because the season is over"))
+		return
+	}
+
+	l.StartSeason(s.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l)
+}
+
+// fixtureGameHandler creates the game of a fixture, and returns the game
+// created
+func fixtureGameHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	l := league(vars["id"])
+	if l == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("League not found"))
+		return
+	}
+	var f *Fixture
+	for i := range l.Fixtures {
+		if l.Fixtures[i].ID == vars["fixtureId"] {
+			f = &l.Fixtures[i]
+		}
+	}
+	if f == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Fixture not found"))
+		return
+	}
+	if f.GameID != "" {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Game of the fixture is already created"))
+		return
+	}
+
+	team1, team2 := world.Team(f.Team1ID), world.Team(f.Team2ID)
+	if team1 == nil || team2 == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Team not found"))
+		return
+	}
+	g := Game{Team1: *team1, Team2: *team2}
+	if !g.TeamSizesAreValid() {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Game could not be created because teams should have 3 to 5 players and both the same size"))
+		return
+	}
+
+	e := recordEvent(Event{Type: GameCreated, GameID: uuid.New().String(), Name: fmt.Sprintf("%s - %s", l.Name, f.ID), Team1ID: f.Team1ID, Team2ID: f.Team2ID, Mode: l.Mode, MapID: playableMap(l.MapID)})
+	f.GameID = e.GameID
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(world.Game(e.GameID))
+}
+
+// leaguesListingHandler lists all the leagues
+func leaguesListingHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(leagues)
+}
+
+// leagueRetrievalHandler returns a league with its fixtures and history
+func leagueRetrievalHandler(w http.ResponseWriter, r *http.Request) {
+	l := league(mux.Vars(r)["id"])
+	if l == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("League not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l)
+}
+
+// leagueStandingsHandler returns the standings tables of the divisions of a
+// league for the season in progress, or the archived final standings of the
+// season provided by the season query parameter
+func leagueStandingsHandler(w http.ResponseWriter, r *http.Request) {
+	l := league(mux.Vars(r)["id"])
+	if l == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("League not found"))
+		return
+	}
+
+	standings := l.AllStandings()
+	if seasonID := r.URL.Query().Get("season"); seasonID != "" && seasonID != l.SeasonID {
+		standings = nil
+		for _, archive := range l.History {
+			if archive.SeasonID == seasonID {
+				standings = archive.Divisions
+			}
+		}
+		if standings == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("League season not found"))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(standings)
+}
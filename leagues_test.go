@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestLeagues tests the fixtures and standings of a league season, with
+// draws and the head-to-head tie-breaker, and the promotions and relegations
+// at the end of the season
+func TestLeagues(t *testing.T) {
+	defer endSeasons()
+	now := time.Now()
+	rr := doRequest(t, "POST", "/admin/seasons", url.Values{"name": {"League Season"}, "start": {now.Format(time.RFC3339Nano)}, "end": {now.Add(time.Hour).Format(time.RFC3339Nano)}}, true)
+	var s Season
+	json.Unmarshal(rr.Body.Bytes(), &s)
+
+	rr = doRequest(t, "POST", "/admin/leagues", url.Values{"name": {"Community League"}, "divisions": {"Premier,Second"}, "mode": {"league"}}, true)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	var l League
+	json.Unmarshal(rr.Body.Bytes(), &l)
+	var teams []Team
+	for i, division := range []string{"1", "1", "1", "2", "2"} {
+		team := newTestTeam(t, fmt.Sprintf("League team %d", i), 3)
+		doRequest(t, "POST", fmt.Sprintf("/admin/leagues/%s/teams", l.ID), url.Values{"teamId": {team.ID}, "division": {division}}, true)
+		teams = append(teams, team)
+	}
+	a, b, c, d, e := teams[0].ID, teams[1].ID, teams[2].ID, teams[3].ID, teams[4].ID
+
+	rr = doRequest(t, "POST", fmt.Sprintf("/admin/leagues/%s/seasons", l.ID), nil, true)
+	json.Unmarshal(rr.Body.Bytes(), &l)
+	if l.SeasonID != s.ID || len(l.Fixtures) != 8 {
+		t.Fatalf("unexpected league season: got season %v and %v fixtures", l.SeasonID, len(l.Fixtures))
+	}
+
+	// B beats then draws A, A beats C twice with kills, B and C win one
+	// game each: A and B both have 7 points, B is ahead head-to-head
+	played := map[string]int{}
+	for _, f := range l.Fixtures {
+		rr = doRequest(t, "POST", fmt.Sprintf("/leagues/%s/fixtures/%s/game", l.ID, f.ID), nil, false)
+		var g Game
+		json.Unmarshal(rr.Body.Bytes(), &g)
+		has := func(id1, id2 string) bool {
+			return (f.Team1ID == id1 && f.Team2ID == id2) || (f.Team1ID == id2 && f.Team2ID == id1)
+		}
+		params := url.Values{}
+		switch {
+		case has(a, b):
+			params.Set("teamId", b)
+			if played["ab"]++; played["ab"] == 2 {
+				params = url.Values{"draw": {"true"}}
+			}
+		case has(a, c):
+			params.Set("teamId", a)
+			doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, teams[0].Players[0].ID), url.Values{"name": {"nbKills"}}, false)
+		case has(b, c):
+			params.Set("teamId", b)
+			if played["bc"]++; played["bc"] == 2 {
+				params.Set("teamId", c)
+			}
+		default:
+			params.Set("teamId", d)
+		}
+		rr = doRequest(t, "PUT", "/games/"+g.ID, params, false)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+		if params.Get("draw") != "" {
+			json.Unmarshal(rr.Body.Bytes(), &g)
+			if g.WinnerID != "" {
+				t.Errorf("draw should have no winner: got %v", g.WinnerID)
+			}
+		}
+	}
+
+	rr = doRequest(t, "GET", fmt.Sprintf("/leagues/%s/standings", l.ID), nil, false)
+	var standings []DivisionStandings
+	json.Unmarshal(rr.Body.Bytes(), &standings)
+	premier := standings[0].Standings
+	if premier[0].TeamID != b || premier[1].TeamID != a || premier[0].Points != 7 || premier[1].Points != 7 ||
+		premier[1].ScoreDifference != 2 || premier[0].Draws != 1 || premier[2].TeamID != c {
+		t.Errorf("unexpected standings: got %+v", premier)
+	}
+
+	rolloverSeasons(now.Add(2 * time.Hour))
+	rr = doRequest(t, "GET", fmt.Sprintf("/leagues/%s", l.ID), nil, false)
+	l = League{}
+	json.Unmarshal(rr.Body.Bytes(), &l)
+	if l.SeasonID != "" || l.division(d) != 0 || l.division(c) != 1 || l.division(e) != 1 ||
+		len(l.History) != 1 || l.History[0].Promoted[0] != d || l.History[0].Relegated[0] != c {
+		t.Errorf("unexpected league after the season: got %+v", l)
+	}
+
+	rr = doRequest(t, "GET", fmt.Sprintf("/leagues/%s/standings?season=%s", l.ID, s.ID), nil, false)
+	json.Unmarshal(rr.Body.Bytes(), &standings)
+	if standings[0].Standings[0].TeamID != b {
+		t.Errorf("unexpected archived standings: got %+v", standings)
+	}
+}
+
+// TestLeagueMoves tests that a team alone in a middle division is promoted
+// without being relegated as well
+func TestLeagueMoves(t *testing.T) {
+	l := League{NbPromoted: 1, Divisions: []Division{
+		{Name: "Premier", TeamIDs: []string{"a", "b"}},
+		{Name: "Second", TeamIDs: []string{"c"}},
+		{Name: "Third", TeamIDs: []string{"d", "e"}},
+	}}
+	l.EndSeason()
+	if l.division("c") != 0 || l.division("b") != 1 || l.division("d") != 2 ||
+		len(l.History[0].Promoted) != 1 || len(l.History[0].Relegated) != 1 {
+		t.Errorf("unexpected divisions after the season: got %+v with history %+v", l.Divisions, l.History)
+	}
+}
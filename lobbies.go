@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Lobby timeout outcomes
+const (
+	StartOnTimeout  = "start"
+	CancelOnTimeout = "cancel"
+)
+
+// defaultLobbyTimeout is the time given by default to the players of a lobby
+// to get ready
+const defaultLobbyTimeout = 5 * time.Minute
+
+// Lobby holds the settings of the lobby of a game: when the lobby times out
+// if some players are still not ready, and whether the game is then started
+// or cancelled
+type Lobby struct {
+	GameID    string    `json:"gameId"`
+	Deadline  time.Time `json:"deadline"`
+	OnTimeout string    `json:"onTimeout"`
+}
+
+// LobbyPlayer is the state of a rostered player in a lobby
+type LobbyPlayer struct {
+	PlayerID string `json:"playerId"`
+	Pseudo   string `json:"pseudo"`
+	TeamID   string `json:"teamId"`
+	Joined   bool   `json:"joined"`
+	Ready    bool   `json:"ready"`
+}
+
+// LobbyStatus is the state of a lobby and of its players
+type LobbyStatus struct {
+	Lobby
+	Status  string        `json:"status"`
+	Players []LobbyPlayer `json:"players"`
+}
+
+// Init lobby settings by game id.
+// As the matchmaking queue, lobby settings are not part of the event log:
+// only the players joining and getting ready, and the game starting or being
+// cancelled are. They are dropped once the game leaves the lobby.
+var lobbies = map[string]Lobby{}
+
+// newLobby reads the timeout (in seconds, 5 minutes by default) and
+// onTimeout (start or cancel, the default) parameters of a lobby, and returns
+// the name of the first malformed parameter if any.
+// The deadline is counted from the time provided.
+func newLobby(r *http.Request, now time.Time) (Lobby, string) {
+	l := Lobby{Deadline: now.Add(defaultLobbyTimeout), OnTimeout: CancelOnTimeout}
+	if v := r.Form.Get("timeout"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 1 {
+			return l, "timeout"
+		}
+		l.Deadline = now.Add(time.Duration(seconds) * time.Second)
+	}
+	switch v := r.Form.Get("onTimeout"); v {
+	case "":
+	case StartOnTimeout, CancelOnTimeout:
+		l.OnTimeout = v
+	default:
+		return l, "onTimeout"
+	}
+	return l, ""
+}
+
+// isIn checks whether an id is part of a list of ids
+func isIn(id string, ids []string) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+// AllReady checks whether all the rostered players of a game are ready
+func (g *Game) AllReady() bool {
+	for _, team := range []Team{g.Team1, g.Team2} {
+		for _, p := range team.Players {
+			if !isIn(p.ID, g.ReadyPlayerIDs) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// lobbyStatus returns the state of the lobby of a game
+func lobbyStatus(g *Game) LobbyStatus {
+	s := LobbyStatus{Lobby: lobbies[g.ID], Status: g.Status, Players: []LobbyPlayer{}}
+	s.GameID = g.ID
+	for _, team := range []Team{g.Team1, g.Team2} {
+		for _, p := range team.Players {
+			s.Players = append(s.Players, LobbyPlayer{
+				PlayerID: p.ID,
+				Pseudo:   p.Pseudo,
+				TeamID:   team.ID,
+				Joined:   isIn(p.ID, g.JoinedPlayerIDs),
+				Ready:    isIn(p.ID, g.ReadyPlayerIDs),
+			})
+		}
+	}
+	return s
+}
+
+// closeLobby drops the lobby settings and the draft of a game which left
+// the lobby
+func closeLobby(gameID string) {
+	delete(lobbies, gameID)
+	delete(drafts, gameID)
+}
+
+// expireLobbies starts or cancels the games whose lobby timed out at the
+// time provided.
+// Before a game starts, the remaining turns of its draft are played as if
+// they timed out.
+func expireLobbies(now time.Time) {
+	for id, l := range lobbies {
+		g := world.Game(id)
+		if g == nil || g.Status != GameStatusLobby {
+			closeLobby(id)
+			continue
+		}
+		if now.Before(l.Deadline) {
+			continue
+		}
+		log.Printf("Lobby of game %s timed out", g.Name)
+		if l.OnTimeout == StartOnTimeout {
+			if d, ok := drafts[id]; ok {
+				d.finish(g, now)
+			}
+			if g := world.Game(id); g.Status == GameStatusLobby {
+				recordEvent(Event{Type: GameStarted, GameID: id})
+			}
+		} else {
+			recordEvent(Event{Type: GameCancelled, GameID: id})
+		}
+		closeLobby(id)
+	}
+}
+
+// lobbyPlayer returns the game in lobby and the rostered player matching the
+// gameId and playerId route variables, or writes an error and returns false
+func lobbyPlayer(w http.ResponseWriter, r *http.Request) (*Game, *Player, bool) {
+	vars := mux.Vars(r)
+	g := world.Game(vars["gameId"])
+	if g == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Game not found"))
+		return nil, nil, false
+	}
+	p := g.Player(vars["playerId"])
+	if p == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Player not found"))
+		return nil, nil, false
+	}
+	if g.Status != GameStatusLobby {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Game is not in lobby"))
+		return nil, nil, false
+	}
+	return g, p, true
+}
+
+// lobbyJoinHandler makes a rostered player join the lobby of a game
+func lobbyJoinHandler(w http.ResponseWriter, r *http.Request) {
+	g, p, ok := lobbyPlayer(w, r)
+	if !ok {
+		return
+	}
+	if !isIn(p.ID, g.JoinedPlayerIDs) {
+		recordEvent(Event{Type: PlayerJoined, GameID: g.ID, PlayerID: p.ID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lobbyStatus(world.Game(g.ID)))
+}
+
+// lobbyReadyHandler marks a player who joined the lobby of a game as ready.
+// The game starts once all its rostered players are ready, and its draft (if
+// any) is done.
+func lobbyReadyHandler(w http.ResponseWriter, r *http.Request) {
+	g, p, ok := lobbyPlayer(w, r)
+	if !ok {
+		return
+	}
+	if !isIn(p.ID, g.JoinedPlayerIDs) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Player has not joined the lobby"))
+		return
+	}
+	if !isIn(p.ID, g.ReadyPlayerIDs) {
+		recordEvent(Event{Type: PlayerReady, GameID: g.ID, PlayerID: p.ID})
+		if world.Game(g.ID).AllReady() && draftDone(g.ID) {
+			recordEvent(Event{Type: GameStarted, GameID: g.ID})
+			closeLobby(g.ID)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lobbyStatus(world.Game(g.ID)))
+}
+
+// lobbyRetrievalHandler returns the state of the lobby of a game
+func lobbyRetrievalHandler(w http.ResponseWriter, r *http.Request) {
+	g := world.Game(mux.Vars(r)["gameId"])
+	if g == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Game not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lobbyStatus(g))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestLobbies tests that a game in lobby starts once all its players are
+// ready, and that a lobby which times out cancels its game
+func TestLobbies(t *testing.T) {
+	team1 := newTestTeam(t, "Lobby Team 1", 3)
+	team2 := newTestTeam(t, "Lobby Team 2", 3)
+	openLobby := func(params url.Values) Game {
+		params.Set("name", "Lobby Game")
+		params.Set("team1Id", team1.ID)
+		params.Set("team2Id", team2.ID)
+		params.Set("lobby", "true")
+		rr := doRequest(t, "POST", "/games", params, false)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+		var g Game
+		json.Unmarshal(rr.Body.Bytes(), &g)
+		return g
+	}
+
+	g := openLobby(url.Values{})
+	if g.Status != GameStatusLobby || !g.StartTime.IsZero() {
+		t.Errorf("game should be in lobby: got %+v", g)
+	}
+	players := append(team1.Players, team2.Players...)
+	rr := doRequest(t, "PUT", fmt.Sprintf("/games/%s/lobby/players/%s/ready", g.ID, players[0].ID), nil, false)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code for a player who did not join: got %v want %v",
+			status, http.StatusConflict)
+	}
+
+	var lobby LobbyStatus
+	for i, p := range players {
+		doRequest(t, "POST", fmt.Sprintf("/games/%s/lobby/players/%s", g.ID, p.ID), nil, false)
+		rr = doRequest(t, "PUT", fmt.Sprintf("/games/%s/lobby/players/%s/ready", g.ID, p.ID), nil, false)
+		json.Unmarshal(rr.Body.Bytes(), &lobby)
+		if i < len(players)-1 && (lobby.Status != GameStatusLobby || !lobby.Players[i].Ready || lobby.Players[i+1].Joined) {
+			t.Errorf("handler returned unexpected lobby: got %+v", lobby)
+		}
+	}
+	if g := world.Game(g.ID); lobby.Status != GameStatusRunning || g.StartTime.IsZero() {
+		t.Errorf("game should start once all players are ready: got %+v", g)
+	}
+
+	g = openLobby(url.Values{"timeout": {"60"}})
+	expireLobbies(time.Now())
+	if g := world.Game(g.ID); g.Status != GameStatusLobby {
+		t.Errorf("lobby should not time out before its deadline: got %+v", g)
+	}
+	expireLobbies(time.Now().Add(time.Minute))
+	if g := world.Game(g.ID); g.Status != GameStatusCancelled {
+		t.Errorf("lobby should be cancelled once timed out: got %+v", g)
+	}
+	rr = doRequest(t, "PUT", "/games/"+g.ID, url.Values{"teamId": {team1.ID}}, false)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for a cancelled game: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+
+	g = openLobby(url.Values{"timeout": {"60"}, "onTimeout": {StartOnTimeout}})
+	expireLobbies(time.Now().Add(time.Minute))
+	if g := world.Game(g.ID); g.Status != GameStatusRunning {
+		t.Errorf("lobby should start once timed out: got %+v", g)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// MapPlayerStats is the performance of a player on a map
+type MapPlayerStats struct {
+	PlayerID string  `json:"playerId"`
+	Pseudo   string  `json:"pseudo"`
+	NbGames  int     `json:"nbGames"`
+	NbWins   int     `json:"nbWins"`
+	WinRate  float64 `json:"winRate"`
+	Stats    Stats   `json:"stats"`
+}
+
+// MapStats sums up the stopped games played on a map: win rates by side
+// (team 1 or team 2), average duration in seconds, and the performance of
+// each player, the players who played the most first
+type MapStats struct {
+	MapID           string           `json:"mapId"`
+	Name            string           `json:"name"`
+	NbGames         int              `json:"nbGames"`
+	Team1Wins       int              `json:"team1Wins"`
+	Team2Wins       int              `json:"team2Wins"`
+	Draws           int              `json:"draws"`
+	Team1WinRate    float64          `json:"team1WinRate"`
+	Team2WinRate    float64          `json:"team2WinRate"`
+	AverageDuration float64          `json:"averageDuration"`
+	Players         []MapPlayerStats `json:"players,omitempty"`
+}
+
+// Maps (or arenas) games can be played on are the items of the draft catalog
+// of the map kind
+
+// gameMap returns the map of the catalog with the id provided, deleted or
+// not, or nil if not found
+func gameMap(id string) *DraftItem {
+	if item := draftItem(id); item != nil && item.Kind == "map" {
+		return item
+	}
+	return nil
+}
+
+// validMap checks whether games can be played on the map provided: no map
+// or a map of the catalog which is not deleted
+func validMap(id string) bool {
+	return id == "" || activeItem(id, "map") != nil
+}
+
+// playableMap returns the map provided if games can still be played on it,
+// or no map if it was deleted in the meantime
+func playableMap(id string) string {
+	if !validMap(id) {
+		return ""
+	}
+	return id
+}
+
+// maps returns the maps of the catalog which are not deleted
+func maps() []DraftItem {
+	all := []DraftItem{}
+	for _, item := range draftCatalog {
+		if item.Kind == "map" && !item.Deleted {
+			all = append(all, item)
+		}
+	}
+	return all
+}
+
+// MapStats calculates the stats of a map over the stopped games of a mode
+// (all modes if empty)
+func (w *World) MapStats(m DraftItem, mode string) MapStats {
+	s := MapStats{MapID: m.ID, Name: m.Name, Players: []MapPlayerStats{}}
+	index := map[string]int{}
+	duration := 0.0
+	for _, g := range w.StoppedGames() {
+		if g.MapID != m.ID || (mode != "" && g.Mode != mode) {
+			continue
+		}
+		s.NbGames++
+		duration += g.StopTime.Sub(g.StartTime).Seconds()
+		switch g.WinnerID {
+		case g.Team1.ID:
+			s.Team1Wins++
+		case g.Team2.ID:
+			s.Team2Wins++
+		default:
+			s.Draws++
+		}
+
+		for _, team := range []Team{g.Team1, g.Team2} {
+			for _, p := range team.Players {
+				i, ok := index[p.ID]
+				if !ok {
+					i = len(s.Players)
+					index[p.ID] = i
+					s.Players = append(s.Players, MapPlayerStats{PlayerID: p.ID})
+				}
+				s.Players[i].Pseudo = p.Pseudo
+				s.Players[i].NbGames++
+				s.Players[i].NbWins += p.Stats.TotalNbWins
+				s.Players[i].Stats.Add(p.Stats)
+			}
+		}
+	}
+
+	s.Team1WinRate = ratio(float64(s.Team1Wins), float64(s.NbGames))
+	s.Team2WinRate = ratio(float64(s.Team2Wins), float64(s.NbGames))
+	s.AverageDuration = ratio(duration, float64(s.NbGames))
+	for i := range s.Players {
+		s.Players[i].WinRate = ratio(float64(s.Players[i].NbWins), float64(s.Players[i].NbGames))
+	}
+	sort.SliceStable(s.Players, func(i, j int) bool {
+		return s.Players[i].NbGames > s.Players[j].NbGames
+	})
+	return s
+}
+
+// mapsListingHandler lists the maps of the catalog which are not deleted
+func mapsListingHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(maps())
+}
+
+// mapsStatsHandler returns the stats of every map of the catalog which is not
+// deleted, without the performance of the players, optionally restricted to
+// the mode provided by the mode query parameter
+func mapsStatsHandler(w http.ResponseWriter, r *http.Request) {
+	all := []MapStats{}
+	for _, m := range maps() {
+		s := world.MapStats(m, r.URL.Query().Get("mode"))
+		s.Players = nil
+		all = append(all, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(all)
+}
+
+// mapStatsHandler returns the stats of a map, even deleted, with the
+// performance of each player, optionally restricted to the mode provided by
+// the mode query parameter
+func mapStatsHandler(w http.ResponseWriter, r *http.Request) {
+	m := gameMap(mux.Vars(r)["id"])
+	if m == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Map not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(world.MapStats(*m, r.URL.Query().Get("mode")))
+}
+
+// mapCreationHandler adds a map to the draft catalog based on the id, name
+// and description parameters
+func mapCreationHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Map could not be created because of malformed POST parameters"))
+		return
+	}
+	m := DraftItem{ID: r.Form.Get("id"), Name: r.Form.Get("name"), Kind: "map", Description: r.Form.Get("description")}
+	if m.ID == "" || m.Name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Map could not be created because of empty POST parameter"))
+		return
+	}
+	if draftItem(m.ID) != nil {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Map could not be created because an item with the same id already exists"))
+		return
+	}
+
+	draftCatalog = append(draftCatalog, m)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+// mapDeletionHandler deletes a map of the catalog.
+// Games already played on the map keep it, and its stats can still be
+// retrieved.
+func mapDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	if !deleteItem(mux.Vars(r)["id"], "map") {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Map not found"))
+		return
+	}
+
+	w.Write([]byte("Map successfully deleted"))
+}
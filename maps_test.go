@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestMaps tests that games are played on a map of the catalog, the win
+// rates by side, average duration and player performance on a map, and that
+// the stats of a deleted map can still be retrieved
+func TestMaps(t *testing.T) {
+	defer func(catalog []DraftItem) { draftCatalog = catalog }(draftCatalog)
+	rr := doRequest(t, "POST", "/admin/maps", url.Values{"id": {"canyon"}, "name": {"Canyon"}}, true)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	rr = doRequest(t, "POST", "/admin/maps", url.Values{"id": {"canyon"}, "name": {"Canyon"}}, true)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code for a duplicate map: got %v want %v",
+			status, http.StatusConflict)
+	}
+
+	team1 := newTestTeam(t, "Map Team 1", 3)
+	team2 := newTestTeam(t, "Map Team 2", 3)
+	rr = doRequest(t, "POST", "/games", url.Values{"name": {"Map Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}, "mapId": {"volcano"}}, false)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for an unknown map: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+
+	player := team1.Players[0]
+	for i, winnerID := range []string{team1.ID, team1.ID, team2.ID} {
+		rr = doRequest(t, "POST", "/games", url.Values{"name": {"Map Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}, "mode": {"maps"}, "mapId": {"canyon"}}, false)
+		var g Game
+		json.Unmarshal(rr.Body.Bytes(), &g)
+		if g.MapID != "canyon" {
+			t.Fatalf("handler returned unexpected game: got %+v", g)
+		}
+		if i == 0 {
+			doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, player.ID), url.Values{"name": {"nbKills"}}, false)
+		}
+		doRequest(t, "PUT", "/games/"+g.ID, url.Values{"teamId": {winnerID}}, false)
+	}
+
+	rr = doRequest(t, "GET", "/maps/canyon/stats?mode=maps", nil, false)
+	var s MapStats
+	json.Unmarshal(rr.Body.Bytes(), &s)
+	if s.NbGames != 3 || s.Team1Wins != 2 || s.Team2Wins != 1 || s.Team1WinRate != 2.0/3 || s.AverageDuration < 0 || len(s.Players) != 6 {
+		t.Errorf("handler returned unexpected map stats: got %+v", s)
+	}
+	for _, p := range s.Players {
+		if p.PlayerID == player.ID && (p.NbGames != 3 || p.NbWins != 2 || p.Stats.NbKills != 1) {
+			t.Errorf("handler returned unexpected player performance: got %+v", p)
+		}
+	}
+
+	rr = doRequest(t, "GET", "/draft/items?kind=map", nil, false)
+	var items []DraftItem
+	json.Unmarshal(rr.Body.Bytes(), &items)
+	if len(items) != 1 || items[0].ID != "canyon" {
+		t.Errorf("maps should be items of the draft catalog: got %+v", items)
+	}
+
+	doRequest(t, "DELETE", "/admin/maps/canyon", nil, true)
+	rr = doRequest(t, "GET", "/maps/canyon/stats?mode=maps", nil, false)
+	s = MapStats{}
+	json.Unmarshal(rr.Body.Bytes(), &s)
+	if rr.Code != http.StatusOK || s.NbGames != 3 {
+		t.Errorf("handler returned unexpected stats for a deleted map: got %v %+v", rr.Code, s)
+	}
+	rr = doRequest(t, "GET", "/maps", nil, false)
+	var maps []DraftItem
+	json.Unmarshal(rr.Body.Bytes(), &maps)
+	if len(maps) != 0 {
+		t.Errorf("handler listed a deleted map: got %+v", maps)
+	}
+	rr = doRequest(t, "POST", "/games", url.Values{"name": {"Map Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}, "mapId": {"canyon"}}, false)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for a deleted map: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	rr = doRequest(t, "DELETE", "/admin/maps/canyon", nil, true)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code for a map already deleted: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
+
+// TestMapChoice tests that the map of a game can be drafted, and chosen for
+// the games of tournaments, challenges, leagues and matchmaking
+func TestMapChoice(t *testing.T) {
+	defer func(catalog []DraftItem) { draftCatalog = catalog }(draftCatalog)
+	doRequest(t, "POST", "/admin/maps", url.Values{"id": {"harbor"}, "name": {"Harbor"}}, true)
+	doRequest(t, "POST", "/admin/draft/items", url.Values{"id": {"jungle"}, "name": {"Jungle"}, "kind": {"map"}}, true)
+
+	team1 := newTestTeam(t, "Map Choice Team 1", 3)
+	team2 := newTestTeam(t, "Map Choice Team 2", 3)
+	rr := doRequest(t, "POST", "/games", url.Values{"name": {"Map Draft"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}, "mapId": {"harbor"}, "lobby": {"true"}}, false)
+	var g Game
+	json.Unmarshal(rr.Body.Bytes(), &g)
+	doRequest(t, "POST", fmt.Sprintf("/games/%s/draft", g.ID), url.Values{"sequence": {"pick:1"}, "kind": {"map"}}, false)
+	doRequest(t, "PUT", fmt.Sprintf("/games/%s/draft", g.ID), url.Values{"teamId": {team1.ID}, "itemId": {"jungle"}}, false)
+	if g := world.Game(g.ID); g.MapID != "jungle" {
+		t.Errorf("the map picked should be the map of the game: got %+v", g)
+	}
+	recordEvent(Event{Type: GameCancelled, GameID: g.ID})
+	closeLobby(g.ID)
+
+	rr = doRequest(t, "POST", "/admin/tournaments", url.Values{"name": {"Harbor Cup"}, "format": {SingleElimination}, "mapId": {"harbor"}}, true)
+	var tr Tournament
+	json.Unmarshal(rr.Body.Bytes(), &tr)
+	for _, team := range []Team{team1, team2} {
+		doRequest(t, "POST", fmt.Sprintf("/admin/tournaments/%s/teams", tr.ID), url.Values{"teamId": {team.ID}}, true)
+	}
+	rr = doRequest(t, "POST", fmt.Sprintf("/admin/tournaments/%s/start", tr.ID), nil, true)
+	json.Unmarshal(rr.Body.Bytes(), &tr)
+	if g := world.Game(tr.Matches[0].GameID); g == nil || g.MapID != "harbor" {
+		t.Errorf("the game of the tournament should be played on its map: got %+v", g)
+	} else {
+		doRequest(t, "PUT", "/games/"+g.ID, url.Values{"teamId": {team1.ID}}, false)
+	}
+
+	start := time.Now().Add(time.Hour).Format(time.RFC3339Nano)
+	rr = doRequest(t, "POST", "/challenges", url.Values{"teamId": {team1.ID}, "opponentId": {team2.ID}, "time": {start}, "mapId": {"harbor"}}, false)
+	var c Challenge
+	json.Unmarshal(rr.Body.Bytes(), &c)
+	doRequest(t, "PUT", "/challenges/"+c.ID, url.Values{"teamId": {team2.ID}, "action": {CounterChallenge}, "time": {start}, "mapId": {"jungle"}}, false)
+	rr = doRequest(t, "PUT", "/challenges/"+c.ID, url.Values{"teamId": {team1.ID}, "action": {AcceptChallenge}}, false)
+	json.Unmarshal(rr.Body.Bytes(), &c)
+	if g := world.Game(c.GameID); g == nil || g.MapID != "jungle" {
+		t.Errorf("the game of the challenge should be played on the map agreed: got %+v", g)
+	}
+	doRequest(t, "PUT", "/challenges/"+c.ID, url.Values{"teamId": {team1.ID}, "action": {CancelChallenge}}, false)
+
+	for _, target := range []string{"/admin/tournaments", "/admin/leagues", "/challenges", "/matchmaking/queue"} {
+		params := url.Values{"name": {"Volcano"}, "format": {RoundRobin}, "teamId": {team1.ID}, "opponentId": {team2.ID}, "time": {start}, "playerIds": {team1.Players[0].ID}, "mapId": {"volcano"}}
+		rr = doRequest(t, "POST", target, params, true)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler %s returned wrong status code for an unknown map: got %v want %v",
+				target, status, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Queue ticket statuses
+const (
+	TicketWaiting = "waiting"
+	TicketMatched = "matched"
+	TicketLeft    = "left"
+)
+
+// Matchmaking settings: the rating difference tolerated between the tickets
+// of a match when they join the queue, how much it widens per second waited,
+// and its maximum
+const (
+	matchBaseTolerance   = 100
+	matchToleranceGrowth = 10
+	matchMaxTolerance    = 1000
+	// matchMaxCandidates is the number of tickets considered to form a match
+	matchMaxCandidates = 10
+)
+
+// QueueTicket represents a player, or a party of players who want to play
+// in the same team, waiting in the matchmaking queue for a game of a mode,
+// and optionally on a map. Tickets are only matched with tickets of the same
+// mode and map.
+// Rating is the mean of the ratings of the players in this mode.
+type QueueTicket struct {
+	ID        string    `json:"id"`
+	PlayerIDs []string  `json:"playerIds"`
+	Mode      string    `json:"mode"`
+	MapID     string    `json:"mapId,omitempty"`
+	Rating    float64   `json:"rating"`
+	JoinedAt  time.Time `json:"joinedAt"`
+	Status    string    `json:"status"`
+	GameID    string    `json:"gameId,omitempty"`
+	TeamID    string    `json:"teamId,omitempty"`
+}
+
+// Tolerance returns the rating difference tolerated for a ticket after
+// waiting until the time provided
+func (t QueueTicket) Tolerance(now time.Time) float64 {
+	return math.Min(matchMaxTolerance, matchBaseTolerance+matchToleranceGrowth*now.Sub(t.JoinedAt).Seconds())
+}
+
+// Init matchmaking queue.
+// Tickets are kept once matched or left so that their status can be polled.
+var matchmakingQueue []QueueTicket
+
+// queueTicket returns the ticket with the id provided, or nil if not found
+func queueTicket(id string) *QueueTicket {
+	for i := range matchmakingQueue {
+		if matchmakingQueue[i].ID == id {
+			return &matchmakingQueue[i]
+		}
+	}
+	return nil
+}
+
+// createRoster records the creation of an ephemeral team made up of existing
+// players, as the roster of a single game, and returns its id.
+// The players keep their own team, and the roster is removed once the game
+// is over.
+func createRoster(name string, players []Player) string {
+	e := recordEvent(Event{Type: TeamCreated, TeamID: uuid.New().String(), Name: name, Ephemeral: true})
+	for _, p := range players {
+		recordEvent(Event{Type: PlayerAdded, TeamID: e.TeamID, PlayerID: p.ID, Name: p.Pseudo, Role: p.Role})
+	}
+	return e.TeamID
+}
+
+// bestSplit looks for a balanced match between candidate tickets, with teams
+// of the size provided, the first ticket being always part of the match.
+// The tickets closest in rating to the first one are picked until both
+// teams can be filled, then, from the largest parties to the smallest and
+// the highest ratings to the lowest, each ticket goes to the team with the
+// lowest total rating which still has room for it.
+// It returns the team (1 or 2, 0 if not part of the match) of each ticket
+// and whether a match was found.
+func bestSplit(candidates []QueueTicket, teamSize int) ([]int, bool) {
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order[1:], func(i, j int) bool {
+		ti, tj := candidates[order[i+1]], candidates[order[j+1]]
+		return math.Abs(ti.Rating-candidates[0].Rating) < math.Abs(tj.Rating-candidates[0].Rating)
+	})
+
+	var picked []int
+	nbPlayers := 0
+	for _, i := range order {
+		if n := len(candidates[i].PlayerIDs); nbPlayers+n <= 2*teamSize {
+			picked = append(picked, i)
+			nbPlayers += n
+		}
+	}
+	if nbPlayers != 2*teamSize || picked[0] != 0 {
+		return nil, false
+	}
+
+	sort.SliceStable(picked, func(i, j int) bool {
+		ti, tj := candidates[picked[i]], candidates[picked[j]]
+		if len(ti.PlayerIDs) != len(tj.PlayerIDs) {
+			return len(ti.PlayerIDs) > len(tj.PlayerIDs)
+		}
+		return ti.Rating > tj.Rating
+	})
+	split := make([]int, len(candidates))
+	var sizes [3]int
+	var sums [3]float64
+	for _, i := range picked {
+		n := len(candidates[i].PlayerIDs)
+		team := 1
+		if sizes[1]+n > teamSize || (sizes[2]+n <= teamSize && sums[2] < sums[1]) {
+			team = 2
+		}
+		if sizes[team]+n > teamSize {
+			return nil, false
+		}
+		split[i] = team
+		sizes[team] += n
+		sums[team] += candidates[i].Rating * float64(n)
+	}
+	return split, true
+}
+
+// matchQueue forms matches between the waiting tickets of each mode and
+// creates their games.
+// The oldest waiting ticket is matched first, with the tickets whose rating
+// is within its tolerance, preferring the largest teams and then the most
+// balanced ones.
+func matchQueue(now time.Time) {
+	for {
+		var waiting []*QueueTicket
+		for i := range matchmakingQueue {
+			t := &matchmakingQueue[i]
+			if t.Status != TicketWaiting {
+				continue
+			}
+			// Players removed since they joined the queue cannot be matched,
+			// and players in a game wait for it to be over
+			playing := false
+			for _, id := range t.PlayerIDs {
+				if world.Player(id) == nil {
+					t.Status = TicketLeft
+				}
+				if world.ActiveGames[id] != "" {
+					playing = true
+				}
+			}
+			if t.Status == TicketWaiting && !playing {
+				waiting = append(waiting, t)
+			}
+		}
+		sort.SliceStable(waiting, func(i, j int) bool {
+			return waiting[i].JoinedAt.Before(waiting[j].JoinedAt)
+		})
+
+		matched := false
+		for _, anchor := range waiting {
+			candidates := []QueueTicket{*anchor}
+			tickets := []*QueueTicket{anchor}
+			for _, t := range waiting {
+				if t != anchor && t.Mode == anchor.Mode && t.MapID == anchor.MapID && math.Abs(t.Rating-anchor.Rating) <= anchor.Tolerance(now) && len(candidates) < matchMaxCandidates {
+					candidates = append(candidates, *t)
+					tickets = append(tickets, t)
+				}
+			}
+			for teamSize := 5; teamSize >= 3; teamSize-- {
+				if split, ok := bestSplit(candidates, teamSize); ok {
+					matched = createMatch(tickets, split)
+					break
+				}
+			}
+			if matched {
+				break
+			}
+		}
+		if !matched {
+			return
+		}
+	}
+}
+
+// createMatch creates the rosters and the game of a match, and marks the
+// tickets of the match as matched.
+// It returns false if the teams of the match are not valid.
+func createMatch(tickets []*QueueTicket, split []int) bool {
+	var g Game
+	var players [3][]Player
+	for i, t := range tickets {
+		for _, id := range t.PlayerIDs {
+			if p := world.Player(id); p != nil {
+				players[split[i]] = append(players[split[i]], *p)
+			}
+		}
+	}
+	g.Team1.Players, g.Team2.Players = players[1], players[2]
+	if !g.TeamSizesAreValid() {
+		return false
+	}
+
+	mode, mapID := tickets[0].Mode, playableMap(tickets[0].MapID)
+	suffix := uuid.New().String()[:8]
+	team1ID := createRoster(fmt.Sprintf("Matchmaking %s team 1", suffix), players[1])
+	team2ID := createRoster(fmt.Sprintf("Matchmaking %s team 2", suffix), players[2])
+	e := recordEvent(Event{Type: GameCreated, GameID: uuid.New().String(), Name: "Matchmaking " + suffix, Team1ID: team1ID, Team2ID: team2ID, Mode: mode, MapID: mapID})
+
+	for i, t := range tickets {
+		if split[i] == 0 {
+			continue
+		}
+		t.Status = TicketMatched
+		t.GameID = e.GameID
+		t.TeamID = team1ID
+		if split[i] == 2 {
+			t.TeamID = team2ID
+		}
+	}
+	return true
+}
+
+// queueJoinHandler adds a player, or a party of players (comma separated
+// playerIds parameter), to the matchmaking queue of the mode and map
+// (optional mapId parameter) provided, and returns the ticket created
+func queueJoinHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Queue could not be joined because of malformed POST parameters"))
+		return
+	}
+	if r.Form.Get("playerIds") == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Queue could not be joined because of empty POST parameter"))
+		return
+	}
+	mode := r.Form.Get("mode")
+	if mode == "" {
+		mode = DefaultMode
+	}
+	mapID := r.Form.Get("mapId")
+	if !validMap(mapID) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("No map could be found with this id"))
+		return
+	}
+
+	t := QueueTicket{
+		ID:        uuid.New().String(),
+		PlayerIDs: strings.Split(r.Form.Get("playerIds"), ","),
+		Mode:      mode,
+		MapID:     mapID,
+		JoinedAt:  time.Now(),
+		Status:    TicketWaiting,
+	}
+	if len(t.PlayerIDs) > 5 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Queue could not be joined because parties have at most 5 players"))
+		return
+	}
+
+	seasonID := world.seasonAt(t.JoinedAt)
+	seen := map[string]bool{}
+	for _, id := range t.PlayerIDs {
+		if seen[id] {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Queue could not be joined because a player is given twice"))
+			return
+		}
+		seen[id] = true
+		if world.Player(id) == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Player not found"))
+			return
+		}
+		if world.ActiveGames[id] != "" {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("Queue could not be joined because a player is already in a game"))
+			return
+		}
+		for _, other := range matchmakingQueue {
+			for _, queued := range other.PlayerIDs {
+				if other.Status == TicketWaiting && queued == id {
+					w.WriteHeader(http.StatusConflict)
+					w.Write([]byte("Queue could not be joined because a player is already queued"))
+					return
+				}
+			}
+		}
+		t.Rating += world.Rating(mode, seasonID, id).Rating / float64(len(t.PlayerIDs))
+	}
+
+	matchmakingQueue = append(matchmakingQueue, t)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// QueueStatus is the status of a ticket, with the time waited so far and the
+// rating difference currently tolerated
+type QueueStatus struct {
+	QueueTicket
+	WaitSeconds float64 `json:"waitSeconds"`
+	Tolerance   float64 `json:"tolerance"`
+}
+
+// queueStatusHandler returns the status of a ticket. Once matched, the ticket
+// gives the game created and the team of the players.
+func queueStatusHandler(w http.ResponseWriter, r *http.Request) {
+	t := queueTicket(mux.Vars(r)["ticketId"])
+	if t == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Ticket not found"))
+		return
+	}
+
+	now := time.Now()
+	status := QueueStatus{QueueTicket: *t, WaitSeconds: now.Sub(t.JoinedAt).Seconds(), Tolerance: t.Tolerance(now)}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// queueLeaveHandler removes a waiting ticket from the matchmaking queue
+func queueLeaveHandler(w http.ResponseWriter, r *http.Request) {
+	t := queueTicket(mux.Vars(r)["ticketId"])
+	if t == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Ticket not found"))
+		return
+	}
+	if t.Status != TicketWaiting {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Queue could not be left because the ticket is " + t.Status))
+		return
+	}
+
+	t.Status = TicketLeft
+	w.Write([]byte("Queue successfully left"))
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TestMatchmakingQueue tests that solo players and parties are matched into
+// valid teams, keeping parties together, and that tickets can be left
+func TestMatchmakingQueue(t *testing.T) {
+	queued := newTestTeam(t, "Queued Players", 7)
+	players := queued.Players
+	join := func(ids ...string) *httptest.ResponseRecorder {
+		return doRequest(t, "POST", "/matchmaking/queue", url.Values{"playerIds": {strings.Join(ids, ",")}, "mode": {"queue"}}, false)
+	}
+
+	var tickets []QueueTicket
+	for _, ids := range [][]string{{players[0].ID, players[1].ID}, {players[2].ID}, {players[3].ID}, {players[4].ID}, {players[5].ID}, {players[6].ID}} {
+		rr := join(ids...)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+		var ticket QueueTicket
+		json.Unmarshal(rr.Body.Bytes(), &ticket)
+		tickets = append(tickets, ticket)
+	}
+	if rr := join(players[2].ID); rr.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			rr.Code, http.StatusConflict)
+	}
+
+	rr := doRequest(t, "DELETE", "/matchmaking/queue/"+tickets[5].ID, nil, false)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	matchQueue(time.Now())
+
+	statuses := map[string]QueueStatus{}
+	for _, ticket := range tickets {
+		rr := doRequest(t, "GET", "/matchmaking/queue/"+ticket.ID, nil, false)
+		var status QueueStatus
+		json.Unmarshal(rr.Body.Bytes(), &status)
+		statuses[ticket.ID] = status
+	}
+	if s := statuses[tickets[5].ID]; s.Status != TicketLeft || s.GameID != "" {
+		t.Errorf("handler returned unexpected status for a ticket left: got %+v", s)
+	}
+	party := statuses[tickets[0].ID]
+	g := world.Game(party.GameID)
+	if party.Status != TicketMatched || g == nil {
+		t.Fatalf("handler returned unexpected status for a party: got %+v", party)
+	}
+	if g.Mode != "queue" || !g.TeamSizesAreValid() || len(g.Team1.Players) != 3 {
+		t.Errorf("unexpected matched game: got %+v", g)
+	}
+	for _, ticket := range tickets[1:5] {
+		if s := statuses[ticket.ID]; s.Status != TicketMatched || s.GameID != g.ID {
+			t.Errorf("handler returned unexpected status: got %+v", s)
+		}
+	}
+	partyTeam := world.Team(party.TeamID)
+	if partyTeam == nil || len(partyTeam.Players) != 3 || partyTeam.Players[0].ID != players[0].ID || partyTeam.Players[1].ID != players[1].ID {
+		t.Errorf("party was not kept in the same team: got %+v", partyTeam)
+	}
+
+	// Players cannot queue while in a game, and the rosters of the match are
+	// removed once it is over, the players staying in their own team
+	if rr := join(players[0].ID); rr.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			rr.Code, http.StatusConflict)
+	}
+	// Rosters cannot join leagues or challenge other teams
+	rr = doRequest(t, "POST", "/admin/leagues", url.Values{"name": {"Roster League"}}, true)
+	var l League
+	json.Unmarshal(rr.Body.Bytes(), &l)
+	rr = doRequest(t, "POST", fmt.Sprintf("/admin/leagues/%s/teams", l.ID), url.Values{"teamId": {party.TeamID}}, true)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for a roster joining a league: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	start := time.Now().Add(time.Hour).Format(time.RFC3339Nano)
+	rr = doRequest(t, "POST", "/challenges", url.Values{"teamId": {queued.ID}, "opponentId": {party.TeamID}, "time": {start}}, false)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for a roster challenged: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+
+	doRequest(t, "PUT", "/games/"+g.ID, url.Values{"teamId": {party.TeamID}}, false)
+
+	// Rosters are neither ranked, rated nor given achievements
+	rr = doRequest(t, "GET", "/leaderboards/totalNbGamesWins?entity=teams&mode=queue", nil, false)
+	var page LeaderboardPage
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	if page.Total != 0 {
+		t.Errorf("handler ranked the rosters of a match: got %+v", page)
+	}
+	for _, teamID := range []string{g.Team1.ID, g.Team2.ID} {
+		if len(world.RatingIndexes[teamID]) != 0 || world.TeamIDs()[teamID] || world.teamUnlockIndex(teamID, "teamwork") >= 0 {
+			t.Errorf("roster %s was rated, counted or given achievements", teamID)
+		}
+	}
+	if world.Team(party.TeamID) != nil || world.Team(statuses[tickets[1].ID].TeamID) != nil {
+		t.Errorf("rosters of the match not removed once the game is over")
+	}
+	if team := world.Team(queued.ID); team == nil || len(team.Players) != 7 {
+		t.Errorf("players did not stay in their own team: got %+v", team)
+	}
+	if rr := join(players[0].ID); rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			rr.Code, http.StatusOK)
+	}
+}
+
+// TestBestSplit tests that the tickets closest in rating are matched into
+// balanced teams, keeping parties together
+func TestBestSplit(t *testing.T) {
+	ticket := func(rating float64, nbPlayers int) QueueTicket {
+		return QueueTicket{PlayerIDs: make([]string, nbPlayers), Rating: rating}
+	}
+	candidates := []QueueTicket{ticket(1500, 2), ticket(1400, 1), ticket(2400, 1), ticket(1600, 1), ticket(1550, 1), ticket(1450, 1)}
+	split, ok := bestSplit(candidates, 3)
+	if !ok || split[0] == 0 || split[2] != 0 {
+		t.Fatalf("unexpected split: got %v", split)
+	}
+	var sums [3]float64
+	for i, team := range split {
+		sums[team] += candidates[i].Rating * float64(len(candidates[i].PlayerIDs))
+	}
+	if math.Abs(sums[1]-sums[2]) > 100 {
+		t.Errorf("unbalanced split: got %v with total ratings %v", split, sums)
+	}
+
+	if _, ok := bestSplit(candidates[:3], 3); ok {
+		t.Errorf("split found without enough players")
+	}
+}
+
+// TestMatchmakingTolerance tests that tickets with distant ratings are only
+// matched once they waited long enough
+func TestMatchmakingTolerance(t *testing.T) {
+	players := newTestTeam(t, "Tolerance Players", 6).Players
+	joinedAt := time.Now()
+	for i, p := range players {
+		rating := 1500.0
+		if i > 0 {
+			rating = 1800
+		}
+		matchmakingQueue = append(matchmakingQueue, QueueTicket{
+			ID:        uuid.New().String(),
+			PlayerIDs: []string{p.ID},
+			Mode:      "tolerance",
+			Rating:    rating,
+			JoinedAt:  joinedAt,
+			Status:    TicketWaiting,
+		})
+	}
+	first := matchmakingQueue[len(matchmakingQueue)-len(players)].ID
+
+	matchQueue(joinedAt.Add(10 * time.Second))
+	if ticket := queueTicket(first); ticket.Status != TicketWaiting {
+		t.Errorf("ticket matched before its tolerance widened: got %+v", ticket)
+	}
+
+	matchQueue(joinedAt.Add(30 * time.Second))
+	if ticket := queueTicket(first); ticket.Status != TicketMatched {
+		t.Errorf("ticket not matched after its tolerance widened: got %+v", ticket)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// headToHeadWeight sets how fast the head-to-head history of two teams
+// weighs in a prediction: after this many games against each other, it
+// weighs a quarter of the prediction (and at most a half).
+const headToHeadWeight = 5
+
+// Prediction is the probability that each team of a game wins, before it
+// starts.
+// It combines the ratings of the players (composite ratings), the ratings of
+// the teams if they already played together, and their head-to-head history
+// in all modes and seasons (without its recent games).
+type Prediction struct {
+	Mode                string           `json:"mode"`
+	Team1ID             string           `json:"team1Id"`
+	Team2ID             string           `json:"team2Id"`
+	Team1Rating         Rating           `json:"team1Rating"`
+	Team2Rating         Rating           `json:"team2Rating"`
+	HeadToHead          HeadToHeadRecord `json:"headToHead"`
+	Team1WinProbability float64          `json:"team1WinProbability"`
+	Team2WinProbability float64          `json:"team2WinProbability"`
+}
+
+// Predict predicts the outcome of a game between two teams in a mode, with
+// the ratings of the season in progress at the time provided
+func (w *World) Predict(team1, team2 Team, mode string, t time.Time) Prediction {
+	seasonID := w.seasonAt(t)
+	p := Prediction{
+		Mode:        mode,
+		Team1ID:     team1.ID,
+		Team2ID:     team2.ID,
+		Team1Rating: w.TeamRating(mode, seasonID, team1),
+		Team2Rating: w.TeamRating(mode, seasonID, team2),
+		HeadToHead:  w.HeadToHeadRecord(team1.ID, team2.ID, false, "", "", 0),
+	}
+	probability := WinProbability(p.Team1Rating, p.Team2Rating)
+
+	// Teams which already played together have their own rating
+	rating1, ok1 := w.rating(mode, seasonID, team1.ID)
+	rating2, ok2 := w.rating(mode, seasonID, team2.ID)
+	if ok1 || ok2 {
+		if !ok1 {
+			rating1 = newRating()
+		}
+		if !ok2 {
+			rating2 = newRating()
+		}
+		probability = (probability + WinProbability(rating1, rating2)) / 2
+	}
+
+	// Head-to-head win rate, draws counting as half a win, with one win and
+	// one loss added so that a few games do not lead to certainties
+	if n := float64(p.HeadToHead.NbGames); n > 0 {
+		rate := (float64(p.HeadToHead.Side1.NbWins) + float64(p.HeadToHead.Draws)/2 + 1) / (n + 2)
+		weight := n / (n + headToHeadWeight) / 2
+		probability = (1-weight)*probability + weight*rate
+	}
+
+	p.Team1WinProbability = probability
+	p.Team2WinProbability = 1 - probability
+	return p
+}
+
+// CalibrationBucket compares the predictions falling in a range of
+// probabilities with the actual outcomes of the games
+type CalibrationBucket struct {
+	From           float64 `json:"from"`
+	To             float64 `json:"to"`
+	NbGames        int     `json:"nbGames"`
+	MeanPrediction float64 `json:"meanPrediction"`
+	ActualWinRate  float64 `json:"actualWinRate"`
+}
+
+// CalibrationReport compares the predictions made when games were created
+// with their outcomes.
+// The Brier score is the mean squared error of the predictions (0 is perfect,
+// 0.25 is as good as always predicting 50%), and the accuracy the rate of
+// games won by the favourite.
+type CalibrationReport struct {
+	NbGames    int                 `json:"nbGames"`
+	BrierScore float64             `json:"brierScore"`
+	Accuracy   float64             `json:"accuracy"`
+	Buckets    []CalibrationBucket `json:"buckets"`
+}
+
+// Calibration calculates the calibration report of the predictions of the
+// stopped games of a mode (all modes if empty), with predictions grouped in
+// the number of buckets provided.
+// Predictions are considered from the point of view of team 1.
+func (w *World) Calibration(mode string, nbBuckets int) CalibrationReport {
+	report := CalibrationReport{Buckets: make([]CalibrationBucket, nbBuckets)}
+	for i := range report.Buckets {
+		report.Buckets[i].From = float64(i) / float64(nbBuckets)
+		report.Buckets[i].To = float64(i+1) / float64(nbBuckets)
+	}
+
+	nbCorrect := 0
+	for _, g := range w.StoppedGames() {
+		p, ok := w.Predictions[g.ID]
+		if !ok || (mode != "" && g.Mode != mode) || g.WinnerID == "" {
+			continue
+		}
+		outcome := 0.0
+		if g.WinnerID == g.Team1.ID {
+			outcome = 1
+		}
+		predicted := p.Team1WinProbability
+
+		report.NbGames++
+		report.BrierScore += (predicted - outcome) * (predicted - outcome)
+		if (predicted > 0.5 && outcome == 1) || (predicted < 0.5 && outcome == 0) {
+			nbCorrect++
+		}
+
+		b := &report.Buckets[int(math.Min(predicted*float64(nbBuckets), float64(nbBuckets-1)))]
+		b.NbGames++
+		b.MeanPrediction += predicted
+		b.ActualWinRate += outcome
+	}
+
+	report.BrierScore = ratio(report.BrierScore, float64(report.NbGames))
+	report.Accuracy = ratio(float64(nbCorrect), float64(report.NbGames))
+	for i := range report.Buckets {
+		b := &report.Buckets[i]
+		b.MeanPrediction = ratio(b.MeanPrediction, float64(b.NbGames))
+		b.ActualWinRate = ratio(b.ActualWinRate, float64(b.NbGames))
+	}
+	return report
+}
+
+// predictionHandler predicts the outcome of a game between the teams provided
+// by the team1Id and team2Id query parameters, in the mode provided by the
+// mode query parameter
+func predictionHandler(w http.ResponseWriter, r *http.Request) {
+	team1 := world.Team(r.URL.Query().Get("team1Id"))
+	team2 := world.Team(r.URL.Query().Get("team2Id"))
+	if team1 == nil || team2 == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Team not found"))
+		return
+	}
+	if team1.ID == team2.ID {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("The teams should not be equal"))
+		return
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = DefaultMode
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(world.Predict(*team1, *team2, mode, time.Now()))
+}
+
+// gamePredictionHandler returns the prediction made when a game was created
+func gamePredictionHandler(w http.ResponseWriter, r *http.Request) {
+	wd, err := requestedWorld(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Prediction could not be retrieved because of malformed as_of parameter"))
+		return
+	}
+
+	p, ok := wd.Predictions[mux.Vars(r)["id"]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Game not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// calibrationHandler returns the calibration report of the predictions,
+// optionally restricted to the mode provided by the mode query parameter.
+// The number of buckets is provided by the buckets query parameter (10 by
+// default).
+func calibrationHandler(w http.ResponseWriter, r *http.Request) {
+	nbBuckets, err := intQueryParam(r, "buckets", 10)
+	if err != nil || nbBuckets < 1 || nbBuckets > 100 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Calibration report could not be calculated because of malformed buckets parameter"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(world.Calibration(r.URL.Query().Get("mode"), nbBuckets))
+}
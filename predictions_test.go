@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestPredictions tests that predictions favour the team which won previous
+// games, that the prediction of a game is stored at creation, and the
+// calibration report of these predictions
+func TestPredictions(t *testing.T) {
+	team1 := newTestTeam(t, "Favourite Team", 3)
+	team2 := newTestTeam(t, "Underdog Team", 3)
+	var games []Game
+	for i := 0; i < 2; i++ {
+		rr := doRequest(t, "POST", "/games", url.Values{"name": {"Predicted Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}, "mode": {"prediction"}}, false)
+		var g Game
+		json.Unmarshal(rr.Body.Bytes(), &g)
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {team1.ID}}, false)
+		games = append(games, g)
+	}
+
+	rr := doRequest(t, "GET", fmt.Sprintf("/games/%s/prediction", games[0].ID), nil, false)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	var p Prediction
+	json.Unmarshal(rr.Body.Bytes(), &p)
+	if p.Team1WinProbability != 0.5 || p.HeadToHead.NbGames != 0 {
+		t.Errorf("handler returned unexpected prediction for the first game: got %+v", p)
+	}
+
+	rr = doRequest(t, "GET", fmt.Sprintf("/predictions?team1Id=%s&team2Id=%s&mode=prediction", team1.ID, team2.ID), nil, false)
+	json.Unmarshal(rr.Body.Bytes(), &p)
+	if p.Team1WinProbability <= 0.5 || math.Abs(p.Team1WinProbability+p.Team2WinProbability-1) > 0.000001 ||
+		p.HeadToHead.NbGames != 2 || p.HeadToHead.Side1.NbWins != 2 {
+		t.Errorf("handler returned unexpected prediction: got %+v", p)
+	}
+
+	rr = doRequest(t, "GET", "/predictions/calibration?mode=prediction&buckets=2", nil, false)
+	var report CalibrationReport
+	json.Unmarshal(rr.Body.Bytes(), &report)
+	if report.NbGames != 2 || report.Accuracy != 0.5 || report.BrierScore >= 0.25 || len(report.Buckets) != 2 ||
+		report.Buckets[1].NbGames != 2 || report.Buckets[1].ActualWinRate != 1 {
+		t.Errorf("handler returned unexpected calibration report: got %+v", report)
+	}
+}
+
+// TestDrawPredictions tests that draws count as half a win in the head-to-head
+// history of two evenly matched teams
+func TestDrawPredictions(t *testing.T) {
+	team1 := newTestTeam(t, "Draw Team 1", 3)
+	team2 := newTestTeam(t, "Draw Team 2", 3)
+	for i := 0; i < 2; i++ {
+		rr := doRequest(t, "POST", "/games", url.Values{"name": {"Draw Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}, "mode": {"draws"}}, false)
+		var g Game
+		json.Unmarshal(rr.Body.Bytes(), &g)
+		doRequest(t, "PUT", "/games/"+g.ID, url.Values{"draw": {"true"}}, false)
+	}
+
+	p := world.Predict(*world.Team(team1.ID), *world.Team(team2.ID), "draws", time.Now())
+	if p.HeadToHead.Draws != 2 || math.Abs(p.Team1WinProbability-0.5) > 1e-9 {
+		t.Errorf("unexpected prediction after draws: got %+v", p)
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultMode is the mode of games created without a mode
+const DefaultMode = "default"
+
+// Glicko-2 constants: initial rating, rating deviation and volatility,
+// system constant (constraining volatility changes) and scale factor between
+// the Glicko and Glicko-2 scales
+const (
+	initialRating     = 1500
+	initialDeviation  = 350
+	initialVolatility = 0.06
+	glickoTau         = 0.5
+	glickoScale       = 173.7178
+)
+
+// Soft reset applied to ratings at the beginning of a season: ratings are
+// brought closer to the initial rating by this factor, and deviations are
+// raised as if this many rating points of uncertainty were added
+const (
+	seasonResetFactor    = 0.5
+	seasonResetDeviation = 100
+)
+
+// Rating is a Glicko-2 skill rating. The deviation measures the uncertainty
+// of the rating: the real skill is within 2 deviations of the rating with a
+// 95% confidence.
+type Rating struct {
+	Rating     float64 `json:"rating"`
+	Deviation  float64 `json:"deviation"`
+	Volatility float64 `json:"volatility"`
+	NbGames    int     `json:"nbGames"`
+}
+
+// RatingChange records the rating of a player or team before and after a game.
+// Entity is either "player" or "team".
+type RatingChange struct {
+	Entity string    `json:"entity"`
+	ID     string    `json:"id"`
+	Mode   string    `json:"mode"`
+	Season string    `json:"season,omitempty"`
+	GameID string    `json:"gameId"`
+	Before Rating    `json:"before"`
+	After  Rating    `json:"after"`
+	Time   time.Time `json:"time"`
+	Seq    int       `json:"seq"`
+}
+
+// newRating returns the rating of a player or team who never played
+func newRating() Rating {
+	return Rating{Rating: initialRating, Deviation: initialDeviation, Volatility: initialVolatility}
+}
+
+// glickoG reduces the impact of a game according to the deviation of the
+// opponent
+func glickoG(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+// Expected returns the probability of winning against an opponent
+func (r Rating) Expected(opponent Rating) float64 {
+	mu := (r.Rating - initialRating) / glickoScale
+	muj := (opponent.Rating - initialRating) / glickoScale
+	phij := opponent.Deviation / glickoScale
+	return 1 / (1 + math.Exp(-glickoG(phij)*(mu-muj)))
+}
+
+// WinProbability returns the probability that a player or team with the
+// first rating wins against an opponent with the second rating.
+// Unlike Expected, it takes the uncertainty of both ratings into account,
+// so that the probabilities of both sides add up to 1.
+func WinProbability(r, opponent Rating) float64 {
+	mu := (r.Rating - initialRating) / glickoScale
+	muj := (opponent.Rating - initialRating) / glickoScale
+	phi := math.Sqrt(r.Deviation*r.Deviation+opponent.Deviation*opponent.Deviation) / glickoScale
+	return 1 / (1 + math.Exp(-glickoG(phi)*(mu-muj)))
+}
+
+// Update returns the rating after a game against an opponent, the score
+// being 1 for a win and 0 for a loss
+func (r Rating) Update(opponent Rating, score float64) Rating {
+	mu := (r.Rating - initialRating) / glickoScale
+	phi := r.Deviation / glickoScale
+	phij := opponent.Deviation / glickoScale
+	g := glickoG(phij)
+	e := r.Expected(opponent)
+
+	v := 1 / (g * g * e * (1 - e))
+	delta := v * g * (score - e)
+
+	// Find the new volatility with the Illinois algorithm
+	a := math.Log(r.Volatility * r.Volatility)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		d := phi*phi + v + ex
+		return ex*(delta*delta-phi*phi-v-ex)/(2*d*d) - (x-a)/(glickoTau*glickoTau)
+	}
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*glickoTau) < 0 {
+			k++
+		}
+		B = a - k*glickoTau
+	}
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > 0.000001 {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB <= 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	volatility := math.Exp(A / 2)
+
+	phiStar := math.Sqrt(phi*phi + volatility*volatility)
+	newPhi := 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	newMu := mu + newPhi*newPhi*g*(score-e)
+
+	return Rating{
+		Rating:     newMu*glickoScale + initialRating,
+		Deviation:  newPhi * glickoScale,
+		Volatility: volatility,
+		NbGames:    r.NbGames + 1,
+	}
+}
+
+// compositeRating combines the ratings of the members of a team into a
+// single opponent rating: the mean of the ratings, with the root mean square
+// of the deviations
+func compositeRating(ratings []Rating) Rating {
+	if len(ratings) == 0 {
+		return newRating()
+	}
+	var c Rating
+	for _, r := range ratings {
+		c.Rating += r.Rating
+		c.Deviation += r.Deviation * r.Deviation
+		c.Volatility += r.Volatility
+	}
+	n := float64(len(ratings))
+	c.Rating /= n
+	c.Deviation = math.Sqrt(c.Deviation / n)
+	c.Volatility /= n
+	return c
+}
+
+// softReset returns the rating a player or team starts a new season with
+func (r Rating) softReset() Rating {
+	return Rating{
+		Rating:     initialRating + (r.Rating-initialRating)*seasonResetFactor,
+		Deviation:  math.Min(initialDeviation, math.Sqrt(r.Deviation*r.Deviation+seasonResetDeviation*seasonResetDeviation)),
+		Volatility: r.Volatility,
+	}
+}
+
+// ratingKey returns the key of the ratings of a mode in a season (empty for
+// the latest ratings, whatever the season)
+func ratingKey(mode, seasonID string) string {
+	if seasonID == "" {
+		return mode
+	}
+	return mode + "@" + seasonID
+}
+
+// Rating returns the rating of a player or team in a mode and season.
+// A player or team who has not played yet in a season starts it with a soft
+// reset of his last rating before the season, whether it comes from a
+// previous season or from games played between seasons. Outside of seasons,
+// the latest rating is carried as is.
+func (w *World) Rating(mode, seasonID, id string) Rating {
+	if r, ok := w.rating(mode, seasonID, id); ok {
+		return r
+	}
+	return newRating()
+}
+
+// rating returns the rating of a player or team in a mode and season, and
+// whether he was ever rated in this mode up to this season
+func (w *World) rating(mode, seasonID, id string) (Rating, bool) {
+	if r, ok := w.Ratings[ratingKey(mode, seasonID)][id]; ok {
+		return r, true
+	}
+	s := w.season(seasonID)
+	if s == nil {
+		return Rating{}, false
+	}
+	indexes := w.RatingIndexes[id]
+	for i := len(indexes) - 1; i >= 0; i-- {
+		c := w.RatingLog[indexes[i]]
+		if c.Mode == mode && c.Time.Before(s.StartTime) {
+			return c.After.softReset(), true
+		}
+	}
+	return Rating{}, false
+}
+
+// TeamRating returns the composite rating of the members of a team in a mode
+// and season
+func (w *World) TeamRating(mode, seasonID string, t Team) Rating {
+	var ratings []Rating
+	for _, p := range t.Players {
+		ratings = append(ratings, w.Rating(mode, seasonID, p.ID))
+	}
+	return compositeRating(ratings)
+}
+
+// UpdateRatings updates the ratings of both teams of a stopped game and of
+// their players, in the mode and season of the game, as well as their latest
+// ratings in the mode.
+// Teams are rated against each other, and players against the composite
+// rating of the opposing players.
+// Ephemeral teams are not rated: they only play one game. A team facing an
+// ephemeral team is rated against the composite rating of its players.
+func (w *World) UpdateRatings(g *Game, e Event) {
+	keys := []string{ratingKey(g.Mode, "")}
+	if g.SeasonID != "" {
+		keys = append(keys, ratingKey(g.Mode, g.SeasonID))
+	}
+	if w.Ratings == nil {
+		w.Ratings = map[string]map[string]Rating{}
+	}
+	if w.RatingIndexes == nil {
+		w.RatingIndexes = map[string][]int{}
+	}
+	for _, key := range keys {
+		if w.Ratings[key] == nil {
+			w.Ratings[key] = map[string]Rating{}
+		}
+	}
+
+	// Calculate all the new ratings from the ratings before the game
+	type update struct {
+		entity, id    string
+		before, after Rating
+	}
+	var updates []update
+	for _, teams := range [][2]*Team{{&g.Team1, &g.Team2}, {&g.Team2, &g.Team1}} {
+		team, opponent := teams[0], teams[1]
+		score := 0.0
+		switch g.WinnerID {
+		case team.ID:
+			score = 1
+		case "":
+			// Draw
+			score = 0.5
+		}
+
+		opponentRating := w.TeamRating(g.Mode, g.SeasonID, *opponent)
+		if !team.Ephemeral {
+			opponentTeamRating := opponentRating
+			if !opponent.Ephemeral {
+				opponentTeamRating = w.Rating(g.Mode, g.SeasonID, opponent.ID)
+			}
+			before := w.Rating(g.Mode, g.SeasonID, team.ID)
+			updates = append(updates, update{"team", team.ID, before, before.Update(opponentTeamRating, score)})
+		}
+
+		for _, p := range team.Players {
+			before := w.Rating(g.Mode, g.SeasonID, p.ID)
+			updates = append(updates, update{"player", p.ID, before, before.Update(opponentRating, score)})
+		}
+	}
+
+	for _, u := range updates {
+		for _, key := range keys {
+			w.Ratings[key][u.id] = u.after
+		}
+		w.RatingIndexes[u.id] = append(w.RatingIndexes[u.id], len(w.RatingLog))
+		w.RatingLog = append(w.RatingLog, RatingChange{
+			Entity: u.entity,
+			ID:     u.id,
+			Mode:   g.Mode,
+			Season: g.SeasonID,
+			GameID: g.ID,
+			Before: u.before,
+			After:  u.after,
+			Time:   e.Time,
+			Seq:    e.Seq,
+		})
+	}
+}
+
+// RatingsOf returns the ratings of a player or team in a season, in every
+// mode played in this season or the previous ones
+func (w *World) RatingsOf(id, seasonID string) map[string]Rating {
+	modes := map[string]bool{}
+	for _, i := range w.RatingIndexes[id] {
+		modes[w.RatingLog[i].Mode] = true
+	}
+	ratings := map[string]Rating{}
+	for mode := range modes {
+		if r, ok := w.rating(mode, seasonID, id); ok {
+			ratings[mode] = r
+		}
+	}
+	return ratings
+}
+
+// RatingHistory returns the rating changes of a player or team, in the order
+// the games were stopped, optionally filtered by mode and season
+func (w *World) RatingHistory(id, mode, seasonID string) []RatingChange {
+	history := []RatingChange{}
+	for _, i := range w.RatingIndexes[id] {
+		if c := w.RatingLog[i]; (mode == "" || c.Mode == mode) && (seasonID == "" || c.Season == seasonID) {
+			history = append(history, c)
+		}
+	}
+	return history
+}
+
+// ratingsHandler returns the ratings of a player (playerId route variable)
+// or team (id route variable) in every mode, in the season provided by the
+// season query parameter (by default the season in progress now, or at the
+// time provided by the as_of query parameter)
+func ratingsHandler(w http.ResponseWriter, r *http.Request) {
+	wd, err := requestedWorld(r)
+	at, _ := requestedTime(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Ratings could not be retrieved because of malformed as_of parameter"))
+		return
+	}
+
+	id, ok := ratedEntity(wd, w, r)
+	if !ok {
+		return
+	}
+
+	seasonID := r.URL.Query().Get("season")
+	if seasonID == "" {
+		seasonID = wd.seasonAt(at)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wd.RatingsOf(id, seasonID))
+}
+
+// ratingHistoryHandler lists the rating changes of a player (playerId route
+// variable) or team (id route variable), optionally filtered by the mode and
+// season query parameters
+func ratingHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	wd, err := requestedWorld(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Rating history could not be retrieved because of malformed as_of parameter"))
+		return
+	}
+
+	id, ok := ratedEntity(wd, w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wd.RatingHistory(id, r.URL.Query().Get("mode"), r.URL.Query().Get("season")))
+}
+
+// ratedEntity returns the id of the player (playerId route variable) or team
+// (id route variable) of a ratings or head-to-head request.
+// If it cannot be found, a 404 response is written.
+func ratedEntity(wd *World, w http.ResponseWriter, r *http.Request) (string, bool) {
+	vars := mux.Vars(r)
+
+	if id, ok := vars["playerId"]; ok {
+		if wd.Player(id) == nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("Player not found"))
+			return "", false
+		}
+		return id, true
+	}
+
+	if !wd.TeamIDs()[vars["id"]] {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Team not found"))
+		return "", false
+	}
+	return vars["id"], true
+}
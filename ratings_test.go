@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// TestRatingUpdate tests that a win against an equal opponent raises the
+// rating, that the deviation shrinks, and that an upset moves ratings more
+func TestRatingUpdate(t *testing.T) {
+	r := newRating()
+	if e := r.Expected(newRating()); e != 0.5 {
+		t.Errorf("unexpected expected score against an equal opponent: got %v want %v", e, 0.5)
+	}
+
+	won := r.Update(newRating(), 1)
+	lost := r.Update(newRating(), 0)
+	if won.Rating <= initialRating || lost.Rating >= initialRating || math.Abs(won.Rating-initialRating-(initialRating-lost.Rating)) > 0.001 {
+		t.Errorf("unexpected ratings after a game: won %+v, lost %+v", won, lost)
+	}
+	if won.Deviation >= initialDeviation || won.NbGames != 1 {
+		t.Errorf("unexpected deviation after a game: got %+v", won)
+	}
+
+	strong := Rating{Rating: 1900, Deviation: 50, Volatility: initialVolatility}
+	weak := Rating{Rating: 1400, Deviation: 50, Volatility: initialVolatility}
+	upsetGain := weak.Update(strong, 1).Rating - weak.Rating
+	expectedGain := strong.Update(weak, 1).Rating - strong.Rating
+	if upsetGain <= expectedGain {
+		t.Errorf("an upset should move ratings more: got %v for the upset and %v otherwise", upsetGain, expectedGain)
+	}
+}
+
+// TestRatingsHandler tests that stopping a game updates the ratings of its
+// players and teams in the mode of the game only, and records the history
+func TestRatingsHandler(t *testing.T) {
+	team1 := newTestTeam(t, "Rated Team 1", 3)
+	team2 := newTestTeam(t, "Rated Team 2", 3)
+	rr := doRequest(t, "POST", "/games", url.Values{"name": {"Rated Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}, "mode": {"ranked"}}, false)
+	var g Game
+	json.Unmarshal(rr.Body.Bytes(), &g)
+	if g.Mode != "ranked" {
+		t.Errorf("handler returned unexpected mode: got %v want %v", g.Mode, "ranked")
+	}
+	doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {team1.ID}}, false)
+
+	for _, target := range []string{"/players/" + team1.Players[0].ID, "/teams/" + team1.ID} {
+		rr = doRequest(t, "GET", target+"/ratings", nil, false)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+		var ratings map[string]Rating
+		json.Unmarshal(rr.Body.Bytes(), &ratings)
+		if _, ok := ratings[DefaultMode]; ok || len(ratings) != 1 || ratings["ranked"].Rating <= initialRating {
+			t.Errorf("handler returned unexpected ratings for %s: got %+v", target, ratings)
+		}
+
+		rr = doRequest(t, "GET", target+"/ratings/history?mode=ranked", nil, false)
+		var history []RatingChange
+		json.Unmarshal(rr.Body.Bytes(), &history)
+		if len(history) != 1 || history[0].GameID != g.ID || history[0].Before.Rating != initialRating {
+			t.Errorf("handler returned unexpected history for %s: got %+v", target, history)
+		}
+	}
+
+	rr = doRequest(t, "GET", "/players/"+team2.Players[0].ID+"/ratings", nil, false)
+	var ratings map[string]Rating
+	json.Unmarshal(rr.Body.Bytes(), &ratings)
+	if ratings["ranked"].Rating >= initialRating {
+		t.Errorf("handler returned unexpected rating for a loser: got %+v", ratings["ranked"])
+	}
+
+	rr = doRequest(t, "GET", "/teams/unknown/ratings", nil, false)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import "time"
+
+// job is a background job run at regular intervals by the scheduler
+type job struct {
+	interval time.Duration
+	run      func(now time.Time)
+	next     time.Time
+}
+
+// Init background jobs, run in this order when they are due
+var jobs = []job{
+	// Play the draft turns which timed out
+	{interval: time.Second, run: expireDraftTurns},
+	// Start or cancel the games whose lobby timed out
+	{interval: time.Second, run: expireLobbies},
+	// Start the games scheduled by challenges at their start time
+	{interval: time.Second, run: startScheduledGames},
+	// Match the players waiting in the matchmaking queue
+	{interval: time.Second, run: matchQueue},
+	// Archive the standings of seasons once they are over
+	{interval: time.Minute, run: rolloverSeasons},
+}
+
+// runDueJobs runs the jobs due at the time provided, and schedules their next
+// run
+func runDueJobs(now time.Time) {
+	for i := range jobs {
+		if now.Before(jobs[i].next) {
+			continue
+		}
+		jobs[i].run(now)
+		jobs[i].next = now.Add(jobs[i].interval)
+	}
+}
+
+// runScheduler runs the due background jobs at regular intervals, all at
+// once under the world mutex
+func runScheduler(tick time.Duration) {
+	for now := range time.Tick(tick) {
+		worldMutex.Lock()
+		runDueJobs(now)
+		worldMutex.Unlock()
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestScheduler tests that jobs run when they are due, in order
+func TestScheduler(t *testing.T) {
+	saved := jobs
+	defer func() { jobs = saved }()
+
+	var runs []string
+	jobs = []job{
+		{interval: time.Second, run: func(time.Time) { runs = append(runs, "fast") }},
+		{interval: time.Minute, run: func(time.Time) { runs = append(runs, "slow") }},
+	}
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		runDueJobs(now.Add(time.Duration(i) * time.Second))
+	}
+	if len(runs) != 4 || runs[0] != "fast" || runs[1] != "slow" || runs[2] != "fast" || runs[3] != "fast" {
+		t.Errorf("unexpected job runs: got %v", runs)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Season is a period during which games are rated and ranked separately.
+// Once a season is over, its final standings are archived by the rollover job.
+type Season struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	StartTime time.Time         `json:"startTime"`
+	EndTime   time.Time         `json:"endTime"`
+	Archived  bool              `json:"archived"`
+	Standings []SeasonStandings `json:"standings,omitempty"`
+}
+
+// SeasonStandings is the rating leaderboard of players or teams in a mode at
+// the end of a season
+type SeasonStandings struct {
+	Mode    string             `json:"mode"`
+	Entity  string             `json:"entity"`
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+// seasonAt returns the id of the season in progress at a given time, or an
+// empty string if there is none
+func (w *World) seasonAt(t time.Time) string {
+	for _, s := range w.Seasons {
+		if !t.Before(s.StartTime) && t.Before(s.EndTime) {
+			return s.ID
+		}
+	}
+	return ""
+}
+
+// season returns the season with the id provided, or nil if not found
+func (w *World) season(id string) *Season {
+	for i := range w.Seasons {
+		if w.Seasons[i].ID == id {
+			return &w.Seasons[i]
+		}
+	}
+	return nil
+}
+
+// setSeason creates or replaces a season, keeping its archived standings,
+// and keeps the seasons sorted by start time
+func (w *World) setSeason(s Season) {
+	if old := w.season(s.ID); old != nil {
+		s.Archived, s.Standings = old.Archived, old.Standings
+		*old = s
+	} else {
+		w.Seasons = append(w.Seasons, s)
+	}
+	sort.SliceStable(w.Seasons, func(i, j int) bool {
+		return w.Seasons[i].StartTime.Before(w.Seasons[j].StartTime)
+	})
+}
+
+// archiveSeason archives the final standings of a season
+func (w *World) archiveSeason(id string) {
+	if s := w.season(id); s != nil {
+		s.Standings = w.SeasonStandings(id)
+		s.Archived = true
+	}
+}
+
+// overlappingSeason returns a season other than the one with the id provided
+// overlapping a period, or nil if there is none
+func (w *World) overlappingSeason(id string, start, end time.Time) *Season {
+	for i, s := range w.Seasons {
+		if s.ID != id && start.Before(s.EndTime) && s.StartTime.Before(end) {
+			return &w.Seasons[i]
+		}
+	}
+	return nil
+}
+
+// SeasonStats returns the stats of a player accumulated over his games
+// stopped during a season
+func (w *World) SeasonStats(playerID, seasonID string) Stats {
+	return w.PlayerStats(playerID, seasonID, "")
+}
+
+// SeasonStandings returns the rating leaderboards of players and teams in
+// each mode played during a season
+func (w *World) SeasonStandings(seasonID string) []SeasonStandings {
+	var modes []string
+	played := map[string]bool{}
+	for _, g := range w.StoppedGames() {
+		if g.SeasonID == seasonID && !played[g.Mode] {
+			played[g.Mode] = true
+			modes = append(modes, g.Mode)
+		}
+	}
+
+	standings := []SeasonStandings{}
+	for _, mode := range modes {
+		for _, entity := range []string{EntityPlayers, EntityTeams} {
+			q := LeaderboardQuery{Metric: "rating", Entity: entity, Mode: mode, Season: seasonID}
+			standings = append(standings, SeasonStandings{Mode: mode, Entity: entity, Entries: w.Leaderboard(q, seasonID)})
+		}
+	}
+	return standings
+}
+
+// rolloverSeasons archives the final standings of the seasons over at the
+// time provided, by recording a SeasonArchived event for each of them.
+// Events are stamped when they are recorded, which can be a bit later than
+// the time provided.
+// Ratings do not need to be reset: players and teams start the next season
+// with a soft reset of their rating (see World.Rating).
+// The league seasons of the season end as well, with promotions and
+// relegations.
+func rolloverSeasons(now time.Time) {
+	var over []Season
+	for _, s := range world.Seasons {
+		if !s.Archived && !now.Before(s.EndTime) {
+			over = append(over, s)
+		}
+	}
+	for _, s := range over {
+		recordEvent(Event{Type: SeasonArchived, SeasonID: s.ID})
+		log.Printf("Season %s archived", s.Name)
+		rolloverLeagues(s.ID)
+	}
+}
+
+// seasonCreationHandler creates a season based on the name, start and end
+// parameters (RFC 3339 dates).
+// Seasons cannot overlap.
+func seasonCreationHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Season could not be created because of malformed POST parameters"))
+		return
+	}
+	name := r.Form.Get("name")
+	if name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Season could not be created because of empty POST parameter"))
+		return
+	}
+	start, err := time.Parse(time.RFC3339Nano, r.Form.Get("start"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Season could not be created because of malformed start parameter"))
+		return
+	}
+	end, err := time.Parse(time.RFC3339Nano, r.Form.Get("end"))
+	if err != nil || !end.After(start) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Season could not be created because of malformed end parameter"))
+		return
+	}
+
+	if s := world.overlappingSeason("", start, end); s != nil {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Season could not be created because it overlaps season " + s.Name))
+		return
+	}
+
+	s := Season{ID: uuid.New().String(), Name: name, StartTime: start, EndTime: end}
+	recordEvent(Event{Type: SeasonCreated, Season: &s, Time: time.Now()})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// seasonUpdateHandler updates the name, start or end (RFC 3339 dates) of a
+// season which is not archived yet. Games already stopped keep their season.
+func seasonUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Season could not be updated because of malformed PUT parameters"))
+		return
+	}
+	old := world.season(mux.Vars(r)["id"])
+	if old == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Season not found"))
+		return
+	}
+	if old.Archived {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Season could not be updated because it is archived"))
+		return
+	}
+
+	s := *old
+	s.Standings = nil
+	if name := r.Form.Get("name"); name != "" {
+		s.Name = name
+	}
+	if start := r.Form.Get("start"); start != "" {
+		if s.StartTime, err = time.Parse(time.RFC3339Nano, start); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Season could not be updated because of malformed start parameter"))
+			return
+		}
+	}
+	if end := r.Form.Get("end"); end != "" {
+		if s.EndTime, err = time.Parse(time.RFC3339Nano, end); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Season could not be updated because of malformed end parameter"))
+			return
+		}
+	}
+	if !s.EndTime.After(s.StartTime) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Season could not be updated because it would end before it starts"))
+		return
+	}
+	if o := world.overlappingSeason(s.ID, s.StartTime, s.EndTime); o != nil {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Season could not be updated because it overlaps season " + o.Name))
+		return
+	}
+
+	recordEvent(Event{Type: SeasonUpdated, Season: &s, Time: time.Now()})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// seasonsListingHandler lists all the seasons, without their standings
+func seasonsListingHandler(w http.ResponseWriter, r *http.Request) {
+	list := []Season{}
+	for _, s := range world.Seasons {
+		s.Standings = nil
+		list = append(list, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// seasonRetrievalHandler returns a season with its standings: the archived
+// final standings of a past season, or the current standings of a season in
+// progress.
+// The season is the one in progress if the id route variable is "current".
+func seasonRetrievalHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if id == "current" {
+		id = world.seasonAt(time.Now())
+	}
+
+	s := world.season(id)
+	if s == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Season not found"))
+		return
+	}
+
+	result := *s
+	if !result.Archived {
+		result.Standings = world.SeasonStandings(s.ID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestSeasons tests that games are rated in the season in progress, that the
+// rollover archives the final standings, and that ratings are soft reset in
+// the next season
+func TestSeasons(t *testing.T) {
+	defer endSeasons()
+
+	now := time.Now()
+	createSeason := func(name string, start, end time.Time) (int, Season) {
+		rr := doRequest(t, "POST", "/admin/seasons", url.Values{"name": {name}, "start": {start.Format(time.RFC3339Nano)}, "end": {end.Format(time.RFC3339Nano)}}, true)
+		var s Season
+		json.Unmarshal(rr.Body.Bytes(), &s)
+		return rr.Code, s
+	}
+	status, first := createSeason("Season 1", now, now.Add(time.Hour))
+	if status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if status, _ := createSeason("Overlapping Season", now.Add(time.Minute), now.Add(2*time.Hour)); status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusConflict)
+	}
+
+	team1 := newTestTeam(t, "Season Team 1", 3)
+	team2 := newTestTeam(t, "Season Team 2", 3)
+	rr := doRequest(t, "POST", "/games", url.Values{"name": {"Season Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}, "mode": {"seasons"}}, false)
+	var g Game
+	json.Unmarshal(rr.Body.Bytes(), &g)
+	doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, team1.Players[0].ID), url.Values{"name": {"nbKills"}}, false)
+	rr = doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {team1.ID}}, false)
+	json.Unmarshal(rr.Body.Bytes(), &g)
+	if g.SeasonID != first.ID {
+		t.Errorf("handler returned unexpected season: got %v want %v", g.SeasonID, first.ID)
+	}
+	rating := world.Rating("seasons", first.ID, team1.Players[0].ID)
+
+	rr = doRequest(t, "GET", fmt.Sprintf("/players/%s?season=%s", team1.Players[0].ID, first.ID), nil, false)
+	var p Player
+	json.Unmarshal(rr.Body.Bytes(), &p)
+	if p.Stats.NbKills != 1 || p.Stats.TotalNbGamesPlayed != 1 {
+		t.Errorf("handler returned unexpected season stats: got %+v", p.Stats)
+	}
+
+	// End the first season and start the next one
+	end := time.Now()
+	rr = doRequest(t, "PUT", "/admin/seasons/"+first.ID, url.Values{"end": {end.Format(time.RFC3339Nano)}}, true)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	_, second := createSeason("Season 2", end, end.Add(time.Hour))
+	rolloverSeasons(end)
+
+	rr = doRequest(t, "GET", "/seasons/"+first.ID, nil, false)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	var s Season
+	json.Unmarshal(rr.Body.Bytes(), &s)
+	if !s.Archived || len(s.Standings) != 2 || s.Standings[0].Mode != "seasons" || len(s.Standings[0].Entries) != 6 ||
+		s.Standings[1].Entries[0].ID != team1.ID {
+		t.Errorf("handler returned unexpected archived season: got %+v", s)
+	}
+
+	rr = doRequest(t, "GET", "/seasons/current", nil, false)
+	json.Unmarshal(rr.Body.Bytes(), &s)
+	if s.ID != second.ID || s.Archived {
+		t.Errorf("handler returned unexpected current season: got %+v", s)
+	}
+
+	rr = doRequest(t, "GET", fmt.Sprintf("/players/%s/ratings?season=%s", team1.Players[0].ID, second.ID), nil, false)
+	var ratings map[string]Rating
+	json.Unmarshal(rr.Body.Bytes(), &ratings)
+	if r := ratings["seasons"]; r.Rating <= initialRating || r.Rating >= rating.Rating || r.Deviation <= rating.Deviation || r.NbGames != 0 {
+		t.Errorf("handler returned unexpected soft reset rating: got %+v from %+v", r, rating)
+	}
+
+	// Ratings as of the first season default to the first season
+	asOf := url.QueryEscape(g.StopTime.Format(time.RFC3339Nano))
+	rr = doRequest(t, "GET", fmt.Sprintf("/players/%s/ratings?as_of=%s", team1.Players[0].ID, asOf), nil, false)
+	ratings = nil
+	json.Unmarshal(rr.Body.Bytes(), &ratings)
+	if r := ratings["seasons"]; r != rating {
+		t.Errorf("handler returned unexpected rating as of the first season: got %+v want %+v", r, rating)
+	}
+}
+
+// TestSeasonGap tests that ratings are carried across the games played
+// between seasons, and that seasons are rebuilt from the event log
+func TestSeasonGap(t *testing.T) {
+	defer endSeasons()
+
+	createSeason := func(name string, start time.Time) Season {
+		rr := doRequest(t, "POST", "/admin/seasons", url.Values{"name": {name}, "start": {start.Format(time.RFC3339Nano)}, "end": {start.Add(time.Hour).Format(time.RFC3339Nano)}}, true)
+		var s Season
+		json.Unmarshal(rr.Body.Bytes(), &s)
+		return s
+	}
+	team1 := newTestTeam(t, "Gap Team 1", 3)
+	team2 := newTestTeam(t, "Gap Team 2", 3)
+	play := func() Game {
+		rr := doRequest(t, "POST", "/games", url.Values{"name": {"Gap Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}, "mode": {"gap"}}, false)
+		var g Game
+		json.Unmarshal(rr.Body.Bytes(), &g)
+		rr = doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {team1.ID}}, false)
+		json.Unmarshal(rr.Body.Bytes(), &g)
+		return g
+	}
+
+	first := createSeason("Gap Season 1", time.Now())
+	inSeason := play()
+	doRequest(t, "PUT", "/admin/seasons/"+first.ID, url.Values{"end": {time.Now().Format(time.RFC3339Nano)}}, true)
+	betweenSeasons := play()
+	if inSeason.SeasonID != first.ID || betweenSeasons.SeasonID != "" {
+		t.Fatalf("unexpected seasons of games: got %q and %q", inSeason.SeasonID, betweenSeasons.SeasonID)
+	}
+
+	id := team1.Players[0].ID
+	history := world.RatingHistory(id, "gap", "")
+	if len(history) != 2 || history[1].Before != history[0].After {
+		t.Fatalf("rating not carried between seasons: got %+v", history)
+	}
+
+	second := createSeason("Gap Season 2", time.Now())
+	if r := world.Rating("gap", second.ID, id); r != history[1].After.softReset() {
+		t.Errorf("unexpected soft reset rating: got %+v from %+v", r, history[1].After)
+	}
+
+	rebuilt := replay(eventLog)
+	if len(rebuilt.Seasons) != len(world.Seasons) || rebuilt.Game(inSeason.ID).SeasonID != first.ID ||
+		rebuilt.season(first.ID).EndTime != world.season(first.ID).EndTime {
+		t.Errorf("unexpected rebuilt seasons: got %+v", rebuilt.Seasons)
+	}
+}
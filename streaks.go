@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Streaks represents the sequences of games of a player: the current and best
+// numbers of games won in a row, of games with a first hit kill in a row, and
+// of days played in a row.
+// Streaks are kept across seasons.
+type Streaks struct {
+	Wins                  int    `json:"wins"`
+	BestWins              int    `json:"bestWins"`
+	FirstHitKillGames     int    `json:"firstHitKillGames"`
+	BestFirstHitKillGames int    `json:"bestFirstHitKillGames"`
+	Days                  int    `json:"days"`
+	BestDays              int    `json:"bestDays"`
+	LastDayPlayed         string `json:"lastDayPlayed,omitempty"`
+}
+
+// dayLayout is the layout of the days played (UTC dates)
+const dayLayout = "2006-01-02"
+
+// AddGame updates the streaks with the result of a game stopped at the time
+// provided.
+// Games must be added in the order they were stopped.
+func (s *Streaks) AddGame(won, firstHitKill bool, stopTime time.Time) {
+	if won {
+		s.Wins++
+	} else {
+		s.Wins = 0
+	}
+	if firstHitKill {
+		s.FirstHitKillGames++
+	} else {
+		s.FirstHitKillGames = 0
+	}
+
+	day := stopTime.UTC().Format(dayLayout)
+	if day != s.LastDayPlayed {
+		last, err := time.Parse(dayLayout, s.LastDayPlayed)
+		if err == nil && last.AddDate(0, 0, 1).Format(dayLayout) == day {
+			s.Days++
+		} else {
+			s.Days = 1
+		}
+		s.LastDayPlayed = day
+	}
+
+	if s.Wins > s.BestWins {
+		s.BestWins = s.Wins
+	}
+	if s.FirstHitKillGames > s.BestFirstHitKillGames {
+		s.BestFirstHitKillGames = s.FirstHitKillGames
+	}
+	if s.Days > s.BestDays {
+		s.BestDays = s.Days
+	}
+}
+
+// UpdateStreaks calculates the streaks of a player from all his stopped games.
+// Streaks are updated game after game when games stop (see
+// indexStoppedGame), and only calculated from scratch when a stat of a past
+// game is corrected.
+func (w *World) UpdateStreaks(playerID string) {
+	var s Streaks
+	for _, g := range w.PlayerGames(playerID) {
+		p := g.Player(playerID)
+		s.AddGame(p.Stats.TotalNbWins > 0, p.Stats.NbFirstHitKills > 0, g.StopTime)
+	}
+	if w.Streaks == nil {
+		w.Streaks = map[string]Streaks{}
+	}
+	w.Streaks[playerID] = s
+}
+
+// lifetimeVariables returns the variables lifetime achievement conditions
+// can use: the lifetime stats and derived stats (see statsVariables), the
+// streaks of the player, and his experience and level
+func lifetimeVariables(stats Stats, s Streaks, pr Progression) map[string]float64 {
+	vars := statsVariables(stats)
+	vars["xp"] = float64(pr.XP)
+	vars["level"] = float64(pr.Level)
+	vars["winStreak"] = float64(s.Wins)
+	vars["bestWinStreak"] = float64(s.BestWins)
+	vars["firstHitKillStreak"] = float64(s.FirstHitKillGames)
+	vars["bestFirstHitKillStreak"] = float64(s.BestFirstHitKillGames)
+	vars["dayStreak"] = float64(s.Days)
+	vars["bestDayStreak"] = float64(s.BestDays)
+	return vars
+}
+
+// LifetimeVariables returns the lifetime variables of a player.
+// The stats of a running game can be provided to be added to the lifetime stats.
+func (w *World) LifetimeVariables(playerID string, running Stats) map[string]float64 {
+	stats := w.LifetimeStats(playerID)
+	stats.Add(running)
+	return lifetimeVariables(stats, w.Streaks[playerID], w.Progression[playerID])
+}
+
+// LifetimeVariablesFrom returns the stopped games of a player from the game
+// provided on, with the lifetime variables he had once each of them was over:
+// the stats and streaks accumulated until this game.
+// Experience and level are not tracked game by game, so the current ones are
+// used.
+func (w *World) LifetimeVariablesFrom(playerID string, g *Game) ([]*Game, []map[string]float64) {
+	games := w.PlayerGames(playerID)
+	pr := w.Progression[playerID]
+	// The last game stopped is the common case: the lifetime stats and
+	// streaks are the ones accumulated until it
+	if len(games) > 0 && games[len(games)-1] == g {
+		return games[len(games)-1:], []map[string]float64{w.LifetimeVariables(playerID, Stats{})}
+	}
+
+	var stats Stats
+	var streaks Streaks
+	var vars []map[string]float64
+	from := len(games)
+	for i, sg := range games {
+		p := sg.Player(playerID)
+		stats.Add(p.Stats)
+		streaks.AddGame(p.Stats.TotalNbWins > 0, p.Stats.NbFirstHitKills > 0, sg.StopTime)
+		if sg == g {
+			from = i
+		}
+		if i >= from {
+			vars = append(vars, lifetimeVariables(stats, streaks, pr))
+		}
+	}
+	return games[from:], vars
+}
+
+// streaksHandler returns the streaks of a player
+func streaksHandler(w http.ResponseWriter, r *http.Request) {
+	wd, err := requestedWorld(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Streaks could not be retrieved because of malformed as_of parameter"))
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	if wd.Player(vars["playerId"]) == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Player not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wd.Streaks[vars["playerId"]])
+}
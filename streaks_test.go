@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestStreaksAddGame tests that streaks are extended or broken by games
+func TestStreaksAddGame(t *testing.T) {
+	day := time.Date(2019, 12, 30, 22, 0, 0, 0, time.UTC)
+	var s Streaks
+	s.AddGame(true, true, day)
+	s.AddGame(true, false, day.Add(time.Hour))
+	s.AddGame(true, true, day.AddDate(0, 0, 1))
+	s.AddGame(false, true, day.AddDate(0, 0, 2))
+	s.AddGame(true, true, day.AddDate(0, 0, 4))
+
+	want := Streaks{
+		Wins:                  1,
+		BestWins:              3,
+		FirstHitKillGames:     3,
+		BestFirstHitKillGames: 3,
+		Days:                  1,
+		BestDays:              3,
+		LastDayPlayed:         "2020-01-03",
+	}
+	if s != want {
+		t.Errorf("unexpected streaks: got %+v want %+v", s, want)
+	}
+}
+
+// TestStreaksHandler tests that winning 3 games in a row is tracked and
+// unlocks the first tier of the win streak achievement
+func TestStreaksHandler(t *testing.T) {
+	team1 := newTestTeam(t, "Winning Team", 3)
+	team2 := newTestTeam(t, "Losing Team", 3)
+	player := team1.Players[0]
+
+	for i := 0; i < 3; i++ {
+		rr := doRequest(t, "POST", "/games", url.Values{"name": {fmt.Sprintf("Winning Game %d", i)}, "team1Id": {team1.ID}, "team2Id": {team2.ID}}, false)
+		var g Game
+		json.Unmarshal(rr.Body.Bytes(), &g)
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {team1.ID}}, false)
+	}
+
+	rr := doRequest(t, "GET", fmt.Sprintf("/players/%s/streaks", player.ID), nil, false)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	var s Streaks
+	json.Unmarshal(rr.Body.Bytes(), &s)
+	if s.Wins != 3 || s.BestWins != 3 || s.FirstHitKillGames != 0 {
+		t.Errorf("handler returned unexpected streaks: got %+v", s)
+	}
+
+	rr = doRequest(t, "GET", fmt.Sprintf("/players/%s/achievements", player.ID), nil, false)
+	var unlocks []Unlock
+	json.Unmarshal(rr.Body.Bytes(), &unlocks)
+	if len(unlocks) != 1 || unlocks[0].AchievementID != "onFire:bronze" {
+		t.Errorf("handler returned unexpected unlocks: got %+v", unlocks)
+	}
+}
+
+// TestCorrectedStreaks tests that the lifetime stats and streaks kept up to
+// date game after game follow stat corrections of past games, and match the
+// ones calculated again from the event log
+func TestCorrectedStreaks(t *testing.T) {
+	team1 := newTestTeam(t, "Corrected Streaks Team 1", 3)
+	team2 := newTestTeam(t, "Corrected Streaks Team 2", 3)
+	player := team1.Players[0]
+	var gameIDs []string
+	for i := 0; i < 2; i++ {
+		rr := doRequest(t, "POST", "/games", url.Values{"name": {"Corrected Streaks Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}}, false)
+		var g Game
+		json.Unmarshal(rr.Body.Bytes(), &g)
+		gameIDs = append(gameIDs, g.ID)
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, player.ID), url.Values{"name": {"nbFirstHitKills"}}, false)
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {team1.ID}}, false)
+	}
+
+	params := url.Values{"name": {"nbFirstHitKills"}, "action": {"set"}, "value": {"0"}, "reason": {"replay review"}}
+	doRequest(t, "PUT", fmt.Sprintf("/admin/games/%s/players/%s/stats", gameIDs[0], player.ID), params, true)
+
+	s, lifetime := world.Streaks[player.ID], world.LifetimeStats(player.ID)
+	if s.FirstHitKillGames != 1 || s.BestFirstHitKillGames != 1 || s.Wins != 2 || lifetime.NbFirstHitKills != 1 || lifetime.TotalNbGamesPlayed != 2 {
+		t.Errorf("unexpected streaks and lifetime stats after a correction: got %+v %+v", s, lifetime)
+	}
+	rebuilt := replay(eventLog)
+	if rebuilt.Streaks[player.ID] != s || rebuilt.LifetimeStats(player.ID) != lifetime {
+		t.Errorf("rebuilt streaks and lifetime stats differ: got %+v %+v", rebuilt.Streaks[player.ID], rebuilt.LifetimeStats(player.ID))
+	}
+}
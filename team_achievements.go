@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TeamUnlock records when, and in which game, a team earned an achievement
+type TeamUnlock struct {
+	TeamID        string    `json:"teamId"`
+	AchievementID string    `json:"achievementId"`
+	UnlockedAt    time.Time `json:"unlockedAt"`
+	GameID        string    `json:"gameId"`
+	Seq           int       `json:"seq"`
+}
+
+// teamVariables returns the variables team achievement conditions can use:
+// the stats of the team members in the game added together (named after
+// their json names), the results of the team and some stats of the opponent.
+// results are the results of the stopped games of the team until this game,
+// in the order they were stopped.
+func teamVariables(team, opponent Team, results []bool) map[string]float64 {
+	var stats, opponentStats Stats
+	nbMembersWithKill := 0
+	for _, p := range team.Players {
+		stats.Add(p.Stats)
+		if p.Stats.NbKills > 0 {
+			nbMembersWithKill++
+		}
+	}
+	for _, p := range opponent.Players {
+		opponentStats.Add(p.Stats)
+	}
+
+	vars := statsVariables(stats)
+	vars["nbMembers"] = float64(len(team.Players))
+	vars["nbMembersWithKill"] = float64(nbMembersWithKill)
+	vars["opponentNbKills"] = float64(opponentStats.NbKills)
+	vars["opponentTotalDamage"] = float64(opponentStats.DamageDone + opponentStats.SpellDamageDone)
+
+	// Results of the team: the last one is the result of this game
+	vars["won"] = 0
+	if len(results) > 0 {
+		vars["won"] = boolToFloat(results[len(results)-1])
+	}
+	vars["winStreak"] = 0
+	for i := len(results) - 1; i >= 0 && results[i]; i-- {
+		vars["winStreak"]++
+	}
+	vars["teamNbGamesPlayed"] = float64(len(results))
+	vars["teamNbWins"] = 0
+	for _, won := range results {
+		vars["teamNbWins"] += boolToFloat(won)
+	}
+	return vars
+}
+
+// TeamResults returns the results (won or not) of the stopped games of a
+// team until the game provided included, in the order they were stopped
+func (w *World) TeamResults(teamID string, until *Game) []bool {
+	var results []bool
+	for _, g := range w.TeamGames(teamID) {
+		results = append(results, g.WinnerID == teamID)
+		if g == until {
+			break
+		}
+	}
+	return results
+}
+
+// stoppedBefore checks whether a team stopped a game before another one
+func (w *World) stoppedBefore(teamID, gameID, otherID string) bool {
+	for _, g := range w.TeamGames(teamID) {
+		switch g.ID {
+		case gameID:
+			return true
+		case otherID:
+			return false
+		}
+	}
+	return false
+}
+
+// UpdateTeamAchievements calculates the achievements of both teams of a
+// stopped game, with their results until this game, and records the ones
+// they earned for the first time. Ephemeral teams earn no achievements.
+// As for players, a stat correction moves an unlock to the corrected game if
+// it is now reached there before the game it was unlocked in, and removes it
+// if it is not reached anymore in the game where it was unlocked.
+func (w *World) UpdateTeamAchievements(g *Game, e Event) {
+	for _, teams := range [][2]*Team{{&g.Team1, &g.Team2}, {&g.Team2, &g.Team1}} {
+		team, opponent := teams[0], teams[1]
+		if team.Ephemeral {
+			continue
+		}
+		team.Achievements = w.Rules.Evaluate(ScopeTeam, teamVariables(*team, *opponent, w.TeamResults(team.ID, g)))
+
+		for _, r := range w.Rules.Rules {
+			for _, id := range r.AchievementIDs() {
+				reached, ok := team.Achievements[id]
+				if !ok {
+					continue
+				}
+				i := w.teamUnlockIndex(team.ID, id)
+				if reached && i >= 0 && w.TeamUnlocks[i].GameID != g.ID && w.stoppedBefore(team.ID, g.ID, w.TeamUnlocks[i].GameID) {
+					w.TeamUnlocks = append(w.TeamUnlocks[:i], w.TeamUnlocks[i+1:]...)
+					i = -1
+				}
+				switch {
+				case reached && i < 0:
+					w.TeamUnlocks = append(w.TeamUnlocks, TeamUnlock{
+						TeamID:        team.ID,
+						AchievementID: id,
+						UnlockedAt:    e.Time,
+						GameID:        g.ID,
+						Seq:           e.Seq,
+					})
+				case !reached && i >= 0 && w.TeamUnlocks[i].GameID == g.ID:
+					w.TeamUnlocks = append(w.TeamUnlocks[:i], w.TeamUnlocks[i+1:]...)
+				}
+			}
+		}
+	}
+}
+
+// teamUnlockIndex returns the index of the unlock of an achievement by a team,
+// or -1 if the team has not earned this achievement yet
+func (w *World) teamUnlockIndex(teamID, achievementID string) int {
+	for i, u := range w.TeamUnlocks {
+		if u.TeamID == teamID && u.AchievementID == achievementID {
+			return i
+		}
+	}
+	return -1
+}
+
+// teamUnlocksHandler lists chronologically all the achievements a team has
+// unlocked, with the game in which it unlocked them
+func teamUnlocksHandler(w http.ResponseWriter, r *http.Request) {
+	wd, err := requestedWorld(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Achievements could not be listed because of malformed as_of parameter"))
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	unlocks := []TeamUnlock{}
+	for _, u := range wd.TeamUnlocks {
+		if u.TeamID == vars["id"] {
+			unlocks = append(unlocks, u)
+		}
+	}
+	// Deleted teams keep their achievements
+	if len(unlocks) == 0 && wd.Team(vars["id"]) == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Team not found"))
+		return
+	}
+	sort.SliceStable(unlocks, func(i, j int) bool {
+		return unlocks[i].UnlockedAt.Before(unlocks[j].UnlockedAt)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(unlocks)
+}
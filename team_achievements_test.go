@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// TestTeamUnlocksHandler tests that a team earns the flawless victory and
+// teamwork achievements, and the win streak achievement after 10 wins in a row
+func TestTeamUnlocksHandler(t *testing.T) {
+	team1 := newTestTeam(t, "Streaking Team 1", 3)
+	team2 := newTestTeam(t, "Streaking Team 2", 3)
+
+	var firstGameID string
+	for i := 0; i < 10; i++ {
+		rr := doRequest(t, "POST", "/games", url.Values{"name": {fmt.Sprintf("Streak Game %d", i)}, "team1Id": {team1.ID}, "team2Id": {team2.ID}}, false)
+		var g Game
+		json.Unmarshal(rr.Body.Bytes(), &g)
+
+		// Every member of team 1 gets a kill in the first game
+		if i == 0 {
+			firstGameID = g.ID
+			for _, p := range team1.Players {
+				doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, p.ID), url.Values{"name": {"nbKills"}}, false)
+			}
+		}
+		// Team 2 gets a kill in all the other games
+		if i > 0 {
+			doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, team2.Players[0].ID), url.Values{"name": {"nbKills"}}, false)
+		}
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {team1.ID}}, false)
+
+		if i == 8 {
+			rr = doRequest(t, "GET", fmt.Sprintf("/teams/%s/achievements", team1.ID), nil, false)
+			var unlocks []TeamUnlock
+			json.Unmarshal(rr.Body.Bytes(), &unlocks)
+			for _, u := range unlocks {
+				if u.AchievementID == "unstoppable" {
+					t.Errorf("team unlocked the win streak achievement after %d wins", i+1)
+				}
+			}
+		}
+	}
+
+	rr := doRequest(t, "GET", fmt.Sprintf("/teams/%s/achievements", team1.ID), nil, false)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	var unlocks []TeamUnlock
+	json.Unmarshal(rr.Body.Bytes(), &unlocks)
+	want := []string{"flawlessVictory", "teamwork", "unstoppable"}
+	if len(unlocks) != len(want) {
+		t.Fatalf("handler returned unexpected unlocks: got %+v", unlocks)
+	}
+	for i, u := range unlocks {
+		if u.AchievementID != want[i] {
+			t.Errorf("handler returned unexpected unlock at position %d: got %v want %v",
+				i, u.AchievementID, want[i])
+		}
+	}
+	if unlocks[0].GameID != firstGameID {
+		t.Errorf("handler returned unexpected game for the first unlock: got %v want %v",
+			unlocks[0].GameID, firstGameID)
+	}
+
+	// The losing team has not earned anything
+	rr = doRequest(t, "GET", fmt.Sprintf("/teams/%s/achievements", team2.ID), nil, false)
+	json.Unmarshal(rr.Body.Bytes(), &unlocks)
+	if len(unlocks) != 0 {
+		t.Errorf("handler returned unexpected unlocks: got %+v", unlocks)
+	}
+}
+
+// TestCorrectedTeamAchievements tests that the team achievements of a
+// corrected game are evaluated with the result of this game, not with the
+// result of the last game of the team
+func TestCorrectedTeamAchievements(t *testing.T) {
+	team1 := newTestTeam(t, "Corrected Team 1", 3)
+	team2 := newTestTeam(t, "Corrected Team 2", 3)
+
+	var gameIDs []string
+	for _, winnerID := range []string{team1.ID, team2.ID} {
+		rr := doRequest(t, "POST", "/games", url.Values{"name": {"Corrected Team Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}}, false)
+		var g Game
+		json.Unmarshal(rr.Body.Bytes(), &g)
+		gameIDs = append(gameIDs, g.ID)
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, team2.Players[0].ID), url.Values{"name": {"nbKills"}}, false)
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {winnerID}}, false)
+	}
+
+	params := url.Values{"name": {"nbKills"}, "action": {"set"}, "value": {"0"}, "reason": {"no kill"}}
+	doRequest(t, "PUT", fmt.Sprintf("/admin/games/%s/players/%s/stats", gameIDs[0], team2.Players[0].ID), params, true)
+
+	if a := world.Game(gameIDs[0]).Team1.Achievements; !a["flawlessVictory"] {
+		t.Errorf("corrected game should be a flawless victory: got %+v", a)
+	}
+	rr := doRequest(t, "GET", fmt.Sprintf("/teams/%s/achievements", team1.ID), nil, false)
+	var unlocks []TeamUnlock
+	json.Unmarshal(rr.Body.Bytes(), &unlocks)
+	found := false
+	for _, u := range unlocks {
+		found = found || (u.AchievementID == "flawlessVictory" && u.GameID == gameIDs[0])
+	}
+	if !found {
+		t.Errorf("flawless victory should be unlocked in the corrected game: got %+v", unlocks)
+	}
+}
@@ -0,0 +1,734 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Tournament formats
+const (
+	SingleElimination = "single_elimination"
+	DoubleElimination = "double_elimination"
+	RoundRobin        = "round_robin"
+	Swiss             = "swiss"
+)
+
+// Tournament statuses
+const (
+	TournamentRegistration = "registration"
+	TournamentRunning      = "running"
+	TournamentFinished     = "finished"
+)
+
+// Brackets of a double elimination tournament
+const (
+	WinnersBracket = "winners"
+	LosersBracket  = "losers"
+	GrandFinal     = "grand_final"
+)
+
+// Ids of the grand final of a double elimination tournament, and of its reset
+// match, played when the team coming from the losers bracket wins the grand
+// final so that both teams have lost once
+const (
+	grandFinalID      = "GF"
+	grandFinalResetID = "GF2"
+)
+
+// byeTeam fills the slot of a match when no team will play in it: the other
+// team advances without playing
+const byeTeam = "bye"
+
+// TournamentTeam is a team registered in a tournament with its seed
+// (1 being the best seed)
+type TournamentTeam struct {
+	TeamID string `json:"teamId"`
+	Seed   int    `json:"seed"`
+}
+
+// Match is a match of a tournament.
+// The winner (and the loser in double elimination) of a match goes to the
+// slot (1 or 2) of the next match. The game of a match is created as soon as
+// both its teams are known.
+type Match struct {
+	ID           string `json:"id"`
+	Bracket      string `json:"bracket,omitempty"`
+	Round        int    `json:"round"`
+	Team1ID      string `json:"team1Id,omitempty"`
+	Team2ID      string `json:"team2Id,omitempty"`
+	GameID       string `json:"gameId,omitempty"`
+	WinnerID     string `json:"winnerId,omitempty"`
+	LoserID      string `json:"loserId,omitempty"`
+	NextMatchID  string `json:"nextMatchId,omitempty"`
+	NextSlot     int    `json:"nextSlot,omitempty"`
+	LoserMatchID string `json:"loserMatchId,omitempty"`
+	LoserSlot    int    `json:"loserSlot,omitempty"`
+}
+
+// Tournament represents a tournament between registered teams.
+// Round robin and Swiss tournaments are played round after round, the games
+// of a round being created once all the games of the previous round are over.
+// All the teams have the same number of players (TeamSize), set at creation
+// or by the first team registered.
+// Games are played on the map of the tournament, if any.
+type Tournament struct {
+	ID       string           `json:"id"`
+	Name     string           `json:"name"`
+	Format   string           `json:"format"`
+	Mode     string           `json:"mode"`
+	MapID    string           `json:"mapId,omitempty"`
+	Status   string           `json:"status"`
+	TeamSize int              `json:"teamSize,omitempty"`
+	NbRounds int              `json:"nbRounds,omitempty"`
+	Teams    []TournamentTeam `json:"teams"`
+	Matches  []Match          `json:"matches"`
+	WinnerID string           `json:"winnerId,omitempty"`
+}
+
+// TournamentStanding is the record of a team in a round robin or Swiss
+// tournament
+type TournamentStanding struct {
+	TeamID string `json:"teamId"`
+	Seed   int    `json:"seed"`
+	Played int    `json:"played"`
+	Wins   int    `json:"wins"`
+	Losses int    `json:"losses"`
+	Points int    `json:"points"`
+}
+
+// Init tournaments.
+// As the draft catalog, tournaments are not part of the event log: only the games they
+// create are.
+var tournaments []Tournament
+
+// tournament returns the tournament with the id provided, or nil if not found
+func tournament(id string) *Tournament {
+	for i := range tournaments {
+		if tournaments[i].ID == id {
+			return &tournaments[i]
+		}
+	}
+	return nil
+}
+
+// match returns the match with the id provided, or nil if not found
+func (t *Tournament) match(id string) *Match {
+	for i := range t.Matches {
+		if t.Matches[i].ID == id {
+			return &t.Matches[i]
+		}
+	}
+	return nil
+}
+
+// eligible checks that a team can play in the tournament: it must exist, not
+// be the roster of a single game, and have the number of players of the
+// teams of the tournament
+func (t *Tournament) eligible(teamID string) error {
+	team := world.Team(teamID)
+	if team == nil {
+		return fmt.Errorf("team %s does not exist", teamID)
+	}
+	if team.Ephemeral {
+		return fmt.Errorf("team %s is the roster of a single game", team.Name)
+	}
+	size := len(team.Players)
+	if size < 3 || size > 5 {
+		return fmt.Errorf("team %s has %d players instead of 3 to 5", team.Name, size)
+	}
+	if t.TeamSize != 0 && size != t.TeamSize {
+		return fmt.Errorf("team %s has %d players instead of %d", team.Name, size, t.TeamSize)
+	}
+	return nil
+}
+
+// seedOrder returns the seeds in the order of the first round of an
+// elimination bracket of the size provided (a power of 2), so that the best
+// seeds meet as late as possible: 1, 8, 4, 5, 2, 7, 3, 6 for 8 teams
+func seedOrder(size int) []int {
+	order := []int{1}
+	for n := 2; n <= size; n *= 2 {
+		var next []int
+		for _, s := range order {
+			next = append(next, s, n+1-s)
+		}
+		order = next
+	}
+	return order
+}
+
+// Start seeds the teams and generates the matches of the first round.
+// Teams without a seed are seeded after the others by rating in the mode of
+// the tournament.
+// It returns an error, without starting the tournament, if a registered team
+// cannot play anymore.
+func (t *Tournament) Start() error {
+	for _, team := range t.Teams {
+		if err := t.eligible(team.TeamID); err != nil {
+			return err
+		}
+	}
+
+	seasonID := world.seasonAt(time.Now())
+	sort.SliceStable(t.Teams, func(i, j int) bool {
+		si, sj := t.Teams[i].Seed, t.Teams[j].Seed
+		if si > 0 && sj > 0 {
+			return si < sj
+		}
+		if si > 0 || sj > 0 {
+			return si > 0
+		}
+		return world.Rating(t.Mode, seasonID, t.Teams[i].TeamID).Rating > world.Rating(t.Mode, seasonID, t.Teams[j].TeamID).Rating
+	})
+	for i := range t.Teams {
+		t.Teams[i].Seed = i + 1
+	}
+	t.Status = TournamentRunning
+
+	switch t.Format {
+	case SingleElimination, DoubleElimination:
+		t.generateElimination()
+	case RoundRobin:
+		t.generateRoundRobin()
+		t.startRound(1)
+	case Swiss:
+		if t.NbRounds == 0 {
+			t.NbRounds = int(math.Ceil(math.Log2(float64(len(t.Teams)))))
+		}
+		t.pairSwissRound(1)
+		t.startRound(1)
+	}
+	return nil
+}
+
+// generateElimination generates the matches of an elimination bracket and
+// places the seeded teams in the first round. Missing teams are byes.
+func (t *Tournament) generateElimination() {
+	size := 1
+	nbRounds := 0
+	for size < len(t.Teams) {
+		size *= 2
+		nbRounds++
+	}
+	t.NbRounds = nbRounds
+
+	bracket := ""
+	if t.Format == DoubleElimination {
+		bracket = WinnersBracket
+	}
+	id := func(prefix string, round, i int) string {
+		return fmt.Sprintf("%s%d-%d", prefix, round, i+1)
+	}
+
+	// Winners (or single) bracket
+	for round := 1; round <= nbRounds; round++ {
+		for i := 0; i < size>>uint(round); i++ {
+			m := Match{ID: id("W", round, i), Bracket: bracket, Round: round}
+			if round < nbRounds {
+				m.NextMatchID, m.NextSlot = id("W", round+1, i/2), i%2+1
+			}
+			t.Matches = append(t.Matches, m)
+		}
+	}
+
+	if t.Format == DoubleElimination {
+		// The losers bracket has 2 rounds for each winners bracket round
+		// after the first one: losers of the winners bracket join in even
+		// rounds, and the remaining teams play each other in odd rounds
+		for k := 1; k < nbRounds; k++ {
+			n := size >> uint(k+1)
+			for i := 0; i < n; i++ {
+				odd := Match{ID: id("L", 2*k-1, i), Bracket: LosersBracket, Round: 2*k - 1, NextMatchID: id("L", 2*k, i), NextSlot: 1}
+				even := Match{ID: id("L", 2*k, i), Bracket: LosersBracket, Round: 2 * k}
+				if k < nbRounds-1 {
+					even.NextMatchID, even.NextSlot = id("L", 2*k+1, i/2), i%2+1
+				} else {
+					even.NextMatchID, even.NextSlot = grandFinalID, 2
+				}
+				t.Matches = append(t.Matches, odd, even)
+			}
+		}
+		t.Matches = append(t.Matches, Match{ID: grandFinalID, Bracket: GrandFinal, Round: 2*nbRounds - 1})
+		t.match(id("W", nbRounds, 0)).NextMatchID = grandFinalID
+		t.match(id("W", nbRounds, 0)).NextSlot = 1
+
+		for i := 0; i < size/2; i++ {
+			m := t.match(id("W", 1, i))
+			m.LoserMatchID, m.LoserSlot = id("L", 1, i/2), i%2+1
+		}
+		for round := 2; round <= nbRounds; round++ {
+			n := size >> uint(round)
+			for i := 0; i < n; i++ {
+				// Losers are sent to the other side of the bracket to
+				// avoid early rematches
+				m := t.match(id("W", round, i))
+				m.LoserMatchID, m.LoserSlot = id("L", 2*(round-1), n-1-i), 2
+			}
+		}
+	}
+
+	for i, seed := range seedOrder(size) {
+		teamID := byeTeam
+		if seed <= len(t.Teams) {
+			teamID = t.Teams[seed-1].TeamID
+		}
+		t.setSlot(id("W", 1, i/2), i%2+1, teamID)
+	}
+}
+
+// roundRobin schedules the rounds of a round robin between the teams
+// provided with the circle method: every team plays every other team once.
+// With an odd number of teams, one team rests at each round.
+func roundRobin(ids []string) [][][2]string {
+	ids = append([]string{}, ids...)
+	if len(ids)%2 != 0 {
+		ids = append(ids, "")
+	}
+	n := len(ids)
+	var rounds [][][2]string
+	for round := 1; round < n; round++ {
+		var pairs [][2]string
+		for i := 0; i < n/2; i++ {
+			if ids[i] != "" && ids[n-1-i] != "" {
+				pairs = append(pairs, [2]string{ids[i], ids[n-1-i]})
+			}
+		}
+		rounds = append(rounds, pairs)
+		// Rotate all the teams but the first one
+		ids = append([]string{ids[0], ids[n-1]}, ids[1:n-1]...)
+	}
+	return rounds
+}
+
+// generateRoundRobin generates the matches of all the rounds of a round robin
+// tournament
+func (t *Tournament) generateRoundRobin() {
+	var ids []string
+	for _, team := range t.Teams {
+		ids = append(ids, team.TeamID)
+	}
+	rounds := roundRobin(ids)
+	t.NbRounds = len(rounds)
+	for i, pairs := range rounds {
+		for j, pair := range pairs {
+			t.Matches = append(t.Matches, Match{ID: fmt.Sprintf("R%d-%d", i+1, j+1), Round: i + 1, Team1ID: pair[0], Team2ID: pair[1]})
+		}
+	}
+}
+
+// pairSwissRound generates the matches of a round of a Swiss tournament:
+// teams are ranked by wins then seed, and paired in this order. With an odd
+// number of teams, the lowest ranked team which has not had a bye yet gets
+// one (a win without playing).
+func (t *Tournament) pairSwissRound(round int) {
+	standings := t.Standings()
+	played := map[string]bool{}
+	hadBye := map[string]bool{}
+	for _, m := range t.Matches {
+		played[m.Team1ID+"|"+m.Team2ID] = true
+		played[m.Team2ID+"|"+m.Team1ID] = true
+		if m.Team2ID == byeTeam {
+			hadBye[m.Team1ID] = true
+		}
+	}
+
+	var ids []string
+	for _, s := range standings {
+		ids = append(ids, s.TeamID)
+	}
+	var matches []Match
+	if len(ids)%2 != 0 {
+		bye := len(ids) - 1
+		for i := len(ids) - 1; i >= 0; i-- {
+			if !hadBye[ids[i]] {
+				bye = i
+				break
+			}
+		}
+		matches = append(matches, Match{Round: round, Team1ID: ids[bye], Team2ID: byeTeam})
+		ids = append(ids[:bye:bye], ids[bye+1:]...)
+	}
+
+	// Each team plays the best ranked team left it has not played yet,
+	// backtracking when the last teams would have to play again. Rematches
+	// are only allowed when they cannot be avoided.
+	var pair func(ids []string, rematches bool) ([]Match, bool)
+	pair = func(ids []string, rematches bool) ([]Match, bool) {
+		if len(ids) == 0 {
+			return nil, true
+		}
+		for j := 1; j < len(ids); j++ {
+			if !rematches && played[ids[0]+"|"+ids[j]] {
+				continue
+			}
+			rest := append(append([]string{}, ids[1:j]...), ids[j+1:]...)
+			if pairs, ok := pair(rest, rematches); ok {
+				return append([]Match{{Round: round, Team1ID: ids[0], Team2ID: ids[j]}}, pairs...), true
+			}
+		}
+		return nil, false
+	}
+	pairs, ok := pair(ids, false)
+	if !ok {
+		pairs, _ = pair(ids, true)
+	}
+	matches = append(matches, pairs...)
+
+	for i := range matches {
+		matches[i].ID = fmt.Sprintf("S%d-%d", round, i+1)
+	}
+	t.Matches = append(t.Matches, matches...)
+}
+
+// startRound creates the games of a round of a round robin or Swiss
+// tournament
+func (t *Tournament) startRound(round int) {
+	for i := range t.Matches {
+		if t.Matches[i].Round == round {
+			t.resolve(t.Matches[i].ID)
+		}
+	}
+}
+
+// setSlot places a team in a slot of a match, and resolves the match if both
+// its teams are known
+func (t *Tournament) setSlot(matchID string, slot int, teamID string) {
+	m := t.match(matchID)
+	if m == nil {
+		return
+	}
+	if slot == 1 {
+		m.Team1ID = teamID
+	} else {
+		m.Team2ID = teamID
+	}
+	t.resolve(matchID)
+}
+
+// resolve creates the game of a match whose teams are known, or directly
+// advances the team of a match against a bye.
+// A team which cannot play anymore (e.g. deleted, or with players removed
+// since it was registered) forfeits the match, so that the tournament goes
+// on.
+func (t *Tournament) resolve(matchID string) {
+	m := t.match(matchID)
+	if m.Team1ID == "" || m.Team2ID == "" || m.GameID != "" || m.WinnerID != "" {
+		return
+	}
+	switch {
+	case m.Team2ID == byeTeam:
+		t.finish(matchID, m.Team1ID, m.Team2ID)
+	case m.Team1ID == byeTeam:
+		t.finish(matchID, m.Team2ID, m.Team1ID)
+	default:
+		if err := t.eligible(m.Team1ID); err != nil {
+			log.Printf("Match %s of tournament %s forfeited: %v", m.ID, t.Name, err)
+			t.finish(matchID, m.Team2ID, m.Team1ID)
+			return
+		}
+		if err := t.eligible(m.Team2ID); err != nil {
+			log.Printf("Match %s of tournament %s forfeited: %v", m.ID, t.Name, err)
+			t.finish(matchID, m.Team1ID, m.Team2ID)
+			return
+		}
+		t.createGame(m)
+	}
+}
+
+// createGame creates the game of a match between two eligible teams
+func (t *Tournament) createGame(m *Match) {
+	e := recordEvent(Event{Type: GameCreated, GameID: uuid.New().String(), Name: fmt.Sprintf("%s - %s", t.Name, m.ID), Team1ID: m.Team1ID, Team2ID: m.Team2ID, Mode: t.Mode, MapID: playableMap(t.MapID)})
+	m.GameID = e.GameID
+}
+
+// finish records the result of a match and advances its teams: to the next
+// matches in elimination tournaments (or to the grand final reset match), or
+// to the next round in round robin and Swiss tournaments
+func (t *Tournament) finish(matchID, winnerID, loserID string) {
+	m := t.match(matchID)
+	m.WinnerID, m.LoserID = winnerID, loserID
+	round, next, nextSlot, loserMatch, loserSlot := m.Round, m.NextMatchID, m.NextSlot, m.LoserMatchID, m.LoserSlot
+
+	switch t.Format {
+	case SingleElimination, DoubleElimination:
+		// The team coming from the losers bracket has to win the grand final
+		// twice
+		if matchID == grandFinalID && winnerID == m.Team2ID && loserID != byeTeam {
+			t.Matches = append(t.Matches, Match{ID: grandFinalResetID, Bracket: GrandFinal, Round: round + 1})
+			t.setSlot(grandFinalResetID, 1, loserID)
+			t.setSlot(grandFinalResetID, 2, winnerID)
+			return
+		}
+		if loserMatch != "" {
+			t.setSlot(loserMatch, loserSlot, loserID)
+		}
+		if next != "" {
+			t.setSlot(next, nextSlot, winnerID)
+			return
+		}
+		t.WinnerID = winnerID
+		t.Status = TournamentFinished
+	case RoundRobin, Swiss:
+		for _, other := range t.Matches {
+			if other.Round == round && other.WinnerID == "" {
+				return
+			}
+		}
+		if round < t.NbRounds {
+			if t.Format == Swiss {
+				t.pairSwissRound(round + 1)
+			}
+			t.startRound(round + 1)
+			return
+		}
+		t.WinnerID = t.Standings()[0].TeamID
+		t.Status = TournamentFinished
+	}
+}
+
+// Standings ranks the teams of a tournament by wins, then by seed.
+// A bye counts as a win.
+func (t *Tournament) Standings() []TournamentStanding {
+	standings := []TournamentStanding{}
+	index := map[string]int{}
+	for _, team := range t.Teams {
+		index[team.TeamID] = len(standings)
+		standings = append(standings, TournamentStanding{TeamID: team.TeamID, Seed: team.Seed})
+	}
+	for _, m := range t.Matches {
+		if m.WinnerID == "" {
+			continue
+		}
+		if i, ok := index[m.WinnerID]; ok {
+			standings[i].Played++
+			standings[i].Wins++
+		}
+		if i, ok := index[m.LoserID]; ok {
+			standings[i].Played++
+			standings[i].Losses++
+		}
+	}
+	for i := range standings {
+		standings[i].Points = standings[i].Wins
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		if standings[i].Points != standings[j].Points {
+			return standings[i].Points > standings[j].Points
+		}
+		return standings[i].Seed < standings[j].Seed
+	})
+	return standings
+}
+
+// tournamentMatch returns the tournament and match of a game, or nil if the
+// game is not a tournament match
+func tournamentMatch(gameID string) (*Tournament, *Match) {
+	for i := range tournaments {
+		for j := range tournaments[i].Matches {
+			if tournaments[i].Matches[j].GameID == gameID {
+				return &tournaments[i], &tournaments[i].Matches[j]
+			}
+		}
+	}
+	return nil, nil
+}
+
+// advanceTournaments records the result of a stopped game in the tournament
+// match it belongs to, if any
+func advanceTournaments(g *Game) {
+	t, m := tournamentMatch(g.ID)
+	if t == nil || m.WinnerID != "" || g.WinnerID == "" {
+		return
+	}
+	loserID := m.Team1ID
+	if g.WinnerID == m.Team1ID {
+		loserID = m.Team2ID
+	}
+	t.finish(m.ID, g.WinnerID, loserID)
+}
+
+// tournamentCreationHandler creates a tournament based on the name, format,
+// mode, mapId (optional), teamSize (3 to 5, by default the size of the first team registered)
+// and rounds (Swiss tournaments only, by default enough rounds to single out
+// a winner) parameters
+func tournamentCreationHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Tournament could not be created because of malformed POST parameters"))
+		return
+	}
+	t := Tournament{
+		ID:     uuid.New().String(),
+		Name:   r.Form.Get("name"),
+		Format: r.Form.Get("format"),
+		Mode:   r.Form.Get("mode"),
+		MapID:  r.Form.Get("mapId"),
+		Status: TournamentRegistration,
+		Teams:  []TournamentTeam{},
+	}
+	if t.Name == "" || t.Format == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Tournament could not be created because of empty POST parameter"))
+		return
+	}
+	switch t.Format {
+	case SingleElimination, DoubleElimination, RoundRobin, Swiss:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Tournament could not be created because of unknown format"))
+		return
+	}
+	if t.Mode == "" {
+		t.Mode = DefaultMode
+	}
+	if !validMap(t.MapID) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("No map could be found with this id"))
+		return
+	}
+	if v := r.Form.Get("teamSize"); v != "" {
+		t.TeamSize, err = strconv.Atoi(v)
+		if err != nil || t.TeamSize < 3 || t.TeamSize > 5 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Tournament could not be created because of malformed teamSize parameter"))
+			return
+		}
+	}
+	if v := r.Form.Get("rounds"); v != "" && t.Format == Swiss {
+		t.NbRounds, err = strconv.Atoi(v)
+		if err != nil || t.NbRounds < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Tournament could not be created because of malformed rounds parameter"))
+			return
+		}
+	}
+
+	tournaments = append(tournaments, t)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// tournamentRegistrationHandler registers a team in a tournament based on the
+// teamId parameter, and an optional seed parameter.
+// The team must be eligible (see Tournament.eligible).
+func tournamentRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Team could not be registered because of malformed POST parameters"))
+		return
+	}
+
+	t := tournament(mux.Vars(r)["id"])
+	if t == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Tournament not found"))
+		return
+	}
+	if t.Status != TournamentRegistration {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Team could not be registered because the tournament has started"))
+		return
+	}
+
+	team := world.Team(r.Form.Get("teamId"))
+	if team == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Team not found"))
+		return
+	}
+	for _, registered := range t.Teams {
+		if registered.TeamID == team.ID {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("Team could not be registered because it is already registered"))
+			return
+		}
+	}
+	if err := t.eligible(team.ID); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Team could not be registered because " + err.Error()))
+		return
+	}
+
+	seed := 0
+	if v := r.Form.Get("seed"); v != "" {
+		seed, err = strconv.Atoi(v)
+		if err != nil || seed < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Team could not be registered because of malformed seed parameter"))
+			return
+		}
+	}
+
+	t.Teams = append(t.Teams, TournamentTeam{TeamID: team.ID, Seed: seed})
+	if t.TeamSize == 0 {
+		t.TeamSize = len(team.Players)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// tournamentStartHandler closes the registrations of a tournament, seeds the
+// teams and creates the games of the first round
+func tournamentStartHandler(w http.ResponseWriter, r *http.Request) {
+	t := tournament(mux.Vars(r)["id"])
+	if t == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Tournament not found"))
+		return
+	}
+	if t.Status != TournamentRegistration {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Tournament has already started"))
+		return
+	}
+	if len(t.Teams) < 2 || (t.Format == DoubleElimination && len(t.Teams) < 3) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Tournament could not be started because too few teams are registered"))
+		return
+	}
+
+	if err := t.Start(); err != nil {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Tournament could not be started because " + err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// tournamentsListingHandler lists all the tournaments
+func tournamentsListingHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tournaments)
+}
+
+// tournamentRetrievalHandler returns a tournament with its bracket (matches)
+// and standings
+func tournamentRetrievalHandler(w http.ResponseWriter, r *http.Request) {
+	t := tournament(mux.Vars(r)["id"])
+	if t == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Tournament not found"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		*Tournament
+		Standings []TournamentStanding `json:"standings"`
+	}{t, t.Standings()})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// newTestTournament creates a tournament of the format provided, registers
+// nbTeams new teams seeded in order and starts it
+func newTestTournament(t *testing.T, format string, nbTeams int) Tournament {
+	rr := doRequest(t, "POST", "/admin/tournaments", url.Values{"name": {"Cup " + format}, "format": {format}, "mode": {"tournament"}}, true)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("tournament creation failed: %v %s", rr.Code, rr.Body.String())
+	}
+	var tr Tournament
+	json.Unmarshal(rr.Body.Bytes(), &tr)
+
+	for i := 1; i <= nbTeams; i++ {
+		team := newTestTeam(t, fmt.Sprintf("%s team %d", format, i), 3)
+		rr = doRequest(t, "POST", fmt.Sprintf("/admin/tournaments/%s/teams", tr.ID), url.Values{"teamId": {team.ID}, "seed": {fmt.Sprint(i)}}, true)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("team registration failed: %v %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	rr = doRequest(t, "POST", fmt.Sprintf("/admin/tournaments/%s/start", tr.ID), nil, true)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("tournament start failed: %v %s", rr.Code, rr.Body.String())
+	}
+	json.Unmarshal(rr.Body.Bytes(), &tr)
+	return tr
+}
+
+// playTournament stops the games of a tournament until it is finished, the
+// best seed winning each game, and returns the tournament
+func playTournament(t *testing.T, id string) Tournament {
+	seeds := map[string]int{}
+	for {
+		rr := doRequest(t, "GET", "/tournaments/"+id, nil, false)
+		var tr Tournament
+		json.Unmarshal(rr.Body.Bytes(), &tr)
+		if tr.Status == TournamentFinished {
+			return tr
+		}
+		for _, team := range tr.Teams {
+			seeds[team.TeamID] = team.Seed
+		}
+
+		played := false
+		for _, m := range tr.Matches {
+			if m.GameID == "" || m.WinnerID != "" {
+				continue
+			}
+			winnerID := m.Team1ID
+			if seeds[m.Team2ID] < seeds[m.Team1ID] {
+				winnerID = m.Team2ID
+			}
+			doRequest(t, "PUT", "/games/"+m.GameID, url.Values{"teamId": {winnerID}}, false)
+			played = true
+		}
+		if !played {
+			t.Fatalf("tournament %s is stuck: %+v", tr.Format, tr.Matches)
+		}
+	}
+}
+
+// TestEliminationTournaments tests that elimination brackets give byes to the
+// best seeds and are won by the best seed when it wins all its games
+func TestEliminationTournaments(t *testing.T) {
+	tr := newTestTournament(t, SingleElimination, 3)
+	if len(tr.Matches) != 3 || tr.Matches[0].WinnerID != tr.Teams[0].TeamID || tr.Matches[0].GameID != "" ||
+		tr.Matches[1].GameID == "" || tr.Matches[2].Team1ID != tr.Teams[0].TeamID {
+		t.Errorf("unexpected single elimination bracket: got %+v", tr.Matches)
+	}
+	if g := world.Game(tr.Matches[1].GameID); g == nil || g.Mode != "tournament" {
+		t.Errorf("game of the match was not created: got %+v", g)
+	}
+	tr = playTournament(t, tr.ID)
+	if tr.WinnerID != tr.Teams[0].TeamID {
+		t.Errorf("unexpected single elimination winner: got %v want %v", tr.WinnerID, tr.Teams[0].TeamID)
+	}
+
+	tr = newTestTournament(t, DoubleElimination, 4)
+	if len(tr.Matches) != 6 {
+		t.Errorf("unexpected number of double elimination matches: got %v want %v", len(tr.Matches), 6)
+	}
+	tr = playTournament(t, tr.ID)
+	final := tr.match("GF")
+	if tr.WinnerID != tr.Teams[0].TeamID || final.Team2ID != tr.Teams[1].TeamID {
+		t.Errorf("unexpected double elimination result: got winner %v and grand final %+v", tr.WinnerID, final)
+	}
+}
+
+// TestRoundTournaments tests that every team plays every other team in a
+// round robin tournament, and that Swiss rounds avoid rematches
+func TestRoundTournaments(t *testing.T) {
+	tr := newTestTournament(t, RoundRobin, 5)
+	if tr.NbRounds != 5 || len(tr.Matches) != 10 {
+		t.Errorf("unexpected round robin schedule: got %v rounds and %v matches", tr.NbRounds, len(tr.Matches))
+	}
+	for _, m := range tr.Matches {
+		if (m.Round == 1) != (m.GameID != "") {
+			t.Errorf("games should only be created for the first round: got %+v", m)
+		}
+	}
+	tr = playTournament(t, tr.ID)
+	standings := tr.Standings()
+	if tr.WinnerID != tr.Teams[0].TeamID || standings[0].Wins != 4 || standings[4].Losses != 4 {
+		t.Errorf("unexpected round robin standings: got %+v", standings)
+	}
+
+	tr = newTestTournament(t, Swiss, 5)
+	if tr.NbRounds != 3 || len(tr.Matches) != 3 || tr.Matches[0].Team2ID != byeTeam {
+		t.Errorf("unexpected Swiss first round: got %v rounds and %+v", tr.NbRounds, tr.Matches)
+	}
+	tr = playTournament(t, tr.ID)
+	played := map[string]bool{}
+	for _, m := range tr.Matches {
+		if played[m.Team1ID+m.Team2ID] {
+			t.Errorf("unexpected Swiss rematch: %+v", m)
+		}
+		played[m.Team1ID+m.Team2ID], played[m.Team2ID+m.Team1ID] = true, true
+	}
+	if tr.WinnerID != tr.Teams[0].TeamID {
+		t.Errorf("unexpected Swiss winner: got %v want %v", tr.WinnerID, tr.Teams[0].TeamID)
+	}
+
+	rr := doRequest(t, "POST", fmt.Sprintf("/admin/tournaments/%s/start", tr.ID), nil, true)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusConflict)
+	}
+}
+
+// TestGrandFinalReset tests that the grand final of a double elimination
+// tournament is played again when the team coming from the losers bracket
+// wins it
+func TestGrandFinalReset(t *testing.T) {
+	tr := newTestTournament(t, DoubleElimination, 4)
+	seeds := map[string]int{}
+	for _, team := range tr.Teams {
+		seeds[team.TeamID] = team.Seed
+	}
+	current := func() *Tournament {
+		return tournament(tr.ID)
+	}
+	for current().match(grandFinalID).GameID == "" {
+		for _, m := range append([]Match{}, current().Matches...) {
+			if m.GameID != "" && m.WinnerID == "" {
+				winnerID := m.Team1ID
+				if seeds[m.Team2ID] < seeds[m.Team1ID] {
+					winnerID = m.Team2ID
+				}
+				doRequest(t, "PUT", "/games/"+m.GameID, url.Values{"teamId": {winnerID}}, false)
+			}
+		}
+	}
+
+	final := *current().match(grandFinalID)
+	doRequest(t, "PUT", "/games/"+final.GameID, url.Values{"teamId": {final.Team2ID}}, false)
+	reset := current().match(grandFinalResetID)
+	if current().Status != TournamentRunning || reset == nil || reset.GameID == "" ||
+		reset.Team1ID != final.Team1ID || reset.Team2ID != final.Team2ID {
+		t.Fatalf("unexpected grand final reset: got %+v", reset)
+	}
+
+	doRequest(t, "PUT", "/games/"+reset.GameID, url.Values{"teamId": {final.Team2ID}}, false)
+	if current().Status != TournamentFinished || current().WinnerID != final.Team2ID {
+		t.Errorf("unexpected winner after the grand final reset: got %+v", current())
+	}
+}
+
+// TestTournamentEligibility tests that only teams with the size of the
+// tournament can be registered, and that a tournament cannot start once a
+// registered team is not eligible anymore
+func TestTournamentEligibility(t *testing.T) {
+	rr := doRequest(t, "POST", "/admin/tournaments", url.Values{"name": {"Eligibility Cup"}, "format": {SingleElimination}}, true)
+	var tr Tournament
+	json.Unmarshal(rr.Body.Bytes(), &tr)
+	register := func(team Team) int {
+		return doRequest(t, "POST", fmt.Sprintf("/admin/tournaments/%s/teams", tr.ID), url.Values{"teamId": {team.ID}}, true).Code
+	}
+
+	team1 := newTestTeam(t, "Eligible team 1", 3)
+	team2 := newTestTeam(t, "Eligible team 2", 3)
+	for _, team := range []Team{team1, team2} {
+		if status := register(team); status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v",
+				status, http.StatusOK)
+		}
+	}
+	for _, team := range []Team{newTestTeam(t, "Too small team", 2), newTestTeam(t, "Larger team", 4)} {
+		if status := register(team); status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code for %s: got %v want %v",
+				team.Name, status, http.StatusBadRequest)
+		}
+	}
+
+	doRequest(t, "DELETE", fmt.Sprintf("/teams/%s/players/%s", team2.ID, team2.Players[0].ID), nil, false)
+	rr = doRequest(t, "POST", fmt.Sprintf("/admin/tournaments/%s/start", tr.ID), nil, true)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusConflict)
+	}
+	if tournament(tr.ID).Status != TournamentRegistration {
+		t.Errorf("tournament started with an ineligible team")
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Unlock records when, and in which game, a player earned an achievement,
+// along with the stats of the player in this game at that time.
+// Seq is the sequence number of the event which unlocked the achievement.
+// Live tells whether the achievement was unlocked during the game.
+type Unlock struct {
+	PlayerID      string    `json:"playerId"`
+	AchievementID string    `json:"achievementId"`
+	UnlockedAt    time.Time `json:"unlockedAt"`
+	GameID        string    `json:"gameId"`
+	Stats         Stats     `json:"stats"`
+	Seq           int       `json:"seq"`
+	Live          bool      `json:"live"`
+}
+
+// Unlocked returns the unlock of an achievement by a player, or nil if the
+// player has not earned this achievement yet
+func (w *World) Unlocked(playerID, achievementID string) *Unlock {
+	for i := range w.Unlocks {
+		if w.Unlocks[i].PlayerID == playerID && w.Unlocks[i].AchievementID == achievementID {
+			return &w.Unlocks[i]
+		}
+	}
+	return nil
+}
+
+// UpdateUnlocks records the achievements a player earned for the first time
+// in a game, given the games he stopped from this game on with their
+// achievements (see updateStoppedPlayer).
+// When the stats of the player in a game were corrected, the unlock of each
+// achievement is moved to the first of these games in which it is reached
+// (earlier or later than before), or revoked if it is not reached anymore.
+// Unlocks of games stopped before are not affected.
+// Live unlocks are final: players were already notified during the game.
+// It returns the number of unlocks added minus the number of unlocks removed.
+func (w *World) UpdateUnlocks(g *Game, playerID string, games []*Game, e Event) int {
+	affected := map[string]bool{}
+	for _, sg := range games {
+		affected[sg.ID] = true
+	}
+
+	nbUnlocks := 0
+	// Iterate over rules rather than over the achievements map to keep
+	// unlocks of the same game in a stable order
+	for _, r := range w.Rules.Rules {
+		if r.Scope != ScopeGame && r.Scope != ScopeLifetime {
+			continue
+		}
+		for _, id := range r.AchievementIDs() {
+			u := w.Unlocked(playerID, id)
+			if u != nil && (u.Live || !affected[u.GameID]) {
+				continue
+			}
+			var first *Game
+			for _, sg := range games {
+				if sg.Player(playerID).Achievements[id] {
+					first = sg
+					break
+				}
+			}
+
+			switch {
+			case u != nil && (first == nil || first.ID != u.GameID):
+				w.removeUnlock(u)
+				nbUnlocks--
+			case u != nil:
+				continue
+			}
+			if first != nil {
+				// Games stopped after the corrected one unlock the
+				// achievement when they stopped
+				at := e
+				if first != g {
+					at = Event{Time: first.StopTime, Seq: e.Seq}
+				}
+				w.unlock(first, *first.Player(playerID), id, at, false)
+				nbUnlocks++
+			}
+		}
+	}
+	return nbUnlocks
+}
+
+// UpdateLiveUnlocks evaluates the live achievements of a player during a game
+// and records the ones he just earned for the first time.
+// Lifetime achievements are evaluated against the stats of all his stopped
+// games plus his current stats in this game.
+func (w *World) UpdateLiveUnlocks(g *Game, p *Player, e Event) {
+	vars := map[string]map[string]float64{
+		ScopeGame:     statsVariables(p.Stats),
+		ScopeLifetime: w.LifetimeVariables(p.ID, p.Stats),
+	}
+
+	for _, r := range w.Rules.Rules {
+		if !r.Live {
+			continue
+		}
+		for _, id := range r.AchievementIDs() {
+			if !r.Evaluate(vars[r.Scope])[id] || w.Unlocked(p.ID, id) != nil {
+				continue
+			}
+			if p.Achievements == nil {
+				p.Achievements = Achievements{}
+			}
+			p.Achievements[id] = true
+			w.unlock(g, *p, id, e, true)
+			w.AddXP(p.ID, w.XPConfig.PerAchievement, g.ID)
+		}
+	}
+}
+
+// unlock records the unlock of an achievement by a player in a game
+func (w *World) unlock(g *Game, p Player, id string, e Event, live bool) {
+	w.Unlocks = append(w.Unlocks, Unlock{
+		PlayerID:      p.ID,
+		AchievementID: id,
+		UnlockedAt:    e.Time,
+		GameID:        g.ID,
+		Stats:         p.Stats,
+		Seq:           e.Seq,
+		Live:          live,
+	})
+}
+
+// removeUnlock removes an unlock from the world
+func (w *World) removeUnlock(u *Unlock) {
+	for i := range w.Unlocks {
+		if &w.Unlocks[i] == u {
+			w.Unlocks = append(w.Unlocks[:i], w.Unlocks[i+1:]...)
+			return
+		}
+	}
+}
+
+// playerUnlocksHandler lists chronologically all the achievements a player
+// has unlocked, with the game in which he unlocked them.
+// They can be restricted to the games in which he played the class provided
+// by the class query parameter.
+func playerUnlocksHandler(w http.ResponseWriter, r *http.Request) {
+	wd, err := requestedWorld(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Achievements could not be listed because of malformed as_of parameter"))
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	if wd.Player(vars["playerId"]) == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Player not found"))
+		return
+	}
+
+	class := r.URL.Query().Get("class")
+	unlocks := []Unlock{}
+	for _, u := range wd.Unlocks {
+		if u.PlayerID == vars["playerId"] && wd.playedClass(u.GameID, u.PlayerID, class) {
+			unlocks = append(unlocks, u)
+		}
+	}
+	sort.SliceStable(unlocks, func(i, j int) bool {
+		return unlocks[i].UnlockedAt.Before(unlocks[j].UnlockedAt)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(unlocks)
+}
+
+// gameUnlocksHandler lists the achievements unlocked in a game, in the order
+// they were unlocked.
+// The game client can poll it during a game with the after query parameter
+// set to the seq of the last unlock received, in order to display live
+// unlocks as soon as they happen.
+func gameUnlocksHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	after := 0
+	if v := r.URL.Query().Get("after"); v != "" {
+		var err error
+		after, err = strconv.Atoi(v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Unlocks could not be listed because of malformed after parameter"))
+			return
+		}
+	}
+
+	if world.Game(vars["gameId"]) == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Game not found"))
+		return
+	}
+
+	unlocks := []Unlock{}
+	for _, u := range world.Unlocks {
+		if u.GameID == vars["gameId"] && u.Seq > after {
+			unlocks = append(unlocks, u)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(unlocks)
+}
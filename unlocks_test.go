@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// TestPlayerUnlocksHandler tests that achievements are unlocked once, in the
+// game where they were earned first, and are listed chronologically
+func TestPlayerUnlocksHandler(t *testing.T) {
+	team1 := newTestTeam(t, "Unlocking Team 1", 3)
+	team2 := newTestTeam(t, "Unlocking Team 2", 3)
+	player := team1.Players[0]
+
+	// Player gets the sharpshooter achievement in the first game, then
+	// both the sharpshooter and the bruiser achievements in the second game
+	var gameIDs []string
+	for i, stats := range [][]string{{"nbAttemptedAttacks", "nbHits"}, {"nbAttemptedAttacks", "nbHits", "damageDone"}} {
+		rr := doRequest(t, "POST", "/games", url.Values{"name": {fmt.Sprintf("Unlocking Game %d", i)}, "team1Id": {team1.ID}, "team2Id": {team2.ID}}, false)
+		var g Game
+		json.Unmarshal(rr.Body.Bytes(), &g)
+		gameIDs = append(gameIDs, g.ID)
+		for _, stat := range stats {
+			n := 1
+			if stat == "damageDone" {
+				n = 500
+			}
+			for j := 0; j < n; j++ {
+				doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, player.ID), url.Values{"name": {stat}}, false)
+			}
+		}
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {team1.ID}}, false)
+	}
+
+	rr := doRequest(t, "GET", fmt.Sprintf("/players/%s/achievements", player.ID), nil, false)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var unlocks []Unlock
+	json.Unmarshal(rr.Body.Bytes(), &unlocks)
+	if len(unlocks) != 2 {
+		t.Fatalf("handler returned unexpected number of unlocks: got %v want %v",
+			len(unlocks), 2)
+	}
+	if unlocks[0].AchievementID != "sharpshooter" || unlocks[0].GameID != gameIDs[0] {
+		t.Errorf("handler returned unexpected first unlock: got %+v", unlocks[0])
+	}
+	if unlocks[1].AchievementID != "bruiser" || unlocks[1].GameID != gameIDs[1] || unlocks[1].Stats.DamageDone != 500 {
+		t.Errorf("handler returned unexpected second unlock: got %+v", unlocks[1])
+	}
+	if unlocks[1].UnlockedAt.Before(unlocks[0].UnlockedAt) {
+		t.Errorf("handler returned unlocks out of chronological order")
+	}
+
+	// Unknown players are not found
+	rr = doRequest(t, "GET", "/players/unknown/achievements", nil, false)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}
+
+// TestGameUnlocksHandler tests that live achievements are unlocked as soon as
+// they are reached during a game, and can be polled by the game client
+func TestGameUnlocksHandler(t *testing.T) {
+	g := newTestGame(t, "Live Game")
+	player := g.Team2.Players[0]
+	endpoint := fmt.Sprintf("/games/%s/unlocks", g.ID)
+
+	for i := 0; i < 499; i++ {
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, player.ID), url.Values{"name": {"damageDone"}}, false)
+	}
+	rr := doRequest(t, "GET", endpoint, nil, false)
+	var unlocks []Unlock
+	json.Unmarshal(rr.Body.Bytes(), &unlocks)
+	if len(unlocks) != 0 {
+		t.Errorf("handler returned unexpected number of unlocks: got %v want %v",
+			len(unlocks), 0)
+	}
+
+	// The 500th damage unlocks the bruiser achievement while the game is running
+	rr = doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, player.ID), url.Values{"name": {"damageDone"}}, false)
+	var p Player
+	json.Unmarshal(rr.Body.Bytes(), &p)
+	if !p.Achievements["bruiser"] {
+		t.Errorf("handler returned unexpected bruiser achievement in body: got %v want %v",
+			p.Achievements["bruiser"], true)
+	}
+
+	rr = doRequest(t, "GET", endpoint, nil, false)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	json.Unmarshal(rr.Body.Bytes(), &unlocks)
+	if len(unlocks) != 1 || unlocks[0].AchievementID != "bruiser" || !unlocks[0].Live {
+		t.Fatalf("handler returned unexpected unlocks: got %+v", unlocks)
+	}
+
+	// Polling after the last unlock received returns nothing new, even
+	// once the game is stopped
+	doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {g.Team2.ID}}, false)
+	rr = doRequest(t, "GET", fmt.Sprintf("%s?after=%d", endpoint, unlocks[0].Seq), nil, false)
+	json.Unmarshal(rr.Body.Bytes(), &unlocks)
+	if len(unlocks) != 0 {
+		t.Errorf("handler returned unexpected number of unlocks: got %v want %v",
+			len(unlocks), 0)
+	}
+}
+
+// TestCorrectedUnlocks tests that an unlock removed by a correction moves to
+// the first game in which the achievement is reached, and that live unlocks
+// are final
+func TestCorrectedUnlocks(t *testing.T) {
+	team1 := newTestTeam(t, "Corrected Unlocks Team 1", 3)
+	team2 := newTestTeam(t, "Corrected Unlocks Team 2", 3)
+	player := team1.Players[0]
+
+	var gameIDs []string
+	for i := 0; i < 2; i++ {
+		rr := doRequest(t, "POST", "/games", url.Values{"name": {"Corrected Unlocks Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}}, false)
+		var g Game
+		json.Unmarshal(rr.Body.Bytes(), &g)
+		gameIDs = append(gameIDs, g.ID)
+		for _, stat := range []string{"nbAttemptedAttacks", "nbHits"} {
+			doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, player.ID), url.Values{"name": {stat}}, false)
+		}
+		if i == 0 {
+			for j := 0; j < 500; j++ {
+				doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, player.ID), url.Values{"name": {"damageDone"}}, false)
+			}
+		}
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {team1.ID}}, false)
+	}
+
+	for _, stat := range []string{"nbHits", "damageDone"} {
+		params := url.Values{"name": {stat}, "action": {"set"}, "value": {"0"}, "reason": {"cheating"}}
+		doRequest(t, "PUT", fmt.Sprintf("/admin/games/%s/players/%s/stats", gameIDs[0], player.ID), params, true)
+	}
+
+	rr := doRequest(t, "GET", fmt.Sprintf("/players/%s/achievements", player.ID), nil, false)
+	unlocks := map[string]Unlock{}
+	var list []Unlock
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	for _, u := range list {
+		unlocks[u.AchievementID] = u
+	}
+	if u, ok := unlocks["sharpshooter"]; !ok || u.GameID != gameIDs[1] {
+		t.Errorf("sharpshooter should be unlocked in the second game: got %+v", list)
+	}
+	if u, ok := unlocks["bruiser"]; !ok || u.GameID != gameIDs[0] || !u.Live {
+		t.Errorf("live bruiser unlock should be kept: got %+v", list)
+	}
+}
+
+// TestCorrectedLifetimeUnlocks tests that a correction moves a lifetime
+// unlock to the first game which reaches it, earlier or later, and revokes it
+// when no game reaches it anymore
+func TestCorrectedLifetimeUnlocks(t *testing.T) {
+	defer resetRules()
+	rule := AchievementRule{ID: "killer", Name: "Killer", Metric: "nbKills", Tiers: []Tier{{Threshold: 3}}, Scope: ScopeLifetime}
+	if err := rule.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	recordEvent(Event{Type: RuleCreated, Rule: &rule})
+
+	team1 := newTestTeam(t, "Corrected Killer Team 1", 3)
+	team2 := newTestTeam(t, "Corrected Killer Team 2", 3)
+	player := team1.Players[0]
+
+	var gameIDs []string
+	for i := 0; i < 3; i++ {
+		rr := doRequest(t, "POST", "/games", url.Values{"name": {"Corrected Killer Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}}, false)
+		var g Game
+		json.Unmarshal(rr.Body.Bytes(), &g)
+		gameIDs = append(gameIDs, g.ID)
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, player.ID), url.Values{"name": {"nbKills"}}, false)
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {team1.ID}}, false)
+	}
+	if u := world.Unlocked(player.ID, "killer"); u == nil || u.GameID != gameIDs[2] {
+		t.Fatalf("killer should be unlocked in the third game: got %+v", u)
+	}
+
+	correct := func(gameID, value string) {
+		params := url.Values{"name": {"nbKills"}, "action": {"set"}, "value": {value}, "reason": {"recount"}}
+		doRequest(t, "PUT", fmt.Sprintf("/admin/games/%s/players/%s/stats", gameID, player.ID), params, true)
+	}
+	correct(gameIDs[0], "5")
+	if u := world.Unlocked(player.ID, "killer"); u == nil || u.GameID != gameIDs[0] {
+		t.Errorf("killer should move back to the corrected game: got %+v", u)
+	}
+	correct(gameIDs[0], "0")
+	correct(gameIDs[1], "0")
+	if u := world.Unlocked(player.ID, "killer"); u != nil {
+		t.Errorf("killer should be revoked once no game reaches it: got %+v", u)
+	}
+}
+
+// TestCorrectedLifetimeAchievements tests that the lifetime achievements of a
+// corrected game are evaluated with the streaks the player had at that game
+func TestCorrectedLifetimeAchievements(t *testing.T) {
+	team1 := newTestTeam(t, "Corrected Streak Team 1", 3)
+	team2 := newTestTeam(t, "Corrected Streak Team 2", 3)
+	player := team1.Players[0]
+
+	var gameIDs []string
+	for i := 0; i < 3; i++ {
+		rr := doRequest(t, "POST", "/games", url.Values{"name": {"Corrected Streak Game"}, "team1Id": {team1.ID}, "team2Id": {team2.ID}}, false)
+		var g Game
+		json.Unmarshal(rr.Body.Bytes(), &g)
+		gameIDs = append(gameIDs, g.ID)
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {team1.ID}}, false)
+	}
+
+	params := url.Values{"name": {"nbKills"}, "action": {"set"}, "value": {"1"}, "reason": {"missed kill"}}
+	doRequest(t, "PUT", fmt.Sprintf("/admin/games/%s/players/%s/stats", gameIDs[0], player.ID), params, true)
+
+	if a := world.Game(gameIDs[0]).Player(player.ID).Achievements; a["onFire:bronze"] {
+		t.Errorf("corrected game should be evaluated with the streak at that game: got %+v", a)
+	}
+	if a := world.Game(gameIDs[2]).Player(player.ID).Achievements; !a["onFire:bronze"] {
+		t.Errorf("later game should keep the achievement reached with its streak: got %+v", a)
+	}
+}
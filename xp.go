@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// XPConfig defines how much experience players earn: per unit of each stat,
+// per game played, per game won and per achievement unlocked.
+// The experience needed to reach level n is LevelBase * (n-1)^LevelExponent.
+type XPConfig struct {
+	PerStat        map[string]int `json:"perStat"`
+	PerGame        int            `json:"perGame"`
+	PerWin         int            `json:"perWin"`
+	PerAchievement int            `json:"perAchievement"`
+	LevelBase      float64        `json:"levelBase"`
+	LevelExponent  float64        `json:"levelExponent"`
+}
+
+// Progression represents the experience and level of a player
+type Progression struct {
+	XP    int `json:"xp"`
+	Level int `json:"level"`
+}
+
+// LevelUp records when, and in which game, a player reached a level.
+// Seq is the sequence number of the LevelReached event recording it.
+type LevelUp struct {
+	PlayerID string    `json:"playerId"`
+	Level    int       `json:"level"`
+	At       time.Time `json:"at"`
+	GameID   string    `json:"gameId"`
+	Seq      int       `json:"seq"`
+}
+
+// defaultXPConfig returns the experience awarded by default
+func defaultXPConfig() XPConfig {
+	return XPConfig{
+		PerStat: map[string]int{
+			"nbHits":          1,
+			"nbKills":         10,
+			"nbFirstHitKills": 5,
+			"nbAssists":       5,
+			"nbSpellCasts":    1,
+		},
+		PerGame:        50,
+		PerWin:         100,
+		PerAchievement: 200,
+		LevelBase:      500,
+		LevelExponent:  1.5,
+	}
+}
+
+// Validate checks that the stats awarding experience exist and that the
+// level curve is increasing: a base and an exponent of at least 1 make every
+// level need more experience than the previous one
+func (c XPConfig) Validate() error {
+	for stat, xp := range c.PerStat {
+		if _, ok := (&Stats{}).Stat(stat); !ok {
+			return fmt.Errorf("unknown stat %q", stat)
+		}
+		if xp < 0 {
+			return fmt.Errorf("negative experience for stat %q", stat)
+		}
+	}
+	if c.PerGame < 0 || c.PerWin < 0 || c.PerAchievement < 0 {
+		return fmt.Errorf("experience cannot be negative")
+	}
+	if c.LevelBase < 1 || c.LevelExponent < 1 {
+		return fmt.Errorf("level base and exponent must be at least 1")
+	}
+	return nil
+}
+
+// LevelXP returns the experience needed to reach a level
+func (c XPConfig) LevelXP(level int) int {
+	if level <= 1 {
+		return 0
+	}
+	return int(c.LevelBase * math.Pow(float64(level-1), c.LevelExponent))
+}
+
+// Level returns the level reached with the experience provided.
+// It inverts the level curve, then adjusts the level by a few steps at most
+// since LevelXP rounds the experience down.
+func (c XPConfig) Level(xp int) int {
+	if xp <= 0 {
+		return 1
+	}
+	level := 1 + int(math.Pow(float64(xp)/c.LevelBase, 1/c.LevelExponent))
+	for level > 1 && c.LevelXP(level) > xp {
+		level--
+	}
+	for c.LevelXP(level+1) <= xp {
+		level++
+	}
+	return level
+}
+
+// GameXP returns the experience earned with the stats of a game
+func (c XPConfig) GameXP(stats Stats) int {
+	xp := c.PerGame
+	for stat, perUnit := range c.PerStat {
+		v, _ := stats.Stat(stat)
+		xp += v * perUnit
+	}
+	if stats.TotalNbWins > 0 {
+		xp += c.PerWin
+	}
+	return xp
+}
+
+// AddXP adds experience (or removes it if negative) to a player because of
+// a game, and notes the levels he reached for the first time so that they
+// are recorded as LevelReached events (see recordEvent).
+// Levels lost after a correction are not recorded again when reached back.
+func (w *World) AddXP(playerID string, xp int, gameID string) {
+	if w.Progression == nil {
+		w.Progression = map[string]Progression{}
+		w.HighestLevels = map[string]int{}
+	}
+	pr := w.Progression[playerID]
+	pr.XP += xp
+	w.setProgression(playerID, pr.XP)
+
+	level := w.Progression[playerID].Level
+	for l := w.HighestLevels[playerID] + 1; l <= level; l++ {
+		if l > 1 {
+			w.levelUps = append(w.levelUps, LevelUp{PlayerID: playerID, Level: l, GameID: gameID})
+		}
+	}
+	if level > w.HighestLevels[playerID] {
+		w.HighestLevels[playerID] = level
+	}
+}
+
+// setProgression sets the experience of a player, and his level according
+// to the XP config, on his progression and on his team
+func (w *World) setProgression(playerID string, xp int) {
+	pr := Progression{XP: xp, Level: w.XPConfig.Level(xp)}
+	w.Progression[playerID] = pr
+	w.RankedVersion++
+	for i := range w.Teams {
+		for j := range w.Teams[i].Players {
+			if w.Teams[i].Players[j].ID == playerID {
+				w.Teams[i].Players[j].XP = pr.XP
+				w.Teams[i].Players[j].Level = pr.Level
+			}
+		}
+	}
+}
+
+// ChangeXPConfig changes the XP config and calculates again the experience
+// of all players from their stopped games and unlocked achievements.
+// Levels already reached stay recorded.
+func (w *World) ChangeXPConfig(c XPConfig) {
+	w.XPConfig = c
+	nbUnlocks := map[string]int{}
+	for _, u := range w.Unlocks {
+		nbUnlocks[u.PlayerID]++
+	}
+	for playerID := range w.Progression {
+		xp := nbUnlocks[playerID] * c.PerAchievement
+		for _, g := range w.PlayerGames(playerID) {
+			xp += c.GameXP(g.Player(playerID).Stats)
+		}
+		w.setProgression(playerID, xp)
+	}
+}
+
+// playerRetrievalHandler returns a player with his lifetime stats (or his
+// stats in the season provided by the season query parameter, and with the
+// class provided by the class query parameter), unlocked achievements (or the
+// ones reached with the class if provided), experience and level
+func playerRetrievalHandler(w http.ResponseWriter, r *http.Request) {
+	wd, err := requestedWorld(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Player could not be retrieved because of malformed as_of parameter"))
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	p := wd.Player(vars["playerId"])
+	if p == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Player not found"))
+		return
+	}
+
+	player := Player{ID: p.ID, Pseudo: p.Pseudo, Level: 1}
+	player.Stats = wd.LifetimeStats(p.ID)
+	seasonID, class := r.URL.Query().Get("season"), r.URL.Query().Get("class")
+	if seasonID != "" || class != "" {
+		player.Stats = wd.PlayerStats(p.ID, seasonID, class)
+	}
+	player.Achievements = Achievements{}
+	if class != "" {
+		player.Achievements = wd.ClassAchievements(p.ID, class)
+	} else {
+		for _, u := range wd.Unlocks {
+			if u.PlayerID == p.ID {
+				player.Achievements[u.AchievementID] = true
+			}
+		}
+	}
+	if pr, ok := wd.Progression[p.ID]; ok {
+		player.XP = pr.XP
+		player.Level = pr.Level
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(player)
+}
+
+// levelUpsHandler lists chronologically the levels reached by a player
+func levelUpsHandler(w http.ResponseWriter, r *http.Request) {
+	wd, err := requestedWorld(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Level ups could not be listed because of malformed as_of parameter"))
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	if wd.Player(vars["playerId"]) == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Player not found"))
+		return
+	}
+
+	levelUps := []LevelUp{}
+	for _, l := range wd.LevelUps {
+		if l.PlayerID == vars["playerId"] {
+			levelUps = append(levelUps, l)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(levelUps)
+}
+
+// xpConfigHandler returns the XP config
+func xpConfigHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(world.XPConfig)
+}
+
+// xpConfigUpdateHandler updates the XP config based on the perGame, perWin,
+// perAchievement, levelBase and levelExponent parameters, and on
+// perStat.<stat> parameters (e.g. perStat.nbKills).
+// The change is recorded in the event log, and the experience of all players
+// is calculated again with the new config.
+func xpConfigUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("XP config could not be updated because of malformed PUT parameters"))
+		return
+	}
+
+	// Work on a copy so that an invalid update leaves the config untouched
+	c := world.XPConfig
+	c.PerStat = map[string]int{}
+	for stat, xp := range world.XPConfig.PerStat {
+		c.PerStat[stat] = xp
+	}
+
+	for param, field := range map[string]*int{
+		"perGame":        &c.PerGame,
+		"perWin":         &c.PerWin,
+		"perAchievement": &c.PerAchievement,
+	} {
+		if v := r.Form.Get(param); v != "" {
+			*field, err = strconv.Atoi(v)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("XP config could not be updated because of malformed %s parameter", param)))
+				return
+			}
+		}
+	}
+	for param, field := range map[string]*float64{
+		"levelBase":     &c.LevelBase,
+		"levelExponent": &c.LevelExponent,
+	} {
+		if v := r.Form.Get(param); v != "" {
+			*field, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("XP config could not be updated because of malformed %s parameter", param)))
+				return
+			}
+		}
+	}
+	for param := range r.Form {
+		if !strings.HasPrefix(param, "perStat.") {
+			continue
+		}
+		xp, err := strconv.Atoi(r.Form.Get(param))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("XP config could not be updated because of malformed %s parameter", param)))
+			return
+		}
+		c.PerStat[strings.TrimPrefix(param, "perStat.")] = xp
+	}
+
+	if err := c.Validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("XP config could not be updated: " + err.Error()))
+		return
+	}
+	recordEvent(Event{Type: XPConfigChanged, XPConfig: &c})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(world.XPConfig)
+}
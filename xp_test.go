@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// TestLevelCurve tests the experience needed for each level
+func TestLevelCurve(t *testing.T) {
+	c := XPConfig{LevelBase: 100, LevelExponent: 2}
+	for xp, want := range map[int]int{-50: 1, 0: 1, 99: 1, 100: 2, 399: 2, 400: 3, 900: 4} {
+		if got := c.Level(xp); got != want {
+			t.Errorf("unexpected level for %d xp: got %v want %v", xp, got, want)
+		}
+	}
+
+	c = XPConfig{LevelBase: 500, LevelExponent: 1.5}
+	for level := 1; level < 1000; level++ {
+		if got := c.Level(c.LevelXP(level)); got != level {
+			t.Errorf("unexpected level for %d xp: got %v want %v", c.LevelXP(level), got, level)
+		}
+		if got := c.Level(c.LevelXP(level+1) - 1); got != level {
+			t.Errorf("unexpected level for %d xp: got %v want %v", c.LevelXP(level+1)-1, got, level)
+		}
+	}
+}
+
+// TestPlayerProgression tests that a won game awards experience, that the
+// player levels up according to the configured curve and that invalid
+// configs are rejected
+func TestPlayerProgression(t *testing.T) {
+	defer func() {
+		c := defaultXPConfig()
+		recordEvent(Event{Type: XPConfigChanged, XPConfig: &c})
+	}()
+
+	rr := doRequest(t, "PUT", "/admin/xp", url.Values{"levelBase": {"100"}, "levelExponent": {"1"}}, true)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	for param, value := range map[string]string{"perStat.nbTeleports": "1", "levelBase": "0", "levelExponent": "0.01", "perWin": "many"} {
+		rr = doRequest(t, "PUT", "/admin/xp", url.Values{param: {value}}, true)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code for %s %q: got %v want %v",
+				param, value, status, http.StatusBadRequest)
+		}
+	}
+
+	g := newTestGame(t, "Progression Game")
+	player := g.Team1.Players[0]
+	for i := 0; i < 10; i++ {
+		doRequest(t, "PUT", fmt.Sprintf("/games/%s/players/%s/stats", g.ID, player.ID), url.Values{"name": {"nbKills"}}, false)
+	}
+	doRequest(t, "PUT", fmt.Sprintf("/games/%s", g.ID), url.Values{"teamId": {g.Team1.ID}}, false)
+
+	rr = doRequest(t, "GET", fmt.Sprintf("/players/%s", player.ID), nil, false)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	var p Player
+	json.Unmarshal(rr.Body.Bytes(), &p)
+	// 50 for the game, 100 for the win and 10 per kill
+	if p.XP != 250 || p.Level != 3 || p.Stats.NbKills != 10 {
+		t.Errorf("handler returned unexpected player: got %+v", p)
+	}
+
+	rr = doRequest(t, "GET", fmt.Sprintf("/players/%s/levelups", player.ID), nil, false)
+	var levelUps []LevelUp
+	json.Unmarshal(rr.Body.Bytes(), &levelUps)
+	if len(levelUps) != 2 || levelUps[0].Level != 2 || levelUps[1].Level != 3 || levelUps[1].GameID != g.ID {
+		t.Errorf("handler returned unexpected level ups: got %+v", levelUps)
+	}
+
+	// A config change is recorded in the event log: the experience of the
+	// player is calculated again, but the levels he reached stay recorded,
+	// even after a rebuild
+	doRequest(t, "PUT", "/admin/xp", url.Values{"levelBase": {"1000"}}, true)
+	if pr := world.Progression[player.ID]; pr.XP != 250 || pr.Level != 1 {
+		t.Errorf("unexpected progression after a config change: got %+v", pr)
+	}
+	doRequest(t, "POST", "/admin/rebuild", nil, true)
+	rr = doRequest(t, "GET", fmt.Sprintf("/players/%s/levelups", player.ID), nil, false)
+	levelUps = nil
+	json.Unmarshal(rr.Body.Bytes(), &levelUps)
+	if len(levelUps) != 2 || world.Progression[player.ID].Level != 1 || world.XPConfig.LevelBase != 1000 {
+		t.Errorf("unexpected level ups after a rebuild: got %+v", levelUps)
+	}
+
+	rr = doRequest(t, "GET", "/players/unknown", nil, false)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusNotFound)
+	}
+}